@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -21,6 +22,38 @@ import (
 
 const maxNumResult = 20
 
+//minExactResults is how many exact prefix matches a search needs
+//before it stops falling back to typo-tolerant matches
+const minExactResults = 5
+
+//fuzzyMaxEdits returns how many typos to tolerate in a search query.
+//Short queries get fewer, since one edit away from "al" is nearly
+//every name.
+func fuzzyMaxEdits(query string) int {
+	switch n := len([]rune(query)); {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+//isTimeout returns true if a user store call failed because its query timed out
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+//storeErrorStatus returns the status code for a failed user store call:
+//504 if the store timed out, or the given status otherwise
+func storeErrorStatus(err error, status int) int {
+	if isTimeout(err) {
+		return http.StatusGatewayTimeout
+	}
+	return status
+}
+
 // UsersHandler handles all requests for the users resource
 func (context *SessionContext) UsersHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
@@ -47,8 +80,13 @@ func (context *SessionContext) UsersHandler(w http.ResponseWriter, r *http.Reque
 					User:      user,
 				}
 				//Insert decoded user into db
-				insUser, insErr := context.User.Insert(user)
-				if insErr != nil {
+				insUser, insErr := context.User.InsertContext(r.Context(), user)
+				if insErr == users.ErrEmailTaken || insErr == users.ErrUserNameTaken {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(insErr.Error()))
+					return
+				} else if insErr != nil {
+					w.WriteHeader(storeErrorStatus(insErr, http.StatusInternalServerError))
 					w.Write([]byte("Error Inserting into Database"))
 					return
 				}
@@ -59,6 +97,7 @@ func (context *SessionContext) UsersHandler(w http.ResponseWriter, r *http.Reque
 					w.Write([]byte("Error Beginning a new session"))
 					return
 				}
+				logUser(r, newState)
 
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusCreated)
@@ -74,16 +113,20 @@ func (context *SessionContext) UsersHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-//SearchUserHandler handles user search requests by finding results from Trie
+//SearchUserHandler handles user search requests by finding results from Trie.
+//By default it returns the 20 most relevant users. With a `limit` or a
+//`cursor` parameter it instead returns a UserPage of up to `limit` users
+//in name order, possibly none, and a Link header with the URL of the
+//next page, if any.
 func (context *SessionContext) SearchUserHandler(w http.ResponseWriter, r *http.Request) {
 	//Check authorization
-	sessionState := &SessionState{}
-	_, err := sessions.GetState(r, context.Key, context.Session, sessionState)
+	sessionState, err := GetSessionState(r, context)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Unauthorized session"))
 		return
 	}
+	logUser(r, sessionState)
 
 	//Get query param from api call
 	query := r.FormValue("q")
@@ -92,38 +135,133 @@ func (context *SessionContext) SearchUserHandler(w http.ResponseWriter, r *http.
 		w.Write([]byte("Search query cannot be empty"))
 		return
 	}
-	//Search for top 20 userID whose name started with query prefix
-	results, _ := context.User.FindInTrie(query, maxNumResult)
-	//Retrive user info from db
-	users, err := context.User.GetByIDs(results)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Unable to find users"))
+	if r.Form.Has("limit") || r.Form.Has("cursor") {
+		context.searchUserPage(w, r, query)
 		return
 	}
-	//sort users by username
-	sort.Slice(users, func(i, j int) bool {
-		return users[i].UserName < users[j].UserName
-	})
+	//Search for the 20 most relevant userIDs with a name starting with
+	//each word of the query, so "ada lovelace" finds Ada Lovelace
+	ranked, _ := context.User.FindRankedInTrie(query, maxNumResult)
+	results := make([]int64, len(ranked))
+	for idx, result := range ranked {
+		results[idx] = result.Value
+	}
+	//Too few exact matches, so make room for names with typos. Keys
+	//are single words, so only single word queries can have typos.
+	maxEdits := fuzzyMaxEdits(query)
+	if len(results) < minExactResults && maxEdits > 0 && len(strings.Fields(query)) == 1 {
+		fuzzy, _ := context.User.FindFuzzyInTrie(query, maxEdits, maxNumResult)
+		results = appendMissing(results, fuzzy, maxNumResult)
+	}
+	//Retrive user info from db, which keeps the most relevant first.
+	//Nothing matching isn't an error, so respond with no users.
+	found := []*users.User{}
+	if len(results) > 0 {
+		found, err = context.User.GetByIDsContext(r.Context(), results)
+		if err != nil {
+			w.WriteHeader(storeErrorStatus(err, http.StatusBadRequest))
+			w.Write([]byte("Unable to find users"))
+			return
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(found)
+}
+
+//UserPage is a page of user search results. Next is the cursor of
+//the following page, and is left out on the last page.
+type UserPage struct {
+	Users []*users.User `json:"users"`
+	Next  string        `json:"next,omitempty"`
+}
+
+//searchUserPage responds with one page of the users matching query, in
+//name order. Pages pick up after the last user of the one before, so
+//users signing up meanwhile don't shift the rest of the results.
+func (context *SessionContext) searchUserPage(w http.ResponseWriter, r *http.Request, query string) {
+	limit := maxNumResult
+	if param := r.FormValue("limit"); param != "" {
+		parsed, err := strconv.Atoi(param)
+		if err != nil || parsed < 1 || parsed > maxSearchLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Limit must be between 1 and " + strconv.Itoa(maxSearchLimit)))
+			return
+		}
+		limit = parsed
+	}
+	var afterKey string
+	var afterID int64
+	if cursor := r.FormValue("cursor"); cursor != "" {
+		var err error
+		if afterKey, afterID, err = decodeCursor(cursor); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Invalid cursor"))
+			return
+		}
+	}
+
+	//Ask for one more than the page to know if there's a next page
+	entries, _ := context.User.FindAfterInTrie(query, afterKey, afterID, limit+1)
+	page := &UserPage{Users: []*users.User{}}
+	if len(entries) > limit {
+		page.Next = encodeCursor(entries[limit-1])
+		next := r.URL.Query()
+		next.Set("limit", strconv.Itoa(limit))
+		next.Set("cursor", page.Next)
+		w.Header().Set("Link", "<"+r.URL.Path+"?"+next.Encode()+">; rel=\"next\"")
+		entries = entries[:limit]
+	}
+	if len(entries) > 0 {
+		ids := make([]int64, len(entries))
+		for idx, entry := range entries {
+			ids[idx] = entry.Value
+		}
+		found, err := context.User.GetByIDsContext(r.Context(), ids)
+		if err != nil {
+			w.WriteHeader(storeErrorStatus(err, http.StatusBadRequest))
+			w.Write([]byte("Unable to find users"))
+			return
+		}
+		page.Users = found
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
+
+//appendMissing appends the IDs in more that aren't already in ids,
+//stopping once there are max IDs
+func appendMissing(ids []int64, more []int64, max int) []int64 {
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, id := range more {
+		if len(ids) >= max {
+			break
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
 }
 
 //SpecificUserHandler handles request from a specific user with UserID
 func (context *SessionContext) SpecificUserHandler(w http.ResponseWriter, r *http.Request) {
-	sessionState := &SessionState{}
-	_, err := sessions.GetState(r, context.Key, context.Session, sessionState)
+	sessionState, err := GetSessionState(r, context)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		w.Write([]byte("Unauthorized session"))
 		return
 	}
+	logUser(r, sessionState)
 	user := sessionState.User
 
 	//u is the user param passed in by client
 	_, u := filepath.Split(r.URL.Path)
-	println(u)
 	if r.Method == http.MethodGet {
 		if u == "me" {
 			meUser := user
@@ -141,9 +279,9 @@ func (context *SessionContext) SpecificUserHandler(w http.ResponseWriter, r *htt
 		//convert string to int64 for comparison
 		uid, _ := strconv.ParseInt(u, 10, 64)
 
-		requestedUser, err := context.User.GetByID(uid)
+		requestedUser, err := context.User.GetByIDContext(r.Context(), uid)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(storeErrorStatus(err, http.StatusNotFound))
 			w.Write([]byte("User not found!"))
 			return
 		}
@@ -179,9 +317,9 @@ func (context *SessionContext) SpecificUserHandler(w http.ResponseWriter, r *htt
 			w.Write([]byte("FirstName or LastName cannot be empty"))
 			return
 		}
-		updatedUser, err := context.User.Update(user.ID, &update)
+		updatedUser, err := context.User.UpdateContext(r.Context(), user.ID, &update)
 		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(storeErrorStatus(err, http.StatusNotFound))
 			w.Write([]byte("User could not be updated."))
 			return
 		}
@@ -214,8 +352,12 @@ func (context *SessionContext) SessionsHandler(w http.ResponseWriter, r *http.Re
 				return
 			}
 			//Get user from email in credential
-			user, userError := context.User.GetByEmail(cred.Email)
-			if userError != nil {
+			user, userError := context.User.GetByEmailContext(r.Context(), cred.Email)
+			if isTimeout(userError) {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				w.Write([]byte("User store timed out"))
+				return
+			} else if userError != nil {
 				time.Sleep(1 * time.Second)
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte("invalid credentials"))
@@ -241,6 +383,7 @@ func (context *SessionContext) SessionsHandler(w http.ResponseWriter, r *http.Re
 				w.Write([]byte("Error Beginning a new session"))
 				return
 			}
+			logUser(r, newState)
 			//Encode user. HashPass and Email already defined as hidden in User struct
 
 			//respond back to client with encoded user
@@ -3,60 +3,47 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
-	"log"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/sessions"
-	"github.com/go-redis/redis"
 )
 
+//GetSessionContext returns a handler context backed by in-memory
+//session and user stores, so handler tests need no redis or MySQL
 func GetSessionContext() *SessionContext {
-
-	sessionKey, sessionKeyExists := os.LookupEnv("SESSIONKEY")
-	if !sessionKeyExists {
-		sessionKey = "thisismykey"
-	}
-	redisAddr, redisAddrExists := os.LookupEnv("REDISADDR")
-	if !redisAddrExists {
-		redisAddr = ":6379"
-	}
-	dsn, dsnExists := os.LookupEnv("DSN")
-	if !dsnExists {
-		dsn = fmt.Sprintf("root:%s@tcp(127.0.0.1:3306)/mydb", "mypassword")
-	}
-
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: redisAddr,
-	})
-	redisSession := sessions.NewRedisStore(redisClient, 3600)
-
-	userStore, postgressErr := users.ConnectToPostgres(dsn)
-	if postgressErr != nil {
-		log.Fatalf("Postgress not working")
-		os.Exit(1)
+	return &SessionContext{
+		Key:     "thisismykey",
+		Session: sessions.NewMemStore(time.Hour, time.Minute),
+		User:    users.NewMemStore(),
 	}
+}
 
-	handlerContext := &SessionContext{
-		Key:     sessionKey,
-		Session: redisSession,
-		User:    userStore,
+//beginTestSession starts a session for the user and returns its Authorization header
+func beginTestSession(t *testing.T, context *SessionContext, user *users.User) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	state := &SessionState{StartTime: time.Now(), User: user}
+	if _, err := sessions.BeginSession(context.Key, context.Session, state, rr); err != nil {
+		t.Fatalf("error beginning session: %v", err)
 	}
-	return handlerContext
+	return rr.Header().Get("Authorization")
 }
 
 func TestUsersHandler(t *testing.T) {
 	cases := []struct {
+		name           string
 		request        string
 		contentType    string
 		newUser        *users.NewUser
 		expectedStatus int
 	}{
 		{
+			"Valid New User",
 			"POST",
 			"application/json",
 			&users.NewUser{
@@ -67,7 +54,33 @@ func TestUsersHandler(t *testing.T) {
 				FirstName:    "Ziyu",
 				LastName:     "Guo",
 			},
-			200,
+			http.StatusCreated,
+		},
+		{
+			"Invalid New User",
+			"POST",
+			"application/json",
+			&users.NewUser{
+				Email:        "gzy123@uw.edu",
+				Password:     "password",
+				PasswordConf: "different",
+				UserName:     "ziyuguo",
+			},
+			http.StatusBadRequest,
+		},
+		{
+			"Wrong Content Type",
+			"POST",
+			"text/plain",
+			&users.NewUser{},
+			http.StatusUnsupportedMediaType,
+		},
+		{
+			"Wrong Method",
+			"PUT",
+			"application/json",
+			&users.NewUser{},
+			http.StatusMethodNotAllowed,
 		},
 	}
 
@@ -75,7 +88,7 @@ func TestUsersHandler(t *testing.T) {
 		jsonUser, _ := json.Marshal(c.newUser)
 		req, err := http.NewRequest(c.request, "/", bytes.NewBuffer(jsonUser))
 		if err != nil {
-			t.Fatalf(err.Error())
+			t.Fatal(err)
 		}
 		req.Header.Add("Content-Type", c.contentType)
 		handlerContext := GetSessionContext()
@@ -83,9 +96,210 @@ func TestUsersHandler(t *testing.T) {
 		handler := http.HandlerFunc(handlerContext.UsersHandler)
 
 		handler.ServeHTTP(rr, req)
-		if status := rr.Code; status != http.StatusOK {
-			t.Errorf("Handler returned wrong status code: got %v, wanted %v",
-				status, http.StatusOK)
+		if status := rr.Code; status != c.expectedStatus {
+			t.Errorf("case %s: Handler returned wrong status code: got %v, wanted %v",
+				c.name, status, c.expectedStatus)
+		}
+		if c.expectedStatus == http.StatusCreated && rr.Header().Get("Authorization") == "" {
+			t.Errorf("case %s: no session started for the new user", c.name)
 		}
 	}
 }
+
+func TestUsersHandlerDuplicateEmail(t *testing.T) {
+	handlerContext := GetSessionContext()
+	handlerContext.User.Insert(&users.User{Email: "gzy123@uw.edu", UserName: "existing"})
+
+	jsonUser, _ := json.Marshal(&users.NewUser{
+		Email:        "gzy123@uw.edu",
+		Password:     "password",
+		PasswordConf: "password",
+		UserName:     "ziyuguo",
+	})
+	req := httptest.NewRequest("POST", "/v1/users", bytes.NewBuffer(jsonUser))
+	req.Header.Add("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handlerContext.UsersHandler(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Handler returned wrong status code: got %v, wanted %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSearchUserHandler(t *testing.T) {
+	handlerContext := GetSessionContext()
+	ada, _ := handlerContext.User.Insert(&users.User{Email: "ada@uw.edu", UserName: "ada", FirstName: "Ada", LastName: "Lovelace"})
+	handlerContext.User.Insert(&users.User{Email: "alan@uw.edu", UserName: "alan", FirstName: "Alan", LastName: "Turing"})
+	auth := beginTestSession(t, handlerContext, ada)
+
+	cases := []struct {
+		name           string
+		query          string
+		auth           string
+		expectedStatus int
+		expectedNames  []string
+	}{
+		{"Prefix Match", "a", auth, http.StatusOK, []string{"ada", "alan"}},
+		{"Last Name Match", "tur", auth, http.StatusOK, []string{"alan"}},
+		{"Typo Match", "lovleace", auth, http.StatusOK, []string{"ada"}},
+		{"Full Name", "ada%20lovelace", auth, http.StatusOK, []string{"ada"}},
+		{"Full Name Prefixes", "a+t", auth, http.StatusOK, []string{"alan"}},
+		{"Full Name No Match", "ada+turing", auth, http.StatusOK, []string{}},
+		{"No Match", "zzz", auth, http.StatusOK, []string{}},
+		{"No Match Or Typo", "qx", auth, http.StatusOK, []string{}},
+		{"Case And Accent Insensitive", "LOV%C3%89", auth, http.StatusOK, []string{"ada"}},
+		{"Short Query No Typos", "ad", auth, http.StatusOK, []string{"ada"}},
+		{"Empty Query", "", auth, http.StatusBadRequest, nil},
+		{"No Session", "a", "", http.StatusUnauthorized, nil},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/v1/users?q="+c.query, nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rr := httptest.NewRecorder()
+		handlerContext.UsersHandler(rr, req)
+
+		if rr.Code != c.expectedStatus {
+			t.Errorf("case %s: Handler returned wrong status code: got %v, wanted %v", c.name, rr.Code, c.expectedStatus)
+			continue
+		}
+		if c.expectedStatus != http.StatusOK {
+			continue
+		}
+		//no matches is an empty list rather than null
+		if len(c.expectedNames) == 0 && strings.TrimSpace(rr.Body.String()) != "[]" {
+			t.Errorf("case %s: expected [] but got %s", c.name, rr.Body.String())
+			continue
+		}
+		var found []*users.User
+		if err := json.NewDecoder(rr.Body).Decode(&found); err != nil {
+			t.Fatalf("case %s: error decoding response: %v", c.name, err)
+		}
+		if len(found) != len(c.expectedNames) {
+			t.Errorf("case %s: expected %d users but got %d", c.name, len(c.expectedNames), len(found))
+			continue
+		}
+		for idx, name := range c.expectedNames {
+			if found[idx].UserName != name {
+				t.Errorf("case %s: expected %s at %d but got %s", c.name, name, idx, found[idx].UserName)
+			}
+		}
+	}
+}
+
+func TestSearchUserHandlerRanking(t *testing.T) {
+	handlerContext := GetSessionContext()
+	alice, _ := handlerContext.User.Insert(&users.User{Email: "alice@uw.edu", UserName: "alice", FirstName: "Alice", LastName: "Smith"})
+	handlerContext.User.Insert(&users.User{Email: "zed@uw.edu", UserName: "zed", FirstName: "Al", LastName: "Jones"})
+	handlerContext.User.Insert(&users.User{Email: "bob@uw.edu", UserName: "bob", FirstName: "Bob", LastName: "Alderman"})
+	auth := beginTestSession(t, handlerContext, alice)
+
+	req := httptest.NewRequest("GET", "/v1/users?q=al", nil)
+	req.Header.Set("Authorization", auth)
+	rr := httptest.NewRecorder()
+	handlerContext.UsersHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v, wanted %v", rr.Code, http.StatusOK)
+	}
+
+	var found []*users.User
+	if err := json.NewDecoder(rr.Body).Decode(&found); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	//exact first name, then username prefix, then last name prefix
+	expected := []string{"zed", "alice", "bob"}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %d users but got %d", len(expected), len(found))
+	}
+	for idx, name := range expected {
+		if found[idx].UserName != name {
+			t.Errorf("expected %s at %d but got %s", name, idx, found[idx].UserName)
+		}
+	}
+}
+
+func TestSearchUserHandlerPagination(t *testing.T) {
+	handlerContext := GetSessionContext()
+	ada, _ := handlerContext.User.Insert(&users.User{Email: "ada@uw.edu", UserName: "ada", FirstName: "Ada", LastName: "Lovelace"})
+	for _, name := range []string{"alan", "alice", "anita", "april"} {
+		handlerContext.User.Insert(&users.User{Email: name + "@uw.edu", UserName: name, FirstName: name, LastName: "Smith"})
+	}
+	auth := beginTestSession(t, handlerContext, ada)
+
+	search := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		req.Header.Set("Authorization", auth)
+		rr := httptest.NewRecorder()
+		handlerContext.UsersHandler(rr, req)
+		return rr
+	}
+
+	//follow the next cursors until there are none
+	var names []string
+	pages := 0
+	for url := "/v1/users?q=a&limit=2"; url != ""; pages++ {
+		rr := search(url)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Handler returned wrong status code: got %v, wanted %v", rr.Code, http.StatusOK)
+		}
+		link := rr.Header().Get("Link")
+		var page map[string]json.RawMessage
+		if err := json.NewDecoder(rr.Body).Decode(&page); err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+		var found []*users.User
+		if err := json.Unmarshal(page["users"], &found); err != nil {
+			t.Fatalf("error decoding users: %v", err)
+		}
+		for _, user := range found {
+			names = append(names, user.UserName)
+		}
+		url = ""
+		if raw, ok := page["next"]; ok {
+			var next string
+			if err := json.Unmarshal(raw, &next); err != nil || next == "" {
+				t.Fatalf("unexpected next cursor %s", raw)
+			}
+			url = "/v1/users?cursor=" + next + "&limit=2&q=a"
+			if link != "<"+url+`>; rel="next"` {
+				t.Errorf("expected the Link header to match the next cursor but got %s", link)
+			}
+		} else if link != "" {
+			t.Errorf("unexpected Link header on the last page %s", link)
+		}
+		//a sign up before the cursor doesn't shift the later pages
+		if pages == 0 {
+			handlerContext.User.Insert(&users.User{Email: "aaron@uw.edu", UserName: "aaron", FirstName: "Aaron", LastName: "Smith"})
+		}
+	}
+	expected := []string{"ada", "alan", "alice", "anita", "april"}
+	if pages != 3 || strings.Join(names, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v over 3 pages but got %v over %d", expected, names, pages)
+	}
+
+	cases := []struct {
+		name           string
+		url            string
+		expectedStatus int
+	}{
+		{"Limit Too Small", "/v1/users?q=a&limit=0", http.StatusBadRequest},
+		{"Limit Too Large", "/v1/users?q=a&limit=101", http.StatusBadRequest},
+		{"Limit Not A Number", "/v1/users?q=a&limit=ten", http.StatusBadRequest},
+		{"Invalid Cursor", "/v1/users?q=a&cursor=%21%21", http.StatusBadRequest},
+		{"Truncated Cursor", "/v1/users?q=a&cursor=Ag", http.StatusBadRequest},
+		{"No Matches", "/v1/users?q=zzz&limit=2", http.StatusOK},
+	}
+	for _, c := range cases {
+		if rr := search(c.url); rr.Code != c.expectedStatus {
+			t.Errorf("case %s: Handler returned wrong status code: got %v, wanted %v", c.name, rr.Code, c.expectedStatus)
+		}
+	}
+
+	//an empty page is still an object with a list of users
+	rr := search("/v1/users?q=zzz&limit=2")
+	if body := strings.TrimSpace(rr.Body.String()); body != `{"users":[]}` {
+		t.Errorf("expected an empty page but got %s", body)
+	}
+}
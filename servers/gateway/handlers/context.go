@@ -18,9 +18,9 @@ import (
 
 //SessionContext captures the signing key, session and user info
 type SessionContext struct {
-	Key     string               `json:"-"`
-	Session *sessions.RedisStore `json:"session"`
-	User    users.Store          `json:"user"`
+	Key     string         `json:"-"`
+	Session sessions.Store `json:"session"`
+	User    users.Store    `json:"user"`
 }
 
 //WebsocketContext stores map of userid to websocket conn and a thread safe lock
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"unicode/utf8"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
+)
+
+//maxSearchLimit is the largest page a search can ask for with `limit`
+const maxSearchLimit = 100
+
+//errInvalidCursor is returned when a search cursor can't be decoded
+var errInvalidCursor = errors.New("invalid cursor")
+
+//encodeCursor returns an opaque cursor for the page after the given
+//entry. Clients should only pass it back, never build or parse it.
+func encodeCursor(entry indexes.Entry) string {
+	buf := binary.AppendVarint(nil, entry.Value)
+	buf = append(buf, entry.Key...)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+//decodeCursor returns the key and ID of the entry an encoded cursor is after
+func decodeCursor(cursor string) (string, int64, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, errInvalidCursor
+	}
+	id, n := binary.Varint(buf)
+	if n <= 0 || n == len(buf) || !utf8.Valid(buf[n:]) {
+		return "", 0, errInvalidCursor
+	}
+	return string(buf[n:]), id, nil
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//unmatchedRoute labels requests no route matches, so unknown paths
+//don't each get their own series
+const unmatchedRoute = "unmatched"
+
+//httpRequestDuration times the gateway's responses by route and status
+var httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gateway",
+	Subsystem: "http",
+	Name:      "request_duration_seconds",
+	Help:      "Time to respond to each request, by route, method and status.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"route", "method", "status"})
+
+//websocketConnections is the number of entries in WebsocketContext.Connections
+var websocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: "gateway",
+	Subsystem: "websocket",
+	Name:      "connections",
+	Help:      "Open websocket connections, one per user.",
+})
+
+//rabbitMessagesConsumed counts the messages read from RabbitMQ by
+//whether they could be decoded
+var rabbitMessagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gateway",
+	Subsystem: "rabbit",
+	Name:      "messages_consumed_total",
+	Help:      "Messages consumed from RabbitMQ, by result.",
+}, []string{"result"})
+
+//RequestMetrics records the duration of the gateway's responses
+type RequestMetrics struct {
+	//Route returns the pattern a request matches, or "" if none does
+	Route func(r *http.Request) string
+}
+
+//NewRequestMetrics constructs a new RequestMetrics labelling requests
+//with the given routes
+func NewRequestMetrics(route func(r *http.Request) string) *RequestMetrics {
+	return &RequestMetrics{Route: route}
+}
+
+//Observe records a response to the request with the status, which took latency
+func (m *RequestMetrics) Observe(r *http.Request, status int, latency time.Duration) {
+	route := m.Route(r)
+	if len(route) == 0 {
+		route = unmatchedRoute
+	}
+	httpRequestDuration.WithLabelValues(route, r.Method, strconv.Itoa(status)).Observe(latency.Seconds())
+}
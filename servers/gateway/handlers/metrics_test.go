@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/streadway/amqp"
+)
+
+// observations returns how many times the histogram has been observed
+func observations(t *testing.T, observer prometheus.Observer) uint64 {
+	metric := &dto.Metric{}
+	if err := observer.(prometheus.Metric).Write(metric); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestRequestMetrics(t *testing.T) {
+	metrics := NewRequestMetrics(func(r *http.Request) string {
+		if r.URL.Path == "/v1/users/1" {
+			return "/v1/users/"
+		}
+		return ""
+	})
+	cases := []struct {
+		name   string
+		path   string
+		status int
+		route  string
+	}{
+		{"Matched Route", "/v1/users/1", http.StatusOK, "/v1/users/"},
+		{"Unmatched Route", "/wp-login.php", http.StatusNotFound, unmatchedRoute},
+	}
+	for _, c := range cases {
+		histogram := httpRequestDuration.WithLabelValues(c.route, http.MethodGet, strconv.Itoa(c.status))
+		before := observations(t, histogram)
+		metrics.Observe(httptest.NewRequest(http.MethodGet, c.path, nil), c.status, 10*time.Millisecond)
+		if after := observations(t, histogram); after != before+1 {
+			t.Errorf("case %s: expected the request to be observed under %s", c.name, c.route)
+		}
+	}
+}
+
+func TestWebsocketConnectionsGauge(t *testing.T) {
+	wsc := &WebsocketContext{Connections: map[int]*websocket.Conn{}, Lock: &sync.Mutex{}}
+	wsc.InsertConnection(nil, 1)
+	wsc.InsertConnection(nil, 2)
+	//a user's new connection replaces their old one
+	wsc.InsertConnection(nil, 2)
+	if count := testutil.ToFloat64(websocketConnections); count != 2 {
+		t.Errorf("expected 2 connections but got %v", count)
+	}
+	wsc.RemoveConnection(1)
+	wsc.RemoveConnection(2)
+	if count := testutil.ToFloat64(websocketConnections); count != 0 {
+		t.Errorf("expected no connections but got %v", count)
+	}
+}
+
+func TestRabbitMessagesConsumed(t *testing.T) {
+	wsc := &WebsocketContext{Connections: map[int]*websocket.Conn{}, Lock: &sync.Mutex{}}
+	malformed := testutil.ToFloat64(rabbitMessagesConsumed.WithLabelValues("malformed"))
+	delivered := testutil.ToFloat64(rabbitMessagesConsumed.WithLabelValues("delivered"))
+	msgs := make(chan amqp.Delivery, 3)
+	msgs <- amqp.Delivery{Body: []byte(`{"type":"message-new"}`)}
+	//a malformed message must not stop the ones after it
+	msgs <- amqp.Delivery{Body: []byte(`not json`)}
+	msgs <- amqp.Delivery{Body: []byte(`{"type":"message-new"}`)}
+	close(msgs)
+
+	done := make(chan struct{})
+	go func() {
+		wsc.consumeRabbitMessages(msgs)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the consumer to return once its channel closed")
+	}
+	if count := testutil.ToFloat64(rabbitMessagesConsumed.WithLabelValues("malformed")) - malformed; count != 1 {
+		t.Errorf("expected 1 malformed message but got %v", count)
+	}
+	if count := testutil.ToFloat64(rabbitMessagesConsumed.WithLabelValues("delivered")) - delivered; count != 2 {
+		t.Errorf("expected 2 delivered messages but got %v", count)
+	}
+}
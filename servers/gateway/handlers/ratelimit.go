@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/requestlog"
+)
+
+//RateLimitClass limits the requests clients can make to a class of
+//routes. Each client has a bucket of Limit tokens that refills every
+//Window, and every request takes one.
+type RateLimitClass struct {
+	Name string
+	//Prefixes are the paths in the class, along with every path below
+	//them. A class without prefixes has every path.
+	Prefixes []string
+	//Methods are the methods in the class, or every method if empty
+	Methods []string
+	Limit   int
+	Window  time.Duration
+}
+
+//matches returns true if the request is in the class
+func (c *RateLimitClass) matches(r *http.Request) bool {
+	if len(c.Methods) != 0 {
+		found := false
+		for _, method := range c.Methods {
+			found = found || method == r.Method
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(c.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.Prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+//RateLimiter wraps an HTTP handler, responding with 429 to clients
+//that make more requests than their class allows. Signed in users are
+//limited by their ID, everyone else by their IP address.
+type RateLimiter struct {
+	Context *SessionContext
+	Store   RateLimitStore
+	//Classes are tried in order, and the first one matching a request
+	//limits it. Requests no class matches aren't limited.
+	Classes []RateLimitClass
+
+	handler http.Handler
+	now     func() time.Time
+}
+
+//NewRateLimiter initializes a new RateLimiter of the given HTTP handler
+func NewRateLimiter(handler http.Handler, context *SessionContext, store RateLimitStore, classes []RateLimitClass) *RateLimiter {
+	return &RateLimiter{
+		Context: context,
+		Store:   store,
+		Classes: classes,
+		handler: handler,
+		now:     time.Now,
+	}
+}
+
+//ServeHTTP serves HTTP if the client hasn't made too many requests,
+//with the standard RateLimit headers describing its limit
+func (rl *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var class *RateLimitClass
+	for idx := range rl.Classes {
+		if rl.Classes[idx].matches(r) {
+			class = &rl.Classes[idx]
+			break
+		}
+	}
+	if class == nil {
+		rl.handler.ServeHTTP(w, r)
+		return
+	}
+
+	key, r := rl.clientKey(r)
+	bucket, err := rl.Store.Take(class.Name+":"+key, class.Limit, class.Window, rl.now())
+	if err != nil {
+		//an outage of the store shouldn't take the gateway down with it
+		requestlog.Logger(r.Context()).Warn("Failed to rate limit request", "error", err)
+		rl.handler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(class.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(bucket.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(bucket.Reset)))
+	w.Header().Set("RateLimit-Policy", strconv.Itoa(class.Limit)+";w="+strconv.Itoa(ceilSeconds(class.Window)))
+	if !bucket.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(bucket.RetryAfter)))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	rl.handler.ServeHTTP(w, r)
+}
+
+//clientKey returns the key of the user making the request, or their
+//IP address if they aren't signed in, along with the request carrying
+//its session for the handlers after it. The gateway terminates client
+//connections itself, so X-Forwarded-For is never trusted.
+func (rl *RateLimiter) clientKey(r *http.Request) (string, *http.Request) {
+	if rl.Context != nil {
+		var sessionState *SessionState
+		var err error
+		r, sessionState, err = withSessionState(r, rl.Context)
+		if err == nil && sessionState.User != nil {
+			logUser(r, sessionState)
+			return "user:" + strconv.FormatInt(sessionState.User.ID, 10), r
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host, r
+}
+
+//ceilSeconds returns the duration in whole seconds, rounded up
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/sessions"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+//testRateLimitClasses limits signing in harder than everything else
+var testRateLimitClasses = []RateLimitClass{
+	{Name: "auth", Prefixes: []string{"/v1/sessions"}, Methods: []string{http.MethodPost}, Limit: 2, Window: time.Minute},
+	{Name: "default", Prefixes: []string{"/v1/"}, Limit: 3, Window: time.Minute},
+}
+
+//newTestRateLimiter returns a rate limiter of a handler that always
+//responds with 200, and a function to move its clock forward
+func newTestRateLimiter(context *SessionContext, store RateLimitStore) (*RateLimiter, func(time.Duration)) {
+	rl := NewRateLimiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), context, store, testRateLimitClasses)
+	now := time.Now()
+	rl.now = func() time.Time { return now }
+	return rl, func(d time.Duration) { now = now.Add(d) }
+}
+
+//rateLimitedRequest makes a request from the address with the Authorization header
+func rateLimitedRequest(rl *RateLimiter, method string, path string, remoteAddr string, auth string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = remoteAddr
+	if len(auth) != 0 {
+		req.Header.Set("Authorization", auth)
+	}
+	rr := httptest.NewRecorder()
+	rl.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRateLimiter(t *testing.T) {
+	context := GetSessionContext()
+	rl, advance := newTestRateLimiter(context, NewMemRateLimitStore())
+
+	for i := 0; i < 3; i++ {
+		rr := rateLimitedRequest(rl, http.MethodGet, "/v1/channels", "1.2.3.4:5000", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected %d but got %d", i, http.StatusOK, rr.Code)
+		}
+		if remaining := rr.Header().Get("RateLimit-Remaining"); remaining != string(rune('2'-i)) {
+			t.Errorf("request %d: expected %d remaining but got %s", i, 2-i, remaining)
+		}
+	}
+	rr := rateLimitedRequest(rl, http.MethodGet, "/v1/channels/1", "1.2.3.4:6000", "")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d once the limit is used up but got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	expectedHeaders := map[string]string{
+		"RateLimit-Limit":     "3",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"RateLimit-Policy":    "3;w=60",
+		"Retry-After":         "20",
+	}
+	for header, expected := range expectedHeaders {
+		if got := rr.Header().Get(header); got != expected {
+			t.Errorf("expected %s %s but got %s", header, expected, got)
+		}
+	}
+
+	//other clients and classes have their own buckets
+	if rr := rateLimitedRequest(rl, http.MethodGet, "/v1/channels", "5.6.7.8:5000", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected another IP to be allowed but got %d", rr.Code)
+	}
+	if rr := rateLimitedRequest(rl, http.MethodPost, "/v1/sessions", "1.2.3.4:5000", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected another class to be allowed but got %d", rr.Code)
+	}
+	//requests no class matches aren't limited
+	rr = rateLimitedRequest(rl, http.MethodGet, "/healthz", "1.2.3.4:5000", "")
+	if rr.Code != http.StatusOK || len(rr.Header().Get("RateLimit-Limit")) != 0 {
+		t.Errorf("expected an unlimited request without headers but got %d %v", rr.Code, rr.Header())
+	}
+
+	//a token comes back every 20 seconds
+	advance(20 * time.Second)
+	if rr := rateLimitedRequest(rl, http.MethodGet, "/v1/channels", "1.2.3.4:5000", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected a refilled token to be allowed but got %d", rr.Code)
+	}
+	if rr := rateLimitedRequest(rl, http.MethodGet, "/v1/channels", "1.2.3.4:5000", ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected only one token to be refilled but got %d", rr.Code)
+	}
+}
+
+func TestRateLimiterKeysOnUser(t *testing.T) {
+	context := GetSessionContext()
+	rl, _ := newTestRateLimiter(context, NewMemRateLimitStore())
+	auth := beginTestSession(t, context, &users.User{ID: 1, UserName: "ada"})
+	otherDevice := beginTestSession(t, context, &users.User{ID: 1, UserName: "ada"})
+
+	//the same user is limited across addresses and sessions
+	rateLimitedRequest(rl, http.MethodPost, "/v1/sessions", "1.2.3.4:5000", auth)
+	rateLimitedRequest(rl, http.MethodPost, "/v1/sessions", "5.6.7.8:5000", otherDevice)
+	if rr := rateLimitedRequest(rl, http.MethodPost, "/v1/sessions", "9.9.9.9:5000", auth); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the user to be limited across addresses but got %d", rr.Code)
+	}
+	//while anonymous clients at the same address aren't affected
+	if rr := rateLimitedRequest(rl, http.MethodPost, "/v1/sessions", "1.2.3.4:5000", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected the address to have its own bucket but got %d", rr.Code)
+	}
+	//and an invalid session is limited by address
+	if rr := rateLimitedRequest(rl, http.MethodPost, "/v1/sessions", "1.2.3.4:5000", "Bearer invalid"); rr.Code != http.StatusOK {
+		t.Errorf("expected an invalid session to share the address's bucket but got %d", rr.Code)
+	}
+	if rr := rateLimitedRequest(rl, http.MethodPost, "/v1/sessions", "1.2.3.4:5000", ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the address's bucket to be used up but got %d", rr.Code)
+	}
+}
+
+//countingSessionStore counts the session states read from a store
+type countingSessionStore struct {
+	sessions.Store
+	gets int
+}
+
+func (cs *countingSessionStore) Get(sid sessions.SessionID, sessionState interface{}) error {
+	cs.gets++
+	return cs.Store.Get(sid, sessionState)
+}
+
+func TestRateLimiterSharesSession(t *testing.T) {
+	context := GetSessionContext()
+	store := &countingSessionStore{Store: context.Session}
+	context.Session = store
+	auth := beginTestSession(t, context, &users.User{ID: 1, UserName: "ada"})
+	var found *SessionState
+	rl := NewRateLimiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		found, _ = GetSessionState(r, context)
+	}), context, NewMemRateLimitStore(), testRateLimitClasses)
+
+	rateLimitedRequest(rl, http.MethodGet, "/v1/channels", "1.2.3.4:5000", auth)
+	if store.gets != 1 {
+		t.Errorf("expected the session to be read once but it was read %d times", store.gets)
+	}
+	if found == nil || found.User.ID != 1 {
+		t.Errorf("expected the handler to get the rate limiter's session but got %+v", found)
+	}
+	//requests the limiter lets through untouched look the session up themselves
+	rateLimitedRequest(rl, http.MethodGet, "/healthz", "1.2.3.4:5000", auth)
+	if store.gets != 2 {
+		t.Errorf("expected the handler to read the session but it was read %d times", store.gets-1)
+	}
+}
+
+//failingRateLimitStore always fails
+type failingRateLimitStore struct {
+	takes int
+}
+
+func (fs *failingRateLimitStore) Take(key string, limit int, window time.Duration, now time.Time) (*Bucket, error) {
+	fs.takes++
+	return nil, errors.New("connection refused")
+}
+
+func TestRateLimiterStoreFailure(t *testing.T) {
+	rl, _ := newTestRateLimiter(nil, &failingRateLimitStore{})
+	for i := 0; i < 5; i++ {
+		if rr := rateLimitedRequest(rl, http.MethodGet, "/v1/channels", "1.2.3.4:5000", ""); rr.Code != http.StatusOK {
+			t.Fatalf("expected requests to be let through when the store fails but got %d", rr.Code)
+		}
+	}
+}
+
+//testRateLimitStore checks the token bucket behavior of a store
+func testRateLimitStore(t *testing.T, store RateLimitStore) {
+	now := time.Now()
+	window := 10 * time.Second
+	for i := 0; i < 5; i++ {
+		bucket, err := store.Take("a", 5, window, now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bucket.Allowed || bucket.Remaining != 4-i {
+			t.Fatalf("take %d: expected %d remaining but got %+v", i, 4-i, bucket)
+		}
+	}
+	bucket, _ := store.Take("a", 5, window, now)
+	if bucket.Allowed || bucket.Remaining != 0 || bucket.Reset != window || bucket.RetryAfter != 2*time.Second {
+		t.Errorf("expected an empty bucket but got %+v", bucket)
+	}
+	if bucket, _ := store.Take("b", 5, window, now); !bucket.Allowed {
+		t.Errorf("expected another key to have its own bucket")
+	}
+
+	//half a token isn't enough
+	bucket, _ = store.Take("a", 5, window, now.Add(time.Second))
+	if bucket.Allowed || bucket.RetryAfter != time.Second {
+		t.Errorf("expected a partly refilled bucket to reject but got %+v", bucket)
+	}
+	bucket, _ = store.Take("a", 5, window, now.Add(5*time.Second))
+	if !bucket.Allowed || bucket.Remaining != 1 {
+		t.Errorf("expected 2.5 tokens to be refilled but got %+v", bucket)
+	}
+	//a bucket never holds more than its limit
+	bucket, _ = store.Take("a", 5, window, now.Add(time.Hour))
+	if !bucket.Allowed || bucket.Remaining != 4 || bucket.Reset != 2*time.Second {
+		t.Errorf("expected a full bucket but got %+v", bucket)
+	}
+	//a clock behind the last take doesn't add or remove tokens
+	bucket, _ = store.Take("a", 5, window, now)
+	if !bucket.Allowed || bucket.Remaining != 3 {
+		t.Errorf("expected a late take to use the current tokens but got %+v", bucket)
+	}
+}
+
+func TestMemRateLimitStore(t *testing.T) {
+	store := NewMemRateLimitStore()
+	testRateLimitStore(t, store)
+
+	//full buckets are forgotten
+	now := time.Now().Add(2 * time.Hour)
+	for i := 0; i < sweepEvery; i++ {
+		store.Take("c", 5, time.Second, now)
+	}
+	if len(store.buckets) != 1 {
+		t.Errorf("expected only the bucket in use to be kept but got %d", len(store.buckets))
+	}
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisRateLimitStore(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+	testRateLimitStore(t, store)
+
+	if ttl := server.TTL("ratelimit:a"); ttl <= 0 || ttl > 10*time.Second {
+		t.Errorf("expected buckets to expire once full again but got TTL %v", ttl)
+	}
+}
+
+func TestFallbackRateLimitStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	primary := NewRedisRateLimitStore(redis.NewClient(&redis.Options{Addr: server.Addr()}))
+	fallback := NewMemRateLimitStore()
+	store := NewFallbackRateLimitStore(primary, fallback)
+	now := time.Now()
+
+	store.Take("a", 2, time.Minute, now)
+	if len(fallback.buckets) != 0 {
+		t.Fatalf("expected the primary store to be used while it works")
+	}
+
+	server.Close()
+	bucket, err := store.Take("a", 2, time.Minute, now)
+	if err != nil || !bucket.Allowed || bucket.Remaining != 1 {
+		t.Fatalf("expected the fallback store to take over but got %+v, %v", bucket, err)
+	}
+
+	//the primary isn't tried again until RetryAfter
+	failing := &failingRateLimitStore{}
+	store.Primary = failing
+	store.Take("a", 2, time.Minute, now.Add(time.Second))
+	if failing.takes != 0 {
+		t.Errorf("expected the failed primary not to be retried yet")
+	}
+	store.Take("a", 2, time.Minute, now.Add(store.RetryAfter))
+	if failing.takes != 1 {
+		t.Errorf("expected the primary to be retried after %v", store.RetryAfter)
+	}
+}
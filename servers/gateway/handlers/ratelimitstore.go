@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//errUnexpectedReply is returned when redis replies to the rate limit
+//script with something it doesn't return
+var errUnexpectedReply = errors.New("unexpected reply from rate limit script")
+
+//Bucket is the state of a token bucket after a request took from it
+type Bucket struct {
+	Allowed   bool
+	Remaining int
+	//Reset is how long until the bucket is full again
+	Reset time.Duration
+	//RetryAfter is how long until a rejected request would be allowed
+	RetryAfter time.Duration
+}
+
+//newBucket returns the state of a bucket refilling limit tokens every
+//window that has tokens left after a request
+func newBucket(allowed bool, tokens float64, limit int, window time.Duration) *Bucket {
+	perToken := float64(window) / float64(limit)
+	bucket := &Bucket{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration(math.Ceil((float64(limit) - tokens) * perToken)),
+	}
+	if !allowed {
+		bucket.RetryAfter = time.Duration(math.Ceil((1 - tokens) * perToken))
+	}
+	return bucket
+}
+
+//RateLimitStore keeps the token buckets of rate limited clients
+type RateLimitStore interface {
+	//Take takes a token from the bucket with the key, which holds
+	//limit tokens and refills them all every window
+	Take(key string, limit int, window time.Duration, now time.Time) (*Bucket, error)
+}
+
+//memBucket is a token bucket kept in memory
+type memBucket struct {
+	tokens float64
+	last   time.Time
+	window time.Duration
+	limit  int
+}
+
+//refill adds the tokens earned since the bucket was last used
+func (b *memBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit), b.tokens+float64(b.limit)*float64(elapsed)/float64(b.window))
+		b.last = now
+	}
+}
+
+//sweepEvery is how many takes a MemRateLimitStore makes between
+//sweeps of the buckets that are full again
+const sweepEvery = 1024
+
+//MemRateLimitStore keeps token buckets in memory, so each gateway
+//replica limits clients on its own
+type MemRateLimitStore struct {
+	mx      sync.Mutex
+	buckets map[string]*memBucket
+	takes   int
+}
+
+//NewMemRateLimitStore constructs a new MemRateLimitStore
+func NewMemRateLimitStore() *MemRateLimitStore {
+	return &MemRateLimitStore{buckets: map[string]*memBucket{}}
+}
+
+//Take takes a token from the bucket with the key
+func (ms *MemRateLimitStore) Take(key string, limit int, window time.Duration, now time.Time) (*Bucket, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	ms.takes++
+	if ms.takes%sweepEvery == 0 {
+		ms.sweep(now)
+	}
+
+	b, found := ms.buckets[key]
+	if !found || b.limit != limit || b.window != window {
+		b = &memBucket{tokens: float64(limit), last: now, window: window, limit: limit}
+		ms.buckets[key] = b
+	}
+	b.refill(now)
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newBucket(allowed, b.tokens, limit, window), nil
+}
+
+//sweep forgets the buckets that are full again, since a new bucket
+//is the same as a full one
+func (ms *MemRateLimitStore) sweep(now time.Time) {
+	for key, b := range ms.buckets {
+		if b.refill(now); b.tokens >= float64(b.limit) {
+			delete(ms.buckets, key)
+		}
+	}
+}
+
+//takeScript atomically refills and takes from a token bucket stored in
+//a hash of its tokens and when they were last refilled, in ms
+var takeScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(state[1])
+local last = tonumber(state[2])
+if tokens == nil or last == nil then
+	tokens = limit
+	last = now
+end
+if now > last then
+	tokens = math.min(limit, tokens + (now - last) * limit / window)
+	last = now
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'last', last)
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, tostring(tokens)}
+`)
+
+//RedisRateLimitStore keeps token buckets in redis, so every gateway
+//replica shares them
+type RedisRateLimitStore struct {
+	Client *redis.Client
+}
+
+//NewRedisRateLimitStore constructs a new RedisRateLimitStore
+func NewRedisRateLimitStore(client *redis.Client) *RedisRateLimitStore {
+	return &RedisRateLimitStore{Client: client}
+}
+
+//Take takes a token from the bucket with the key
+func (rs *RedisRateLimitStore) Take(key string, limit int, window time.Duration, now time.Time) (*Bucket, error) {
+	result, err := takeScript.Run(rs.Client, []string{"ratelimit:" + key},
+		limit, window.Milliseconds(), now.UnixNano()/int64(time.Millisecond)).Result()
+	if err != nil {
+		return nil, err
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, errUnexpectedReply
+	}
+	allowed, _ := values[0].(int64)
+	encodedTokens, _ := values[1].(string)
+	tokens, err := strconv.ParseFloat(encodedTokens, 64)
+	if err != nil {
+		return nil, errUnexpectedReply
+	}
+	return newBucket(allowed == 1, tokens, limit, window), nil
+}
+
+//FallbackRateLimitStore uses the Primary store, and the Fallback store
+//for RetryAfter once the primary fails
+type FallbackRateLimitStore struct {
+	Primary    RateLimitStore
+	Fallback   RateLimitStore
+	RetryAfter time.Duration
+
+	mx        sync.Mutex
+	downUntil time.Time
+}
+
+//NewFallbackRateLimitStore constructs a new FallbackRateLimitStore
+//that tries the primary store again 10 seconds after it fails
+func NewFallbackRateLimitStore(primary RateLimitStore, fallback RateLimitStore) *FallbackRateLimitStore {
+	return &FallbackRateLimitStore{
+		Primary:    primary,
+		Fallback:   fallback,
+		RetryAfter: 10 * time.Second,
+	}
+}
+
+//Take takes a token from the bucket with the key in the primary
+//store, or the fallback store if the primary is failing
+func (fs *FallbackRateLimitStore) Take(key string, limit int, window time.Duration, now time.Time) (*Bucket, error) {
+	fs.mx.Lock()
+	primaryDown := now.Before(fs.downUntil)
+	fs.mx.Unlock()
+	if !primaryDown {
+		bucket, err := fs.Primary.Take(key, limit, window, now)
+		if err == nil {
+			return bucket, nil
+		}
+		fs.mx.Lock()
+		if !now.Before(fs.downUntil) {
+			slog.Warn("Rate limiting locally, shared rate limit store failed", "retry_after", fs.RetryAfter, "error", err)
+			fs.downUntil = now.Add(fs.RetryAfter)
+		}
+		fs.mx.Unlock()
+	}
+	return fs.Fallback.Take(key, limit, window, now)
+}
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"net/http"
 	"time"
 
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/requestlog"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/sessions"
 )
 
 //SessionState struct: define a session state struct for this web server
@@ -13,3 +17,44 @@ type SessionState struct {
 	StartTime time.Time   `json:"time"`
 	User      *users.User `json:"user"`
 }
+
+//logUser records the user of the session in the request's access log
+func logUser(r *http.Request, sessionState *SessionState) {
+	if sessionState.User != nil {
+		requestlog.SetUser(r.Context(), sessionState.User.ID)
+	}
+}
+
+//sessionContextKey is the request context key of the session looked up
+//for the request
+type sessionContextKey struct{}
+
+//resolvedSession is the state of a request's session, or the error
+//looking it up
+type resolvedSession struct {
+	state *SessionState
+	err   error
+}
+
+//withSessionState looks up the request's session and returns the
+//request carrying it, so handlers further down the chain don't look it
+//up in the session store again
+func withSessionState(r *http.Request, sessionContext *SessionContext) (*http.Request, *SessionState, error) {
+	state, err := GetSessionState(r, sessionContext)
+	ctx := context.WithValue(r.Context(), sessionContextKey{}, &resolvedSession{state: state, err: err})
+	return r.WithContext(ctx), state, err
+}
+
+//GetSessionState returns the state of the request's session, looking
+//it up in the session store unless it was already looked up for the
+//request
+func GetSessionState(r *http.Request, sessionContext *SessionContext) (*SessionState, error) {
+	if resolved, ok := r.Context().Value(sessionContextKey{}).(*resolvedSession); ok {
+		return resolved.state, resolved.err
+	}
+	state := &SessionState{}
+	if _, err := sessions.GetState(r, sessionContext.Key, sessionContext.Session, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
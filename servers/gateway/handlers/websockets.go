@@ -2,13 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
-	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/sessions"
 	"github.com/gorilla/websocket"
+	"github.com/streadway/amqp"
 )
 
 //TODO: add a handler that upgrades clients to a WebSocket connection
@@ -98,12 +98,12 @@ func (wsc *WebsocketContext) WebSocketHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 	//Authenticate
-	sessionState := &SessionState{}
-	_, err := sessions.GetState(r, wsc.Context.Key, wsc.Context.Session, sessionState)
+	sessionState, err := GetSessionState(r, wsc.Context)
 	if err != nil {
 		http.Error(w, "Unauthorized", 401)
 		return
 	}
+	logUser(r, sessionState)
 
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -126,10 +126,10 @@ func echo(conn *websocket.Conn, wsc *WebsocketContext, userID int) {
 	for { // infinite loop
 		messageType, _, err := conn.ReadMessage()
 		if messageType == websocket.CloseMessage {
-			fmt.Println("Close message received.")
+			slog.Debug("Websocket close message received", "user_id", userID)
 			break
 		} else if err != nil {
-			fmt.Println("Error reading message.")
+			slog.Debug("Failed to read websocket message", "user_id", userID, "error", err)
 			break
 		}
 	}
@@ -158,29 +158,33 @@ func (wsc *WebsocketContext) StartRabbitConsumer() {
 	)
 	failOnError(err, "Failed to register a consumer")
 
-	go func() {
-		for {
-			for d := range msgs {
-				m := RabbitMessage{}
-				err := json.Unmarshal([]byte(d.Body), &m)
-				if err != nil {
-					fmt.Println("Error reading json", err)
-					break
-				}
-				//check if channel is private
-				//If yes, broadcast only to users in userID array
-				//If no, broadcat to all
-				if len(m.UserIDs) != 0 {
-					log.Printf("write to private users: " + m.Type)
-					wsc.WriteToPrivateConnections(m, m.UserIDs)
-				} else {
-					log.Printf("write to ALL: " + m.Type)
-					wsc.WriteToAllConnections(m)
-				}
-			}
-		}
+	go wsc.consumeRabbitMessages(msgs)
+}
 
-	}()
+//consumeRabbitMessages writes each message from msgs to the websocket
+//connections it is for, skipping malformed ones, until msgs is closed
+func (wsc *WebsocketContext) consumeRabbitMessages(msgs <-chan amqp.Delivery) {
+	for d := range msgs {
+		m := RabbitMessage{}
+		err := json.Unmarshal([]byte(d.Body), &m)
+		if err != nil {
+			rabbitMessagesConsumed.WithLabelValues("malformed").Inc()
+			slog.Error("Failed to decode rabbit message", "error", err)
+			continue
+		}
+		rabbitMessagesConsumed.WithLabelValues("delivered").Inc()
+		//check if channel is private
+		//If yes, broadcast only to users in userID array
+		//If no, broadcat to all
+		if len(m.UserIDs) != 0 {
+			slog.Debug("Writing message to private users", "type", m.Type, "users", len(m.UserIDs))
+			wsc.WriteToPrivateConnections(m, m.UserIDs)
+		} else {
+			slog.Debug("Writing message to all users", "type", m.Type)
+			wsc.WriteToAllConnections(m)
+		}
+	}
+	slog.Error("Rabbit consumer stopped, websocket clients get no more events")
 }
 
 //InsertConnection Thread-safe method for inserting a connection
@@ -188,6 +192,7 @@ func (wsc *WebsocketContext) InsertConnection(conn *websocket.Conn, userID int)
 	wsc.Lock.Lock()
 	// insert socket connection
 	wsc.Connections[userID] = conn
+	websocketConnections.Set(float64(len(wsc.Connections)))
 	wsc.Lock.Unlock()
 }
 
@@ -196,6 +201,7 @@ func (wsc *WebsocketContext) RemoveConnection(userID int) {
 	wsc.Lock.Lock()
 	// insert socket connection
 	delete(wsc.Connections, userID)
+	websocketConnections.Set(float64(len(wsc.Connections)))
 	wsc.Lock.Unlock()
 }
 
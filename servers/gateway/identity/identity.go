@@ -0,0 +1,179 @@
+//Package identity signs the user claims the gateway forwards to the
+//microservices behind it, and lets those services verify them.
+//
+//The gateway sends the claims as base64 encoded JSON in the X-User
+//header, and their signature in the X-User-Signature header. A
+//service only trusts X-User if the signature, made with a key shared
+//with the gateway or the gateway's Ed25519 public key, is valid.
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//Header names
+const (
+	UserHeader      = "X-User"
+	SignatureHeader = "X-User-Signature"
+)
+
+//Signature algorithms, which prefix the signature in SignatureHeader
+const (
+	HMACSHA256 = "hmac-sha256"
+	Ed25519    = "ed25519"
+)
+
+//DefaultMaxAge is how long after being issued claims are accepted
+const DefaultMaxAge = 5 * time.Minute
+
+//maxClockSkew is how far in the future claims may have been issued,
+//allowing for the gateway's clock being ahead
+const maxClockSkew = time.Minute
+
+//Errors returned by Verify
+var (
+	ErrMissing   = errors.New("identity: no signed user")
+	ErrMalformed = errors.New("identity: malformed user or signature")
+	ErrSignature = errors.New("identity: invalid signature")
+	ErrExpired   = errors.New("identity: claims expired")
+)
+
+//Claims are what the gateway asserts about the user making a
+//request. The fields match the gateway's user JSON.
+type Claims struct {
+	ID        int64  `json:"id"`
+	UserName  string `json:"userName"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	PhotoURL  string `json:"photoURL"`
+	//IssuedAt is when the gateway signed the claims, in Unix seconds
+	IssuedAt int64 `json:"iat"`
+}
+
+//Strip removes the user headers, so a client can't forge them
+func Strip(h http.Header) {
+	h.Del(UserHeader)
+	h.Del(SignatureHeader)
+}
+
+//Signer signs claims for the gateway
+type Signer struct {
+	alg  string
+	sign func(msg []byte) []byte
+	now  func() time.Time
+}
+
+//NewHMACSigner constructs a new Signer using HMAC-SHA256 with the key,
+//which services verifying the claims must also have
+func NewHMACSigner(key []byte) *Signer {
+	return &Signer{
+		alg:  HMACSHA256,
+		sign: func(msg []byte) []byte { return hmacSHA256(key, msg) },
+		now:  time.Now,
+	}
+}
+
+//NewEd25519Signer constructs a new Signer using the Ed25519 private
+//key. Services verify the claims with its public key.
+func NewEd25519Signer(key ed25519.PrivateKey) *Signer {
+	return &Signer{
+		alg:  Ed25519,
+		sign: func(msg []byte) []byte { return ed25519.Sign(key, msg) },
+		now:  time.Now,
+	}
+}
+
+//SetHeaders replaces the user headers with the claims, issued now, and their signature
+func (s *Signer) SetHeaders(h http.Header, claims Claims) error {
+	claims.IssuedAt = s.now().Unix()
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	user := base64.StdEncoding.EncodeToString(encoded)
+	h.Set(UserHeader, user)
+	h.Set(SignatureHeader, s.alg+"="+base64.RawURLEncoding.EncodeToString(s.sign([]byte(user))))
+	return nil
+}
+
+//Verifier checks the claims a service gets from the gateway
+type Verifier struct {
+	//MaxAge is how long after being issued claims are accepted
+	MaxAge time.Duration
+
+	alg    string
+	verify func(msg []byte, sig []byte) bool
+	now    func() time.Time
+}
+
+//NewHMACVerifier constructs a new Verifier of claims signed by
+//NewHMACSigner with the same key
+func NewHMACVerifier(key []byte) *Verifier {
+	return &Verifier{
+		MaxAge: DefaultMaxAge,
+		alg:    HMACSHA256,
+		verify: func(msg []byte, sig []byte) bool { return hmac.Equal(sig, hmacSHA256(key, msg)) },
+		now:    time.Now,
+	}
+}
+
+//NewEd25519Verifier constructs a new Verifier of claims signed by
+//NewEd25519Signer with the public key's private key
+func NewEd25519Verifier(key ed25519.PublicKey) *Verifier {
+	return &Verifier{
+		MaxAge: DefaultMaxAge,
+		alg:    Ed25519,
+		verify: func(msg []byte, sig []byte) bool { return ed25519.Verify(key, msg, sig) },
+		now:    time.Now,
+	}
+}
+
+//Verify returns the claims in the headers if they were signed by the
+//gateway within MaxAge
+func (v *Verifier) Verify(h http.Header) (*Claims, error) {
+	user, signature := h.Get(UserHeader), h.Get(SignatureHeader)
+	if len(user) == 0 || len(signature) == 0 {
+		return nil, ErrMissing
+	}
+	alg, encodedSig, found := strings.Cut(signature, "=")
+	if !found || alg != v.alg {
+		return nil, ErrMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if !v.verify([]byte(user), sig) {
+		return nil, ErrSignature
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(user)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	claims := &Claims{}
+	if err := json.Unmarshal(decoded, claims); err != nil {
+		return nil, ErrMalformed
+	}
+	issued := time.Unix(claims.IssuedAt, 0)
+	now := v.now()
+	if now.Sub(issued) > v.MaxAge || issued.Sub(now) > maxClockSkew {
+		return nil, ErrExpired
+	}
+	return claims, nil
+}
+
+//hmacSHA256 returns the HMAC-SHA256 of the message
+func hmacSHA256(key []byte, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,180 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testClaims = Claims{ID: 7, UserName: "ada", FirstName: "Ada", LastName: "Lovelace", PhotoURL: "photo"}
+
+//testKeys returns a signer and verifier for each algorithm
+func testKeys(t *testing.T) map[string]struct {
+	signer   *Signer
+	verifier *Verifier
+} {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	hmacKey := []byte("0123456789abcdef0123456789abcdef")
+	return map[string]struct {
+		signer   *Signer
+		verifier *Verifier
+	}{
+		HMACSHA256: {NewHMACSigner(hmacKey), NewHMACVerifier(hmacKey)},
+		Ed25519:    {NewEd25519Signer(private), NewEd25519Verifier(public)},
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for alg, keys := range testKeys(t) {
+		h := http.Header{}
+		h.Set(UserHeader, "forged")
+		if err := keys.signer.SetHeaders(h, testClaims); err != nil {
+			t.Fatalf("%s: unexpected error signing: %v", alg, err)
+		}
+		if len(h.Values(UserHeader)) != 1 {
+			t.Errorf("%s: expected the existing X-User to be replaced but got %v", alg, h.Values(UserHeader))
+		}
+		if !strings.HasPrefix(h.Get(SignatureHeader), alg+"=") {
+			t.Errorf("%s: expected the signature to name its algorithm but got %s", alg, h.Get(SignatureHeader))
+		}
+
+		claims, err := keys.verifier.Verify(h)
+		if err != nil {
+			t.Fatalf("%s: unexpected error verifying: %v", alg, err)
+		}
+		if claims.IssuedAt == 0 || time.Since(time.Unix(claims.IssuedAt, 0)) > time.Minute {
+			t.Errorf("%s: expected claims issued now but got %d", alg, claims.IssuedAt)
+		}
+		claims.IssuedAt = 0
+		if *claims != testClaims {
+			t.Errorf("%s: expected %+v but got %+v", alg, testClaims, *claims)
+		}
+	}
+}
+
+func TestXUserIsUserJSON(t *testing.T) {
+	//services that read X-User without verifying it keep working
+	h := http.Header{}
+	NewHMACSigner([]byte("key")).SetHeaders(h, testClaims)
+	decoded, err := base64.StdEncoding.DecodeString(h.Get(UserHeader))
+	if err != nil {
+		t.Fatalf("expected standard base64 but got %v", err)
+	}
+	var user map[string]interface{}
+	if err := json.Unmarshal(decoded, &user); err != nil {
+		t.Fatalf("expected JSON but got %s", decoded)
+	}
+	if user["id"] != float64(7) || user["userName"] != "ada" || user["iat"] == nil {
+		t.Errorf("unexpected user JSON: %s", decoded)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	keys := testKeys(t)
+	signer, verifier := keys[HMACSHA256].signer, keys[HMACSHA256].verifier
+	//claims are issued in whole seconds
+	now := time.Now().Truncate(time.Second)
+	signer.now = func() time.Time { return now }
+	verifier.now = func() time.Time { return now }
+	signed := http.Header{}
+	signer.SetHeaders(signed, testClaims)
+	user, signature := signed.Get(UserHeader), signed.Get(SignatureHeader)
+
+	//tamper replaces the claims with edited ones, keeping the signature
+	tampered := testClaims
+	tampered.ID = 1
+	tamperedJSON, _ := json.Marshal(tampered)
+
+	cases := []struct {
+		name      string
+		user      string
+		signature string
+		now       time.Time
+		expected  error
+	}{
+		{"No User", "", signature, now, ErrMissing},
+		{"No Signature", user, "", now, ErrMissing},
+		{"Forged User", base64.StdEncoding.EncodeToString(tamperedJSON), signature, now, ErrSignature},
+		{"Other Algorithm", user, keys[Ed25519].signer.alg + "=" + strings.SplitN(signature, "=", 2)[1], now, ErrMalformed},
+		{"Bad Signature", user, HMACSHA256 + "=" + base64.RawURLEncoding.EncodeToString([]byte("bogus")), now, ErrSignature},
+		{"Not Base64", user, HMACSHA256 + "=!!", now, ErrMalformed},
+		{"No Algorithm", user, strings.SplitN(signature, "=", 2)[1], now, ErrMalformed},
+		{"Expired", user, signature, now.Add(DefaultMaxAge + time.Second), ErrExpired},
+		{"Issued In Future", user, signature, now.Add(-2 * maxClockSkew), ErrExpired},
+		{"Within Max Age", user, signature, now.Add(DefaultMaxAge), nil},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		if len(c.user) != 0 {
+			h.Set(UserHeader, c.user)
+		}
+		if len(c.signature) != 0 {
+			h.Set(SignatureHeader, c.signature)
+		}
+		at := c.now
+		verifier.now = func() time.Time { return at }
+		if _, err := verifier.Verify(h); !errors.Is(err, c.expected) {
+			t.Errorf("case %s: expected %v but got %v", c.name, c.expected, err)
+		}
+	}
+
+	//a signature from another key doesn't verify
+	other := http.Header{}
+	NewHMACSigner([]byte("another key")).SetHeaders(other, testClaims)
+	verifier.now = time.Now
+	if _, err := verifier.Verify(other); err != ErrSignature {
+		t.Errorf("expected %v for another key but got %v", ErrSignature, err)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	h := http.Header{}
+	h.Set(UserHeader, "forged")
+	h.Set(SignatureHeader, "forged")
+	h.Set("Authorization", "kept")
+	Strip(h)
+	if len(h) != 1 || h.Get("Authorization") != "kept" {
+		t.Errorf("expected only the user headers to be removed but got %v", h)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	keys := testKeys(t)[Ed25519]
+	handler := keys.verifier.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := FromContext(r.Context())
+		if !ok {
+			t.Errorf("expected claims in the context")
+			return
+		}
+		w.Write([]byte(claims.UserName))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/channels", nil)
+	r.Header.Set(UserHeader, base64.StdEncoding.EncodeToString([]byte(`{"id":1}`)))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d for an unsigned user but got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	keys.signer.SetHeaders(r.Header, testClaims)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK || rr.Body.String() != "ada" {
+		t.Errorf("expected the signed user to be let through but got %d %q", rr.Code, rr.Body.String())
+	}
+
+	if _, ok := FromContext(r.Context()); ok {
+		t.Errorf("expected no claims in a context Require didn't make")
+	}
+}
@@ -0,0 +1,30 @@
+package identity
+
+import (
+	"context"
+	"net/http"
+)
+
+//contextKey is the type of the key claims are stored under in a
+//request's context
+type contextKey struct{}
+
+//Require returns a handler that responds with 401 to requests without
+//valid claims, and otherwise calls next with the claims in the
+//request's context
+func (v *Verifier) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, err := v.Verify(r.Header)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, claims)))
+	})
+}
+
+//FromContext returns the claims Require verified for the request
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(*Claims)
+	return claims, ok
+}
@@ -0,0 +1,185 @@
+package indexes
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//CopyOnWriteTrie is a compressed trie mapping strings to int64s whose
+//readers never wait for writers. Its nodes are never changed once
+//published: Add and Remove copy the nodes on the path to the key they
+//change, then swap in the new root atomically, so Find and Len see
+//either the whole change or none of it without taking any lock. Writers
+//take turns, so it suits indexes that are searched far more often than
+//they change. Keys and prefixes are normalized like TrieNode's.
+type CopyOnWriteTrie struct {
+	root   atomic.Pointer[cowRoot] // Current version, nil until the first write
+	mx     sync.Mutex              // Serializes writers, never taken by readers
+	normal Normalizer              // Applied to keys and prefixes
+}
+
+//cowRoot is one immutable version of a CopyOnWriteTrie
+type cowRoot struct {
+	node *radixNode
+	size int
+}
+
+//NewCopyOnWriteTrie constructs a new CopyOnWriteTrie that normalizes keys with Normalize.
+func NewCopyOnWriteTrie() *CopyOnWriteTrie {
+	return &CopyOnWriteTrie{}
+}
+
+//NewCopyOnWriteTrieWithNormalizer constructs a new CopyOnWriteTrie that
+//normalizes keys and prefixes with the given Normalizer.
+func NewCopyOnWriteTrieWithNormalizer(normal Normalizer) *CopyOnWriteTrie {
+	return &CopyOnWriteTrie{normal: normal}
+}
+
+//normalize applies the trie's Normalizer to a key or prefix
+func (t *CopyOnWriteTrie) normalize(key string) string {
+	if t.normal == nil {
+		return Normalize(key)
+	}
+	return t.normal(key)
+}
+
+//Len returns the number of entries in the trie.
+func (t *CopyOnWriteTrie) Len() int {
+	root := t.root.Load()
+	if root == nil {
+		return 0
+	}
+	return root.size
+}
+
+//Find finds `max` values matching `prefix`. If the trie
+//is entirely empty, or the prefix is empty, or max == 0,
+//or the prefix is not found, this returns a nil slice.
+func (t *CopyOnWriteTrie) Find(prefix string, max int) []int64 {
+	prefix = t.normalize(prefix)
+	root := t.root.Load()
+	if root == nil || root.size == 0 || prefix == "" || max <= 0 {
+		return nil
+	}
+	return root.node.find(prefix, max)
+}
+
+//Add adds a key and value to the trie.
+func (t *CopyOnWriteTrie) Add(key string, value int64) {
+	// normalize before taking the lock, it's the slowest part
+	key = t.normalize(key)
+	// a key made only of marks normalizes to nothing
+	if key == "" {
+		return
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	root := t.root.Load()
+	if root == nil {
+		root = &cowRoot{node: &radixNode{}}
+	}
+	if node, added := root.node.with(key, value); added {
+		t.root.Store(&cowRoot{node: node, size: root.size + 1})
+	}
+}
+
+//Remove removes a key/value pair from the trie, and merges
+//or trims edges left without values or branches.
+func (t *CopyOnWriteTrie) Remove(key string, value int64) {
+	key = t.normalize(key)
+	if key == "" {
+		return
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	root := t.root.Load()
+	if root == nil {
+		return
+	}
+	if node, removed := root.node.without(key, value); removed {
+		t.root.Store(&cowRoot{node: node, size: root.size - 1})
+	}
+}
+
+//with returns a copy of the node with the key and value added below it,
+//sharing every node off the key's path, and true. If the pair is already
+//there it returns the node itself and false.
+func (n *radixNode) with(key string, value int64) (*radixNode, bool) {
+	if key == "" {
+		idx := sort.Search(len(n.values), func(i int) bool { return n.values[i] >= value })
+		if idx < len(n.values) && n.values[idx] == value {
+			return n, false
+		}
+		values := make([]int64, 0, len(n.values)+1)
+		values = append(values, n.values[:idx]...)
+		values = append(values, value)
+		values = append(values, n.values[idx:]...)
+		return &radixNode{prefix: n.prefix, children: n.children, values: values}, true
+	}
+
+	idx, child := n.child(key[0])
+	if child == nil {
+		leaf := &radixNode{prefix: strings.Clone(key), values: []int64{value}}
+		children := make([]*radixNode, 0, len(n.children)+1)
+		children = append(children, n.children[:idx]...)
+		children = append(children, leaf)
+		children = append(children, n.children[idx:]...)
+		return &radixNode{prefix: n.prefix, children: children, values: n.values}, true
+	}
+
+	var newChild *radixNode
+	common := commonPrefixLen(key, child.prefix)
+	if common < len(child.prefix) {
+		// the key leaves this edge part way, so split it there
+		tail := &radixNode{prefix: child.prefix[common:], children: child.children, values: child.values}
+		split := &radixNode{prefix: child.prefix[:common], children: []*radixNode{tail}}
+		newChild, _ = split.with(key[common:], value)
+	} else {
+		var added bool
+		if newChild, added = child.with(key[common:], value); !added {
+			return n, false
+		}
+	}
+	children := make([]*radixNode, len(n.children))
+	copy(children, n.children)
+	children[idx] = newChild
+	return &radixNode{prefix: n.prefix, children: children, values: n.values}, true
+}
+
+//without returns a copy of the node with the key and value removed
+//below it, sharing every node off the key's path, and true. If the
+//pair isn't there it returns the node itself and false.
+func (n *radixNode) without(key string, value int64) (*radixNode, bool) {
+	if key == "" {
+		idx := sort.Search(len(n.values), func(i int) bool { return n.values[i] >= value })
+		if idx == len(n.values) || n.values[idx] != value {
+			return n, false
+		}
+		var values []int64
+		if len(n.values) > 1 {
+			values = make([]int64, 0, len(n.values)-1)
+			values = append(values, n.values[:idx]...)
+			values = append(values, n.values[idx+1:]...)
+		}
+		return &radixNode{prefix: n.prefix, children: n.children, values: values}, true
+	}
+
+	idx, child := n.child(key[0])
+	// key is not in the trie, nothing to remove
+	if child == nil || !strings.HasPrefix(key, child.prefix) {
+		return n, false
+	}
+	newChild, removed := child.without(key[len(child.prefix):], value)
+	if !removed {
+		return n, false
+	}
+	children := make([]*radixNode, len(n.children))
+	copy(children, n.children)
+	children[idx] = newChild
+	copied := &radixNode{prefix: n.prefix, children: children, values: n.values}
+	// compact only changes the new children slice and makes new nodes
+	copied.compact(idx)
+	return copied, true
+}
@@ -0,0 +1,385 @@
+package indexes
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+func TestCopyOnWriteTrieMatchesTrieNode(t *testing.T) {
+	property := func(ops indexOps) bool {
+		want, got := NewTrieNode(), NewCopyOnWriteTrie()
+		keys := []string{"a", "王"}
+		for _, op := range ops {
+			if op.remove {
+				want.Remove(op.key, op.value)
+				got.Remove(op.key, op.value)
+			} else {
+				want.Add(op.key, op.value)
+				got.Add(op.key, op.value)
+			}
+			keys = append(keys, op.key)
+			if err := indexesEqual(want, got, []string{op.key}); err != nil {
+				t.Logf("after %+v: %v", op, err)
+				return false
+			}
+		}
+		if err := indexesEqual(want, got, keys); err != nil {
+			t.Logf("after all operations: %v", err)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 50}); err != nil {
+		t.Error(err)
+	}
+}
+
+//TestCopyOnWriteTrieVersions checks that writes never change a version
+//readers may still be using
+func TestCopyOnWriteTrieVersions(t *testing.T) {
+	property := func(before, after indexOps) bool {
+		testTrie := NewCopyOnWriteTrie()
+		for _, op := range before {
+			testTrie.Add(op.key, op.value)
+		}
+		old := testTrie.root.Load()
+		if old == nil {
+			return true
+		}
+		prefixes := []string{"a", "b", "A", "é", "王"}
+		expected := make([][]int64, len(prefixes))
+		for i, prefix := range prefixes {
+			expected[i] = old.node.find(Normalize(prefix), 100)
+		}
+		for _, op := range append(before, after...) {
+			if op.remove {
+				testTrie.Remove(op.key, op.value)
+			} else {
+				testTrie.Add(op.key, op.value)
+			}
+		}
+		for i, prefix := range prefixes {
+			if result := old.node.find(Normalize(prefix), 100); !reflect.DeepEqual(result, expected[i]) {
+				t.Logf("prefix %q: old version changed from %v to %v", prefix, expected[i], result)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+//TestCopyOnWriteTrieReadersDontBlock checks that Find and Len
+//return while a writer holds the lock
+func TestCopyOnWriteTrieReadersDontBlock(t *testing.T) {
+	testTrie := NewCopyOnWriteTrie()
+	testTrie.Add("ada", 1)
+	testTrie.mx.Lock()
+	defer testTrie.mx.Unlock()
+
+	done := make(chan []int64)
+	go func() {
+		testTrie.Len()
+		done <- testTrie.Find("ad", 10)
+	}()
+	select {
+	case result := <-done:
+		if !reflect.DeepEqual(result, []int64{1}) {
+			t.Errorf("expected [1] but got %v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Find blocked on a writer")
+	}
+}
+
+//TestCopyOnWriteTrieStress runs writers and readers at once, so the
+//race detector can check them, and checks readers only ever see whole
+//writes: every user is added under two keys in one version each, and
+//removed from the second key before the first.
+func TestCopyOnWriteTrieStress(t *testing.T) {
+	testTrie := NewCopyOnWriteTrie()
+	const writers, readers, users = 4, 4, 200
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < users; i++ {
+				id := int64(w*users + i)
+				testTrie.Add(fmt.Sprintf("first%d", id), id)
+				testTrie.Add(fmt.Sprintf("second%d", id), id)
+				if i%2 == 0 {
+					testTrie.Remove(fmt.Sprintf("second%d", id), id)
+					testTrie.Remove(fmt.Sprintf("first%d", id), id)
+				}
+			}
+		}(w)
+	}
+	errs := make(chan error, readers)
+	var readWG sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readWG.Add(1)
+		go func() {
+			defer readWG.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// a user under second must still be under first, whichever
+				// version each Find sees, since first is added earlier
+				// and removed later
+				second := testTrie.Find("second", writers*users)
+				first := make(map[int64]bool)
+				for _, id := range testTrie.Find("first", writers*users) {
+					first[id] = true
+				}
+				for _, id := range second {
+					if !first[id] && testTrie.Find(fmt.Sprintf("second%d", id), 1) != nil {
+						errs <- fmt.Errorf("user %d found under second but not first", id)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	readWG.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if l := testTrie.Len(); l != writers*users {
+		t.Errorf("expected %d entries but got %d", writers*users, l)
+	}
+}
+
+//findIn finds up to 100 values matching prefix in a version of a TrieNode
+func findIn(version *trieVersion[int64], prefix string) []int64 {
+	var list []int64
+	if node := version.root.descend([]rune(Normalize(prefix))); node != nil {
+		node.findDFS(&list, 100, lessInt64)
+	}
+	return list
+}
+
+//TestTrieVersions checks that writes never change a version of a
+//TrieNode readers may still be using
+func TestTrieVersions(t *testing.T) {
+	property := func(before, after indexOps) bool {
+		testTrie := NewTrieNode()
+		for _, op := range before {
+			testTrie.AddField(op.key, op.value, Field(op.value%3))
+		}
+		old := testTrie.load()
+		prefixes := []string{"a", "b", "A", "é", "王"}
+		expected := make([][]int64, len(prefixes))
+		for i, prefix := range prefixes {
+			expected[i] = findIn(old, prefix)
+		}
+		var snapshot bytes.Buffer
+		if _, err := testTrie.WriteTo(&snapshot); err != nil {
+			t.Fatal(err)
+		}
+		for _, op := range append(before, after...) {
+			if op.remove {
+				testTrie.Remove(op.key, op.value)
+			} else {
+				testTrie.AddField(op.key, op.value, Field(op.value%4))
+			}
+		}
+		testTrie.RemoveValues(1, 2)
+		for i, prefix := range prefixes {
+			if result := findIn(old, prefix); !reflect.DeepEqual(result, expected[i]) {
+				t.Logf("prefix %q: old version changed from %v to %v", prefix, expected[i], result)
+				return false
+			}
+		}
+		// the old version must still write the same snapshot
+		var again bytes.Buffer
+		testTrie.current.Store(old)
+		if _, err := testTrie.WriteTo(&again); err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Equal(snapshot.Bytes(), again.Bytes())
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+//TestTrieReadersDontBlock checks that every search of a TrieNode
+//returns while a writer holds the lock
+func TestTrieReadersDontBlock(t *testing.T) {
+	testTrie := NewTrieNode()
+	testTrie.Add("ada", 1)
+	testTrie.mx.Lock()
+	defer testTrie.mx.Unlock()
+
+	done := make(chan []int64)
+	go func() {
+		testTrie.Len()
+		testTrie.Values()
+		testTrie.FindFuzzy("adb", 1, 10)
+		testTrie.FindRanked("ad", 10, nil)
+		testTrie.FindAfter("ad", "", 0, 10)
+		testTrie.WriteTo(io.Discard)
+		done <- testTrie.Find("ad", 10)
+	}()
+	select {
+	case result := <-done:
+		if !reflect.DeepEqual(result, []int64{1}) {
+			t.Errorf("expected [1] but got %v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a search blocked on a writer")
+	}
+}
+
+//TestTrieStress runs writers and every kind of search on a TrieNode at
+//once, so the race detector can check them, and checks searches only
+//ever see whole writes: every user is added under two keys in one
+//version each, and removed from the second key before the first.
+func TestTrieStress(t *testing.T) {
+	testTrie := NewTrieNode()
+	const writers, readers, users = 4, 4, 200
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < users; i++ {
+				id := int64(w*users + i)
+				testTrie.AddField(fmt.Sprintf("first%d", id), id, 1)
+				testTrie.AddField(fmt.Sprintf("second%d", id), id, 2)
+				switch i % 4 {
+				case 0:
+					testTrie.Remove(fmt.Sprintf("second%d", id), id)
+					testTrie.Remove(fmt.Sprintf("first%d", id), id)
+				case 1:
+					testTrie.RemoveValues(id)
+				}
+			}
+		}(w)
+	}
+	errs := make(chan error, readers)
+	var readWG sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readWG.Add(1)
+		go func() {
+			defer readWG.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				testTrie.FindFuzzy("secnd1", 1, 20)
+				testTrie.FindAfter("first second", "first1", 1, 20)
+				testTrie.WriteTo(io.Discard)
+				// a user under second must still be under first, whichever
+				// version each search sees, since first is added earlier
+				// and removed later
+				second := testTrie.FindRanked("second", writers*users, nil)
+				first := make(map[int64]bool)
+				for _, id := range testTrie.Find("first", writers*users) {
+					first[id] = true
+				}
+				for _, result := range second {
+					if !first[result.Value] && testTrie.Find(fmt.Sprintf("second%d", result.Value), 1) != nil {
+						errs <- fmt.Errorf("user %d found under second but not first", result.Value)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	readWG.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if l := testTrie.Len(); l != writers*users {
+		t.Errorf("expected %d entries but got %d", writers*users, l)
+	}
+}
+
+//globalLockIndex guards an Index with one read/write mutex, like
+//TrieNode did before it was copy-on-write, for benchmarks to compare with
+type globalLockIndex struct {
+	index Index
+	mx    sync.RWMutex
+}
+
+func (g *globalLockIndex) Add(key string, value int64) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.index.Add(key, value)
+}
+
+func (g *globalLockIndex) Remove(key string, value int64) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.index.Remove(key, value)
+}
+
+func (g *globalLockIndex) Find(prefix string, max int) []int64 {
+	g.mx.RLock()
+	defer g.mx.RUnlock()
+	return g.index.Find(prefix, max)
+}
+
+func (g *globalLockIndex) Len() int {
+	g.mx.RLock()
+	defer g.mx.RUnlock()
+	return g.index.Len()
+}
+
+//BenchmarkIndexMixed runs searches on every goroutine while one in
+//every writeEvery operations is a sign up, like the gateway under load
+func BenchmarkIndexMixed(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	for _, writeEvery := range []int{100, 10} {
+		indexes := benchmarkIndexes()
+		indexes["GlobalLock"] = func() Index { return &globalLockIndex{index: NewTrieNode()} }
+		for name, newIndex := range indexes {
+			b.Run(fmt.Sprintf("%s/writes=1in%d", name, writeEvery), func(b *testing.B) {
+				index := newIndex()
+				for i, key := range keys {
+					index.Add(key, int64(i))
+				}
+				var mx sync.Mutex
+				next := int64(len(keys))
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for i := 0; pb.Next(); i++ {
+						if i%writeEvery == 0 {
+							mx.Lock()
+							next++
+							id := next
+							mx.Unlock()
+							index.Add(keys[id%int64(len(keys))], id)
+							continue
+						}
+						index.Find("ada1", 20)
+					}
+				})
+			})
+		}
+	}
+}
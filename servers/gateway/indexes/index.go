@@ -0,0 +1,19 @@
+package indexes
+
+//Index maps string keys to int64 values and finds values by key
+//prefix. TrieNode and RadixTrie both implement it, and return the
+//same results in the same order for the same keys and values.
+type Index interface {
+	//Add adds a key and value to the index
+	Add(key string, value int64)
+	//Remove removes a key/value pair from the index
+	Remove(key string, value int64)
+	//Find finds `max` values whose keys start with `prefix`,
+	//in key order and then value order
+	Find(prefix string, max int) []int64
+	//Len returns the number of key/value pairs in the index
+	Len() int
+}
+
+var _ Index = (*TrieNode)(nil)
+var _ Index = (*RadixTrie)(nil)
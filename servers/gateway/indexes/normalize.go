@@ -0,0 +1,40 @@
+package indexes
+
+import (
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+//Normalizer maps a key to the form it is stored and searched under,
+//so that different spellings of the same name share one key
+type Normalizer func(key string) string
+
+//FoldCase applies NFKC normalization and Unicode case folding, so
+//full-width, composed and decomposed forms and any letter case of a
+//name all become the same key. Accents are kept, so "José" and "Jose"
+//are still different keys.
+func FoldCase(key string) string {
+	// casers and transformers keep state, so each call gets its own
+	t := transform.Chain(norm.NFKC, cases.Fold(), norm.NFKC)
+	folded, _, err := transform.String(t, key)
+	if err != nil {
+		return key
+	}
+	return folded
+}
+
+//Normalize is FoldCase that also strips diacritics, so "José" and
+//"Jose" are the same key. Letters with no decomposed form, such as
+//"ø" or "ł", are kept. This is the Normalizer used by NewTrieNode.
+func Normalize(key string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	stripped, _, err := transform.String(t, FoldCase(key))
+	if err != nil {
+		return FoldCase(key)
+	}
+	return stripped
+}
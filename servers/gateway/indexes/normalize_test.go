@@ -0,0 +1,91 @@
+package indexes
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		name     string
+		key      string
+		folded   string
+		stripped string
+	}{
+		{"ASCII", "Eric", "eric", "eric"},
+		{"Composed Accent", "José", "josé", "jose"},
+		{"Decomposed Accent", "José", "josé", "jose"},
+		{"Full Width", "ＪＯＨＮ", "john", "john"},
+		{"German Sharp S", "Straße", "strasse", "strasse"},
+		{"Greek", "ΣΩΚΡΆΤΗΣ", "σωκράτησ", "σωκρατησ"},
+		{"Vietnamese", "Nguyễn", "nguyễn", "nguyen"},
+		{"No Decomposition", "Søren", "søren", "søren"},
+		{"Cyrillic", "Ёлкин", "ёлкин", "елкин"},
+		{"Han", "王小明", "王小明", "王小明"},
+		{"Ligature", "ﬁona", "fiona", "fiona"},
+	}
+
+	for _, c := range cases {
+		if folded := FoldCase(c.key); folded != c.folded {
+			t.Errorf("case %s: FoldCase(%q): expected %q but got %q", c.name, c.key, c.folded, folded)
+		}
+		if stripped := Normalize(c.key); stripped != c.stripped {
+			t.Errorf("case %s: Normalize(%q): expected %q but got %q", c.name, c.key, c.stripped, stripped)
+		}
+	}
+}
+
+func TestNormalizedFind(t *testing.T) {
+	cases := []struct {
+		name     string
+		keys     []string
+		values   []int64
+		prefix   string
+		expected []int64
+	}{
+		{"Accent In Key", []string{"José"}, []int64{1}, "jose", []int64{1}},
+		{"Accent In Prefix", []string{"Jose"}, []int64{1}, "JOSÉ", []int64{1}},
+		{"Composed And Decomposed", []string{"José", "José"}, []int64{1, 2}, "jos", []int64{1, 2}},
+		{"Full Width Prefix", []string{"john"}, []int64{1}, "ＪＯ", []int64{1}},
+		{"Sharp S", []string{"Straße"}, []int64{1}, "strass", []int64{1}},
+		{"Vietnamese", []string{"Nguyễn"}, []int64{1}, "nguyen", []int64{1}},
+		{"Han", []string{"王小明", "王芳"}, []int64{1, 2}, "王", []int64{1, 2}},
+		{"Only Marks", []string{"́"}, []int64{1}, "́", nil},
+	}
+
+	for _, c := range cases {
+		testTrie := NewTrieNode()
+		for idx, k := range c.keys {
+			testTrie.Add(k, c.values[idx])
+		}
+		slice := testTrie.Find(c.prefix, 10)
+		if len(slice) != len(c.expected) {
+			t.Errorf("case %s: expected %v but got %v", c.name, c.expected, slice)
+			continue
+		}
+		for idx, v := range c.expected {
+			if slice[idx] != v {
+				t.Errorf("case %s: expected %v but got %v", c.name, c.expected, slice)
+				break
+			}
+		}
+	}
+}
+
+func TestNormalizedRemove(t *testing.T) {
+	testTrie := NewTrieNode()
+	testTrie.Add("José", 1)
+	testTrie.Remove("JOSE", 1)
+	if l := testTrie.Len(); l != 0 {
+		t.Errorf("expected differently spelled key to be removed but len is %d", l)
+	}
+}
+
+func TestFoldCaseKeepsAccents(t *testing.T) {
+	testTrie := NewTrieNodeWithNormalizer(FoldCase)
+	testTrie.Add("José", 1)
+	testTrie.Add("Jose", 2)
+	if slice := testTrie.Find("JOSÉ", 10); len(slice) != 1 || slice[0] != 1 {
+		t.Errorf("expected [1] but got %v", slice)
+	}
+	if slice := testTrie.FindFuzzy("josé", 1, 10); len(slice) != 2 || slice[0] != 1 || slice[1] != 2 {
+		t.Errorf("expected [1 2] but got %v", slice)
+	}
+}
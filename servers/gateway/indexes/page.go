@@ -0,0 +1,173 @@
+package indexes
+
+import (
+	"sort"
+	"strings"
+)
+
+//EntryOf is a value found by FindAfter and the key it was found under.
+//Pass the last entry's Key and Value back to FindAfter for the next page.
+type EntryOf[V comparable] struct {
+	Key   string
+	Value V
+}
+
+//Entry is a value found by a TrieNode's FindAfter
+type Entry = EntryOf[int64]
+
+//FindAfter finds `max` values matching `prefix` that come after the
+//entry with key `afterKey` and value `afterID`, in key order and then
+//the trie's value order, so a search can be read a page at a time. Each value is
+//only found under the first key it matches, so it's on one page at
+//most. An empty afterKey starts from the beginning. Since pages pick
+//up after a key rather than a count, values added before that key
+//don't shift later pages, and the walk starts at that key rather than
+//at the first match. Like FindRanked, a prefix of several words
+//only matches values with a key starting with each word, and Key is
+//the key matching the longest word. Without a less function the trie
+//can't tell which values of afterKey come after afterID, so the next
+//page starts at the following key. If the trie is entirely empty,
+//or the prefix is empty, or max == 0, or nothing is left, this
+//returns a nil slice.
+func (t *Trie[V]) FindAfter(prefix string, afterKey string, afterID V, max int) []EntryOf[V] {
+	tokens := strings.Fields(t.normalize(prefix))
+	current := t.load()
+	if len(current.root.children) == 0 || len(tokens) == 0 || max <= 0 {
+		return nil
+	}
+	// page through the longest word, which usually matches the fewest
+	// keys, and only keep values that match all the others too
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return len(tokens[i]) > len(tokens[j])
+	})
+	for _, token := range tokens[1:] {
+		if current.root.descend([]rune(token)) == nil {
+			return nil
+		}
+	}
+
+	page := &pager[V]{
+		prefix:   tokens[0],
+		others:   tokens[1:],
+		afterKey: afterKey,
+		afterID:  afterID,
+		less:     t.less,
+		version:  current,
+		seen:     make(set[V]),
+		max:      max,
+	}
+	prefixRunes := []rune(tokens[0])
+	triePointer := current.root.descend(prefixRunes)
+	if triePointer == nil {
+		return nil
+	}
+	switch {
+	case afterKey == "" || afterKey < tokens[0]:
+		// every key under the prefix comes after the cursor
+		triePointer.afterDFS(prefixRunes, nil, false, page)
+	case strings.HasPrefix(afterKey, tokens[0]):
+		triePointer.afterDFS(prefixRunes, []rune(afterKey[len(tokens[0]):]), true, page)
+	default:
+		// every key under the prefix comes before the cursor
+		return nil
+	}
+	return page.list
+}
+
+//descend returns the node for the given key, or nil if there is none
+func (t *trieNode[V]) descend(key []rune) *trieNode[V] {
+	triePointer := t
+	for _, s := range key {
+		if triePointer.children[s] == nil {
+			return nil
+		}
+		triePointer = triePointer.children[s]
+	}
+	return triePointer
+}
+
+//pager collects one page of FindAfter
+type pager[V comparable] struct {
+	prefix   string            // word whose keys are paged through
+	others   []string          // words values must also have a key starting with
+	afterKey string            // key of the last entry of the page before
+	afterID  V                 // value of the last entry of the page before
+	less     func(a, b V) bool // trie's value order
+	version  *trieVersion[V]   // version searched, for the keys of each value
+	seen     set[V]            // values already found by this walk
+	list     []EntryOf[V]      // the page so far
+	max      int               // size of the page
+}
+
+//before returns true if value under key comes before the cursor
+func (p *pager[V]) before(key string, value V) bool {
+	return key < p.afterKey || (key == p.afterKey && (p.less == nil || !p.less(p.afterID, value)))
+}
+
+//accepts returns true if value, found under key, belongs on the page.
+//Values matching a key before the cursor were on an earlier page, and
+//values found earlier in this walk are already on this one.
+func (p *pager[V]) accepts(key string, value V) bool {
+	if p.seen.has(value) {
+		return false
+	}
+	keys := p.version.keysOf(value)
+	for _, other := range p.others {
+		matched := false
+		for k := range keys {
+			if strings.HasPrefix(k, other) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if p.before(key, value) {
+		return false
+	}
+	for k := range keys {
+		if strings.HasPrefix(k, p.prefix) && p.before(k, value) {
+			return false
+		}
+	}
+	p.seen.add(value)
+	return true
+}
+
+//afterDFS is a private function that walks the trie in key order from
+//this node, whose key is `key`, adding entries after the cursor to
+//the page until it's full. While onPath, this node's key is a prefix
+//of the cursor's key and `rest` is the remainder of it, so children
+//before the cursor are skipped without being walked. It returns true
+//once the page is full.
+func (t *trieNode[V]) afterDFS(key []rune, rest []rune, onPath bool, page *pager[V]) bool {
+	// on the path, only the cursor's own node can have values after it
+	if len(t.values) > 0 && (!onPath || len(rest) == 0) {
+		keyString := string(key)
+		for _, value := range t.values.sorted(page.less) {
+			if !page.accepts(keyString, value) {
+				continue
+			}
+			page.list = append(page.list, EntryOf[V]{keyString, value})
+			if len(page.list) == page.max {
+				return true
+			}
+		}
+	}
+	for _, r := range t.sortedChildren() {
+		childKey := append(key[:len(key):len(key)], r)
+		switch {
+		case !onPath || len(rest) == 0 || r > rest[0]:
+			if t.children[r].afterDFS(childKey, nil, false, page) {
+				return true
+			}
+		case r == rest[0]:
+			if t.children[r].afterDFS(childKey, rest[1:], true, page) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package indexes
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+//readPages pages through prefix with FindAfter and returns the pages
+func readPages(testTrie *TrieNode, prefix string, max int) [][]int64 {
+	var pages [][]int64
+	afterKey, afterID := "", int64(0)
+	for {
+		entries := testTrie.FindAfter(prefix, afterKey, afterID, max)
+		if len(entries) == 0 {
+			return pages
+		}
+		page := make([]int64, len(entries))
+		for idx, entry := range entries {
+			page[idx] = entry.Value
+		}
+		pages = append(pages, page)
+		afterKey, afterID = entries[len(entries)-1].Key, entries[len(entries)-1].Value
+	}
+}
+
+func TestFindAfter(t *testing.T) {
+	testTrie := NewTrieNode()
+	testTrie.Add("ada", 1)
+	testTrie.Add("lovelace", 1)
+	testTrie.Add("alan", 2)
+	testTrie.Add("turing", 2)
+	testTrie.Add("ada", 3)
+	testTrie.Add("adams", 3)
+	testTrie.Add("anita", 4)
+	testTrie.Add("borg", 4)
+	testTrie.Add("adam", 5)
+
+	cases := []struct {
+		name     string
+		prefix   string
+		afterKey string
+		afterID  int64
+		max      int
+		expected []Entry
+	}{
+		{"From The Start", "a", "", 0, 10,
+			[]Entry{{"ada", 1}, {"ada", 3}, {"adam", 5}, {"alan", 2}, {"anita", 4}}},
+		{"After A Value", "a", "ada", 1, 2,
+			[]Entry{{"ada", 3}, {"adam", 5}}},
+		{"After A Key", "a", "adam", 5, 10,
+			[]Entry{{"alan", 2}, {"anita", 4}}},
+		{"After A Removed Key", "a", "adb", 0, 10,
+			[]Entry{{"alan", 2}, {"anita", 4}}},
+		{"After Everything", "a", "anita", 4, 10, nil},
+		{"Multiple Words", "a tur", "", 0, 10,
+			[]Entry{{"turing", 2}}},
+		{"Multiple Words After", "lov a", "", 0, 10,
+			[]Entry{{"lovelace", 1}}},
+		{"Not Found", "z", "", 0, 10, nil},
+		{"Zero Max", "a", "", 0, 0, nil},
+		{"Empty Prefix", "", "", 0, 10, nil},
+	}
+	for _, c := range cases {
+		if result := testTrie.FindAfter(c.prefix, c.afterKey, c.afterID, c.max); !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("case %s: expected %v but got %v", c.name, c.expected, result)
+		}
+	}
+}
+
+func TestFindAfterEachValueOnce(t *testing.T) {
+	testTrie := NewTrieNode()
+	// 3 is under "adam" and "adams", but only belongs on the first page
+	testTrie.Add("adam", 3)
+	testTrie.Add("adams", 3)
+	testTrie.Add("adamo", 1)
+	testTrie.Add("adamx", 2)
+
+	pages := readPages(testTrie, "ad", 2)
+	expected := [][]int64{{3, 1}, {2}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected %v but got %v", expected, pages)
+	}
+}
+
+//TestFindAfterConcurrentInserts checks that adding values between
+//pages neither repeats nor skips values that were there all along
+func TestFindAfterConcurrentInserts(t *testing.T) {
+	testTrie := NewTrieNode()
+	names := []string{"ada", "alan", "alice", "anita", "april", "ava"}
+	for idx, name := range names {
+		testTrie.Add(name, int64(idx+1))
+	}
+
+	seen := make(map[int64]int)
+	afterKey, afterID := "", int64(0)
+	for page := 0; ; page++ {
+		entries := testTrie.FindAfter("a", afterKey, afterID, 2)
+		if len(entries) == 0 {
+			break
+		}
+		for _, entry := range entries {
+			seen[entry.Value]++
+		}
+		afterKey, afterID = entries[len(entries)-1].Key, entries[len(entries)-1].Value
+		// sign ups before and after where the next page starts
+		if page < 2 {
+			testTrie.Add("aaron", int64(100+page))
+			testTrie.Add("azalea", int64(200+page))
+		}
+	}
+	for idx := range names {
+		if seen[int64(idx+1)] != 1 {
+			t.Errorf("expected %s once but saw it %d times", names[idx], seen[int64(idx+1)])
+		}
+	}
+	for value, count := range seen {
+		if count != 1 {
+			t.Errorf("expected %d once but saw it %d times", value, count)
+		}
+	}
+	// sign ups after the cursor are on later pages, those before aren't
+	if seen[200] != 1 || seen[201] != 1 || seen[100] != 0 || seen[101] != 0 {
+		t.Errorf("expected only the sign ups after the cursor but saw %v", seen)
+	}
+}
+
+//TestFindAfterKeysFollowChanges checks that pages after removals and
+//snapshot loads still find each value once, under its first key
+func TestFindAfterKeysFollowChanges(t *testing.T) {
+	testTrie := NewTrieNode()
+	testTrie.Add("adam", 3)
+	testTrie.Add("adams", 3)
+	testTrie.Add("adamo", 1)
+	testTrie.Add("adamx", 2)
+	testTrie.Add("adamz", 4)
+	testTrie.RemoveValues(4)
+
+	// 3 is only under "adams" now, so it's on the second page
+	testTrie.Remove("adam", 3)
+	expected := [][]int64{{1, 3}, {2}}
+	if pages := readPages(testTrie, "ad", 2); !reflect.DeepEqual(pages, expected) {
+		t.Errorf("after removing: expected %v but got %v", expected, pages)
+	}
+
+	testTrie.Add("adam", 3)
+	var buf bytes.Buffer
+	if _, err := testTrie.WriteTo(&buf); err != nil {
+		t.Fatalf("error writing snapshot: %v", err)
+	}
+	loaded := NewTrieNode()
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("error reading snapshot: %v", err)
+	}
+	expected = [][]int64{{3, 1}, {2}}
+	if pages := readPages(loaded, "ad", 2); !reflect.DeepEqual(pages, expected) {
+		t.Errorf("after loading: expected %v but got %v", expected, pages)
+	}
+}
@@ -0,0 +1,259 @@
+package indexes
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+//RadixTrie is a compressed trie mapping strings to int64s that is safe
+//for concurrent use. Unlike TrieNode, which has a node, a map and a set
+//for every rune, each RadixTrie node holds a whole run of key bytes with
+//no branches, and its children and values are sorted slices. It uses a
+//fraction of the memory for large indexes, and only supports the Index
+//methods. Keys and prefixes are normalized like TrieNode's.
+type RadixTrie struct {
+	root   radixNode
+	size   int          // Number of key/value pairs
+	mx     sync.RWMutex // Read/write mutex
+	normal Normalizer   // Applied to keys and prefixes
+}
+
+//radixNode is one edge of a RadixTrie and the node it leads to. Keys
+//are split on bytes rather than runes; since UTF-8 is self-synchronizing
+//and sorts like code points, prefixes and order are the same as TrieNode's.
+type radixNode struct {
+	prefix   string       // Key bytes on the edge from the parent
+	children []*radixNode // Sorted by the first byte of their prefix
+	values   []int64      // Sorted values of the key ending here
+}
+
+//NewRadixTrie constructs a new RadixTrie that normalizes keys with Normalize.
+func NewRadixTrie() *RadixTrie {
+	return &RadixTrie{}
+}
+
+//NewRadixTrieWithNormalizer constructs a new RadixTrie that
+//normalizes keys and prefixes with the given Normalizer.
+func NewRadixTrieWithNormalizer(normal Normalizer) *RadixTrie {
+	return &RadixTrie{normal: normal}
+}
+
+//normalize applies the trie's Normalizer to a key or prefix
+func (t *RadixTrie) normalize(key string) string {
+	if t.normal == nil {
+		return Normalize(key)
+	}
+	return t.normal(key)
+}
+
+//Len returns the number of entries in the trie.
+func (t *RadixTrie) Len() int {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+	return t.size
+}
+
+//Add adds a key and value to the trie.
+func (t *RadixTrie) Add(key string, value int64) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	key = t.normalize(key)
+	// a key made only of marks normalizes to nothing
+	if key == "" {
+		return
+	}
+	if t.root.add(key, value) {
+		t.size++
+	}
+}
+
+//add is a private helper method that adds a key and value below
+//the node, splitting edges where the key leaves them. It returns
+//true if the pair wasn't already in the trie.
+func (n *radixNode) add(key string, value int64) bool {
+	for key != "" {
+		idx, child := n.child(key[0])
+		if child == nil {
+			// copy the rest of the key so the node doesn't keep
+			// the whole normalized key alive
+			leaf := &radixNode{prefix: strings.Clone(key), values: []int64{value}}
+			n.children = append(n.children, nil)
+			copy(n.children[idx+1:], n.children[idx:])
+			n.children[idx] = leaf
+			return true
+		}
+		common := commonPrefixLen(key, child.prefix)
+		if common < len(child.prefix) {
+			// the key leaves this edge part way, so split it there
+			split := &radixNode{prefix: child.prefix[:common], children: []*radixNode{child}}
+			child.prefix = child.prefix[common:]
+			n.children[idx] = split
+			child = split
+		}
+		key = key[common:]
+		n = child
+	}
+	return n.addValue(value)
+}
+
+//addValue adds a value to the node's sorted values and returns
+//true if the value wasn't already there
+func (n *radixNode) addValue(value int64) bool {
+	idx := sort.Search(len(n.values), func(i int) bool { return n.values[i] >= value })
+	if idx < len(n.values) && n.values[idx] == value {
+		return false
+	}
+	n.values = append(n.values, 0)
+	copy(n.values[idx+1:], n.values[idx:])
+	n.values[idx] = value
+	return true
+}
+
+//child returns the child whose prefix starts with b, or nil and
+//the index a child starting with b would be inserted at
+func (n *radixNode) child(b byte) (int, *radixNode) {
+	idx := sort.Search(len(n.children), func(i int) bool { return n.children[i].prefix[0] >= b })
+	if idx < len(n.children) && n.children[idx].prefix[0] == b {
+		return idx, n.children[idx]
+	}
+	return idx, nil
+}
+
+//commonPrefixLen returns the number of leading bytes a and b share
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+//Find finds `max` values matching `prefix`. If the trie
+//is entirely empty, or the prefix is empty, or max == 0,
+//or the prefix is not found, this returns a nil slice.
+func (t *RadixTrie) Find(prefix string, max int) []int64 {
+	t.mx.RLock()
+	defer t.mx.RUnlock()
+
+	prefix = t.normalize(prefix)
+	if t.size == 0 || prefix == "" || max <= 0 {
+		return nil
+	}
+	return t.root.find(prefix, max)
+}
+
+//find finds `max` values below the node whose keys start with `prefix`
+func (n *radixNode) find(prefix string, max int) []int64 {
+	// follow edges until the prefix runs out, possibly part way along one
+	for prefix != "" {
+		_, child := n.child(prefix[0])
+		if child == nil {
+			return nil
+		}
+		if len(prefix) <= len(child.prefix) {
+			if !strings.HasPrefix(child.prefix, prefix) {
+				return nil
+			}
+			n = child
+			break
+		}
+		if !strings.HasPrefix(prefix, child.prefix) {
+			return nil
+		}
+		prefix = prefix[len(child.prefix):]
+		n = child
+	}
+	var returnSlice []int64
+	n.findDFS(&returnSlice, max)
+	return returnSlice
+}
+
+//findDFS is a private function that recursively goes down the trie to return a slice of results
+func (n *radixNode) findDFS(list *[]int64, max int) {
+	canGet := max - len(*list)
+	if len(n.values) >= canGet {
+		*list = append(*list, n.values[:canGet]...)
+		return
+	}
+	*list = append(*list, n.values...)
+	for _, child := range n.children {
+		child.findDFS(list, max)
+		if len(*list) == max {
+			return
+		}
+	}
+}
+
+//Remove removes a key/value pair from the trie, and merges
+//or trims edges left without values or branches.
+func (t *RadixTrie) Remove(key string, value int64) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	key = t.normalize(key)
+	if key == "" {
+		return
+	}
+	if t.root.remove(key, value) {
+		t.size--
+	}
+}
+
+//remove removes the pair below the node and returns true if it was there
+func (n *radixNode) remove(key string, value int64) bool {
+	if key == "" {
+		return n.removeValue(value)
+	}
+	idx, child := n.child(key[0])
+	// key is not in the trie, nothing to remove
+	if child == nil || !strings.HasPrefix(key, child.prefix) {
+		return false
+	}
+	if !child.remove(key[len(child.prefix):], value) {
+		return false
+	}
+	n.compact(idx)
+	return true
+}
+
+//removeValue removes a value from the node's sorted values and
+//returns true if it was there
+func (n *radixNode) removeValue(value int64) bool {
+	idx := sort.Search(len(n.values), func(i int) bool { return n.values[i] >= value })
+	if idx == len(n.values) || n.values[idx] != value {
+		return false
+	}
+	n.values = append(n.values[:idx], n.values[idx+1:]...)
+	if len(n.values) == 0 {
+		n.values = nil
+	}
+	return true
+}
+
+//compact removes the child at idx if it has no values or children,
+//or merges it with its only child if it has no values
+func (n *radixNode) compact(idx int) {
+	child := n.children[idx]
+	if len(child.values) != 0 {
+		return
+	}
+	switch len(child.children) {
+	case 0:
+		copy(n.children[idx:], n.children[idx+1:])
+		// don't keep the removed child alive in the spare capacity
+		n.children[len(n.children)-1] = nil
+		n.children = n.children[:len(n.children)-1]
+		if len(n.children) == 0 {
+			n.children = nil
+		}
+	case 1:
+		// merge into a new node, since a CopyOnWriteTrie's readers
+		// may still be using the grandchild
+		grandchild := child.children[0]
+		n.children[idx] = &radixNode{
+			prefix:   child.prefix + grandchild.prefix,
+			children: grandchild.children,
+			values:   grandchild.values,
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package indexes
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+	"testing/quick"
+)
+
+func TestRadixTrie(t *testing.T) {
+	testTrie := NewRadixTrie()
+	testTrie.Add("adam", 1)
+	testTrie.Add("ada", 2)
+	testTrie.Add("Ada", 3)
+	testTrie.Add("adele", 4)
+	testTrie.Add("José", 5)
+	testTrie.Add("jose", 6)
+	testTrie.Add("王小明", 7)
+	testTrie.Add("王", 8)
+	testTrie.Add("ada", 2)
+
+	cases := []struct {
+		prefix   string
+		max      int
+		expected []int64
+	}{
+		{"a", 10, []int64{2, 3, 1, 4}},
+		{"ad", 3, []int64{2, 3, 1}},
+		{"ada", 10, []int64{2, 3, 1}},
+		{"adam", 10, []int64{1}},
+		{"adamo", 10, nil},
+		{"ade", 10, []int64{4}},
+		{"b", 10, nil},
+		{"JOS", 10, []int64{5, 6}},
+		{"王", 10, []int64{8, 7}},
+		{"王小", 10, []int64{7}},
+		{"", 10, nil},
+		{"a", 0, nil},
+	}
+	for _, c := range cases {
+		if result := testTrie.Find(c.prefix, c.max); !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("prefix %q: expected %v but got %v", c.prefix, c.expected, result)
+		}
+	}
+	if l := testTrie.Len(); l != 8 {
+		t.Errorf("expected 8 entries but got %d", l)
+	}
+
+	testTrie.Remove("ada", 2)
+	testTrie.Remove("ada", 3)
+	testTrie.Remove("ada", 3)
+	testTrie.Remove("missing", 1)
+	if result := testTrie.Find("ad", 10); !reflect.DeepEqual(result, []int64{1, 4}) {
+		t.Errorf("expected [1 4] after removing ada but got %v", result)
+	}
+	if l := testTrie.Len(); l != 6 {
+		t.Errorf("expected 6 entries but got %d", l)
+	}
+	// "ad" is left with two branches and no values, so "ada" is gone
+	// and "adam" merges back into a single edge below it
+	_, ad := testTrie.root.child('a')
+	if ad == nil || ad.prefix != "ad" || len(ad.children) != 2 || ad.children[0].prefix != "am" {
+		t.Errorf("expected edges ad, am and ele after removing ada")
+	}
+}
+
+//indexOp is one Add or Remove for the property tests
+type indexOp struct {
+	remove bool
+	key    string
+	value  int64
+}
+
+//indexOps is a random sequence of operations, over few enough keys
+//and values that they share prefixes, collide and get removed again
+type indexOps []indexOp
+
+//indexTestRunes are the runes keys are made of, including multi byte
+//runes so edges get split in the middle of a rune's UTF-8 encoding
+var indexTestRunes = []rune("abAé́ß王玉")
+
+//Generate implements quick.Generator
+func (indexOps) Generate(r *rand.Rand, size int) reflect.Value {
+	ops := make(indexOps, r.Intn(size*4+1))
+	for i := range ops {
+		key := make([]rune, r.Intn(6))
+		for j := range key {
+			key[j] = indexTestRunes[r.Intn(len(indexTestRunes))]
+		}
+		ops[i] = indexOp{r.Intn(3) == 0, string(key), int64(r.Intn(8))}
+	}
+	return reflect.ValueOf(ops)
+}
+
+//indexesEqual returns an error describing how the indexes differ
+//for any prefix of the given keys, or nil if they are the same
+func indexesEqual(want, got Index, keys []string) error {
+	if want.Len() != got.Len() {
+		return fmt.Errorf("expected %d entries but got %d", want.Len(), got.Len())
+	}
+	for _, key := range keys {
+		runes := []rune(key)
+		for i := 0; i <= len(runes); i++ {
+			prefix := string(runes[:i])
+			for _, max := range []int{2, 100} {
+				expected, result := want.Find(prefix, max), got.Find(prefix, max)
+				if !reflect.DeepEqual(expected, result) {
+					return fmt.Errorf("prefix %q max %d: expected %v but got %v", prefix, max, expected, result)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func TestRadixTrieMatchesTrieNode(t *testing.T) {
+	property := func(ops indexOps) bool {
+		want, got := NewTrieNode(), NewRadixTrie()
+		keys := []string{"a", "王"}
+		for _, op := range ops {
+			if op.remove {
+				want.Remove(op.key, op.value)
+				got.Remove(op.key, op.value)
+			} else {
+				want.Add(op.key, op.value)
+				got.Add(op.key, op.value)
+			}
+			keys = append(keys, op.key)
+			if err := indexesEqual(want, got, []string{op.key}); err != nil {
+				t.Logf("after %+v: %v", op, err)
+				return false
+			}
+		}
+		if err := indexesEqual(want, got, keys); err != nil {
+			t.Logf("after all operations: %v", err)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 50}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRadixTrieRemoveAll(t *testing.T) {
+	property := func(ops indexOps) bool {
+		testTrie := NewRadixTrie()
+		for _, op := range ops {
+			testTrie.Add(op.key, op.value)
+		}
+		for _, op := range ops {
+			testTrie.Remove(op.key, op.value)
+		}
+		return testTrie.Len() == 0 && len(testTrie.root.children) == 0
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+//benchmarkKeys returns n names shaped like a user table's, with
+//lots of shared prefixes
+func benchmarkKeys(n int) []string {
+	first := []string{"ada", "alan", "grace", "adam", "alice", "anita", "barbara", "brian"}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s%d", first[i%len(first)], i)
+	}
+	return keys
+}
+
+func benchmarkIndexes() map[string]func() Index {
+	return map[string]func() Index{
+		"TrieNode":        func() Index { return NewTrieNode() },
+		"RadixTrie":       func() Index { return NewRadixTrie() },
+		"CopyOnWriteTrie": func() Index { return NewCopyOnWriteTrie() },
+	}
+}
+
+func BenchmarkIndexAdd(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	for name, newIndex := range benchmarkIndexes() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			index := newIndex()
+			for i := 0; i < b.N; i++ {
+				index.Add(keys[i%len(keys)], int64(i))
+			}
+		})
+	}
+}
+
+func BenchmarkIndexFind(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	for name, newIndex := range benchmarkIndexes() {
+		b.Run(name, func(b *testing.B) {
+			index := newIndex()
+			for i, key := range keys {
+				index.Add(key, int64(i))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index.Find("ada1", 20)
+			}
+		})
+	}
+}
+
+//BenchmarkIndexHeap reports the live heap each index uses per entry
+func BenchmarkIndexHeap(b *testing.B) {
+	keys := benchmarkKeys(100000)
+	for name, newIndex := range benchmarkIndexes() {
+		b.Run(name, func(b *testing.B) {
+			var before, after runtime.MemStats
+			var index Index
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				index = newIndex()
+				for i, key := range keys {
+					index.Add(key, int64(i))
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+			}
+			b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(index.Len()), "heap-B/entry")
+			runtime.KeepAlive(index)
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package indexes
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+//Field identifies which field of a record a key was taken from, such
+//as a user's username or last name, so matches can be weighted by
+//field. Fields are bit flags, since one key can come from several
+//fields of the same record.
+type Field uint8
+
+//RankOptionsOf weight the scores FindRanked gives its results.
+//Every match scores its completion, the prefix length over the key
+//length, which is 1 for an exact match and smaller for longer keys.
+//The weights below are added on top of that.
+type RankOptionsOf[V comparable] struct {
+	//ExactWeight is added when the key is exactly the prefix
+	ExactWeight float64
+	//FieldWeights is added for a key from the given field. A key
+	//from several fields gets the largest of their weights.
+	FieldWeights map[Field]float64
+	//Recency optionally reports how recently a value was active,
+	//from 0 for long ago or never to 1 for just now. It may be
+	//called by several searches at once.
+	Recency func(value V) float64
+	//RecencyWeight is multiplied by Recency and added
+	RecencyWeight float64
+}
+
+//RankOptions weight the scores a TrieNode's FindRanked gives its results
+type RankOptions = RankOptionsOf[int64]
+
+//ResultOf is a value found by FindRanked, with the key and fields of
+//its best match and that match's score
+type ResultOf[V comparable] struct {
+	Value  V
+	Key    string
+	Fields Field
+	Score  float64
+}
+
+//Result is a value found by a TrieNode's FindRanked
+type Result = ResultOf[int64]
+
+//fieldWeight returns the largest weight of the given fields
+func (o *RankOptionsOf[V]) fieldWeight(fields Field) float64 {
+	best := 0.0
+	for field, weight := range o.FieldWeights {
+		if fields&field != 0 && weight > best {
+			best = weight
+		}
+	}
+	return best
+}
+
+//score returns the score of a match on a key with the given fields
+func (o *RankOptionsOf[V]) score(prefixLen int, keyLen int, fields Field) float64 {
+	score := float64(prefixLen) / float64(keyLen)
+	if prefixLen == keyLen {
+		score += o.ExactWeight
+	}
+	return score + o.fieldWeight(fields)
+}
+
+//recency returns the weighted recency of a value, clamped to the weight
+func (o *RankOptionsOf[V]) recency(value V) float64 {
+	if o.Recency == nil || o.RecencyWeight == 0 {
+		return 0
+	}
+	recency := o.Recency(value)
+	if recency < 0 {
+		recency = 0
+	} else if recency > 1 {
+		recency = 1
+	}
+	return recency * o.RecencyWeight
+}
+
+//addField records that value's key here came from field
+func (t *trieNode[V]) addField(value V, field Field) {
+	if field == 0 {
+		return
+	}
+	if t.fields == nil {
+		t.fields = make(map[V]Field)
+	}
+	t.fields[value] |= field
+}
+
+//FindRanked finds the `max` best values matching `query`, best first.
+//Unlike Find, it walks the keys under the prefix shortest first and
+//keeps the `max` best scores, so a short exact match is never dropped
+//for longer keys that sort earlier. Since longer keys complete less of
+//the prefix, it stops once no longer key could beat the worst of them.
+//A query of several words, such as "ada lovelace", only matches values
+//that have a key starting with each word; their score is the sum of
+//each word's best match, and their Key is those keys in query order.
+//Ties are ordered by key, then by the trie's less function. A nil opts
+//scores by completion alone. If the trie is entirely empty, or the
+//query is empty, or max == 0, or any word is not found, this returns
+//a nil slice.
+func (t *Trie[V]) FindRanked(query string, max int, opts *RankOptionsOf[V]) []ResultOf[V] {
+	tokens := strings.Fields(t.normalize(query))
+	current := t.load()
+	if len(current.root.children) == 0 || len(tokens) == 0 || max <= 0 {
+		return nil
+	}
+	if opts == nil {
+		opts = &RankOptionsOf[V]{}
+	}
+
+	// walk the keys of the longest word, which usually matches the
+	// fewest, and score each value found on all the words at once
+	first := 0
+	for idx, token := range tokens {
+		if current.root.node(token) == nil {
+			return nil
+		}
+		if len(token) > len(tokens[first]) {
+			first = idx
+		}
+	}
+	prefixLen := utf8.RuneCountInString(tokens[first])
+
+	// the most the other words, a key's field and recency can add to a
+	// score, so the walk can tell when no longer key can make the cut
+	maxField := 0.0
+	for _, weight := range opts.FieldWeights {
+		maxField = math.Max(maxField, weight)
+	}
+	rest := maxField
+	for idx := range tokens {
+		if idx != first {
+			rest += 1 + math.Max(opts.ExactWeight, 0) + maxField
+		}
+	}
+	if opts.Recency != nil {
+		rest += math.Max(opts.RecencyWeight, 0)
+	}
+
+	r := &ranker[V]{
+		version: current,
+		tokens:  tokens,
+		opts:    opts,
+		seen:    make(set[V]),
+		best:    &rankHeap[V]{less: t.less},
+		max:     max,
+	}
+	level := []*trieNode[V]{current.root.node(tokens[first])}
+	var next []*trieNode[V]
+	for depth := 0; len(level) > 0; depth++ {
+		for _, node := range level {
+			for value := range node.values {
+				r.consider(value)
+			}
+		}
+		// every key below is at least depth+1 longer than the prefix
+		bound := float64(prefixLen)/float64(prefixLen+depth+1) + rest
+		if r.best.Len() == max && r.best.results[0].Score > bound {
+			break
+		}
+		next = next[:0]
+		for _, node := range level {
+			for _, child := range node.children {
+				next = append(next, child)
+			}
+		}
+		level, next = next, level
+	}
+
+	results := r.best.results
+	sort.Slice(results, func(i, j int) bool {
+		return r.best.better(results[i], results[j])
+	})
+	return results
+}
+
+//node returns the node for the given key, or nil if there is none
+func (t *trieNode[V]) node(key string) *trieNode[V] {
+	triePointer := t
+	for _, s := range key {
+		if triePointer = triePointer.children[s]; triePointer == nil {
+			return nil
+		}
+	}
+	return triePointer
+}
+
+//ranker keeps the best results of FindRanked
+type ranker[V comparable] struct {
+	version *trieVersion[V]   // version searched
+	tokens  []string          // words of the query
+	opts    *RankOptionsOf[V] // weights of the scores
+	seen    set[V]            // values already scored
+	best    *rankHeap[V]      // best results so far, worst first
+	max     int               // number of results to keep
+}
+
+//consider scores a value the first time it's found, from its best
+//match on each word, and keeps it if it's among the best so far
+func (r *ranker[V]) consider(value V) {
+	if !r.seen.add(value) {
+		return
+	}
+	keys := r.version.keysOf(value)
+	result := ResultOf[V]{Value: value}
+	matched := make([]string, len(r.tokens))
+	for idx, token := range r.tokens {
+		tokenLen := utf8.RuneCountInString(token)
+		found := false
+		var best ResultOf[V]
+		for key := range keys {
+			if !strings.HasPrefix(key, token) {
+				continue
+			}
+			// keys may be a write ahead of the version searched
+			node := r.version.root.node(key)
+			if node == nil || !node.values.has(value) {
+				continue
+			}
+			fields := node.fields[value]
+			score := r.opts.score(tokenLen, utf8.RuneCountInString(key), fields)
+			if !found || score > best.Score || (score == best.Score && key < best.Key) {
+				best = ResultOf[V]{Key: key, Fields: fields, Score: score}
+				found = true
+			}
+		}
+		if !found {
+			return
+		}
+		matched[idx] = best.Key
+		result.Fields |= best.Fields
+		result.Score += best.Score
+	}
+	result.Key = strings.Join(matched, " ")
+	result.Score += r.opts.recency(value)
+
+	if r.best.Len() < r.max {
+		heap.Push(r.best, result)
+	} else if r.best.better(result, r.best.results[0]) {
+		r.best.results[0] = result
+		heap.Fix(r.best, 0)
+	}
+}
+
+//rankHeap is a heap of results with the worst on top
+type rankHeap[V comparable] struct {
+	results []ResultOf[V]
+	less    func(a, b V) bool // trie's value order, for ties
+}
+
+//better returns true if a ranks before b
+func (h *rankHeap[V]) better(a, b ResultOf[V]) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if a.Key != b.Key || h.less == nil {
+		return a.Key < b.Key
+	}
+	return h.less(a.Value, b.Value)
+}
+
+func (h *rankHeap[V]) Len() int           { return len(h.results) }
+func (h *rankHeap[V]) Less(i, j int) bool { return h.better(h.results[j], h.results[i]) }
+func (h *rankHeap[V]) Swap(i, j int)      { h.results[i], h.results[j] = h.results[j], h.results[i] }
+func (h *rankHeap[V]) Push(x any)         { h.results = append(h.results, x.(ResultOf[V])) }
+func (h *rankHeap[V]) Pop() any {
+	last := h.results[len(h.results)-1]
+	h.results = h.results[:len(h.results)-1]
+	return last
+}
@@ -0,0 +1,313 @@
+package indexes
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+const (
+	testFirstName Field = 1 << iota
+	testLastName
+	testUserName
+)
+
+//testRanking mirrors how users are ranked: exact matches first,
+//then usernames, then first and last names
+var testRanking = &RankOptions{
+	ExactWeight: 4,
+	FieldWeights: map[Field]float64{
+		testUserName:  2,
+		testFirstName: 1,
+		testLastName:  1,
+	},
+}
+
+func TestFindRanked(t *testing.T) {
+	type entry struct {
+		key   string
+		value int64
+		field Field
+	}
+	cases := []struct {
+		name     string
+		entries  []entry
+		prefix   string
+		max      int
+		opts     *RankOptions
+		expected []int64
+	}{
+		{
+			"Exact Match First",
+			[]entry{{"adam", 1, testUserName}, {"ada", 2, testFirstName}},
+			"ada",
+			10,
+			testRanking,
+			[]int64{2, 1},
+		},
+		{
+			"Username Before Names",
+			[]entry{{"alan", 1, testFirstName}, {"alice", 2, testUserName}},
+			"al",
+			10,
+			testRanking,
+			[]int64{2, 1},
+		},
+		{
+			"Shorter Completion First",
+			[]entry{{"robertson", 1, testLastName}, {"roberts", 2, testLastName}, {"robert", 3, testFirstName}},
+			"rob",
+			10,
+			testRanking,
+			[]int64{3, 2, 1},
+		},
+		{
+			"Best Field Of Same Key",
+			[]entry{{"lee", 1, testLastName}, {"leeds", 2, testUserName}, {"lee", 1, testUserName}},
+			"le",
+			10,
+			testRanking,
+			[]int64{1, 2},
+		},
+		{
+			"Best Key Of Same Value",
+			[]entry{{"smithson", 1, testUserName}, {"smith", 1, testLastName}, {"smithers", 2, testUserName}},
+			"smith",
+			10,
+			testRanking,
+			[]int64{1, 2},
+		},
+		{
+			"Max Keeps Best Not First",
+			[]entry{{"aaron", 1, testFirstName}, {"abby", 2, testFirstName}, {"a", 3, testUserName}},
+			"a",
+			1,
+			testRanking,
+			[]int64{3},
+		},
+		{
+			"Nil Options Ranks By Completion",
+			[]entry{{"abcd", 1, testUserName}, {"ab", 2, testLastName}},
+			"ab",
+			10,
+			nil,
+			[]int64{2, 1},
+		},
+		{
+			"Ties Ordered By Key",
+			[]entry{{"bob", 2, 0}, {"bob", 1, 0}, {"boa", 3, 0}},
+			"bo",
+			10,
+			nil,
+			[]int64{3, 1, 2},
+		},
+		{
+			"Prefix Not Found",
+			[]entry{{"bob", 1, 0}},
+			"c",
+			10,
+			nil,
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		testTrie := NewTrieNode()
+		for _, e := range c.entries {
+			testTrie.AddField(e.key, e.value, e.field)
+		}
+		results := testTrie.FindRanked(c.prefix, c.max, c.opts)
+		if len(results) != len(c.expected) {
+			t.Errorf("case %s: expected %v but got %+v", c.name, c.expected, results)
+			continue
+		}
+		for idx, v := range c.expected {
+			if results[idx].Value != v {
+				t.Errorf("case %s: expected %v but got %+v", c.name, c.expected, results)
+				break
+			}
+			if idx > 0 && results[idx].Score > results[idx-1].Score {
+				t.Errorf("case %s: results are not ordered by score: %+v", c.name, results)
+			}
+		}
+	}
+}
+
+func TestFindRankedRecency(t *testing.T) {
+	testTrie := NewTrieNode()
+	testTrie.AddField("sam", 1, testUserName)
+	testTrie.AddField("sam", 2, testUserName)
+	recent := map[int64]float64{2: 1, 1: 0.1}
+	opts := &RankOptions{
+		FieldWeights:  testRanking.FieldWeights,
+		Recency:       func(value int64) float64 { return recent[value] },
+		RecencyWeight: 0.5,
+	}
+
+	results := testTrie.FindRanked("sa", 10, opts)
+	if len(results) != 2 || results[0].Value != 2 || results[1].Value != 1 {
+		t.Fatalf("expected recently active value first but got %+v", results)
+	}
+	if diff := results[0].Score - results[1].Score; math.Abs(diff-0.45) > 1e-9 {
+		t.Errorf("expected scores to differ by the weighted recency but got %+v", results)
+	}
+	if results[0].Fields != testUserName || results[0].Key != "sam" {
+		t.Errorf("expected the match's key and field in the result but got %+v", results[0])
+	}
+}
+
+func TestRemoveClearsFields(t *testing.T) {
+	testTrie := NewTrieNode()
+	testTrie.AddField("kim", 1, testUserName)
+	testTrie.Remove("kim", 1)
+	testTrie.Add("kim", 1)
+	results := testTrie.FindRanked("kim", 10, testRanking)
+	if len(results) != 1 || results[0].Fields != 0 {
+		t.Errorf("expected re-added value with no fields but got %+v", results)
+	}
+}
+
+func TestFindRankedMultiTerm(t *testing.T) {
+	type entry struct {
+		key   string
+		value int64
+		field Field
+	}
+	users := []entry{
+		{"ada", 1, testFirstName}, {"lovelace", 1, testLastName}, {"countess", 1, testUserName},
+		{"ada", 2, testFirstName}, {"yonath", 2, testLastName}, {"ayonath", 2, testUserName},
+		{"alan", 3, testFirstName}, {"turing", 3, testLastName}, {"aturing", 3, testUserName},
+		{"adam", 4, testFirstName}, {"lovell", 4, testLastName}, {"alovell", 4, testUserName},
+	}
+	cases := []struct {
+		name        string
+		query       string
+		expected    []int64
+		expectedKey string
+	}{
+		{"Full Name", "ada lovelace", []int64{1}, "ada lovelace"},
+		{"Both Prefixes", "ad lov", []int64{1, 4}, "ada lovelace"},
+		{"Any Word Order", "lovelace ada", []int64{1}, "lovelace ada"},
+		{"Extra Whitespace", "  ada   yon ", []int64{2}, "ada yonath"},
+		{"Exact Words Rank First", "ada lovel", []int64{1, 4}, "ada lovelace"},
+		{"Username And Name", "aturing alan", []int64{3}, "aturing alan"},
+		{"One Word Missing", "ada hopper", nil, ""},
+		{"No User Has Both", "alan lovelace", nil, ""},
+		{"Case And Accents", "ÁDA LOVE", []int64{1, 4}, "ada lovelace"},
+	}
+
+	testTrie := NewTrieNode()
+	for _, u := range users {
+		testTrie.AddField(u.key, u.value, u.field)
+	}
+	for _, c := range cases {
+		results := testTrie.FindRanked(c.query, 10, testRanking)
+		if len(results) != len(c.expected) {
+			t.Errorf("case %s: expected %v but got %+v", c.name, c.expected, results)
+			continue
+		}
+		for idx, v := range c.expected {
+			if results[idx].Value != v {
+				t.Errorf("case %s: expected %v but got %+v", c.name, c.expected, results)
+				break
+			}
+		}
+		if len(results) > 0 && results[0].Key != c.expectedKey {
+			t.Errorf("case %s: expected key %q but got %q", c.name, c.expectedKey, results[0].Key)
+		}
+	}
+}
+
+func TestFindRankedMultiTermScore(t *testing.T) {
+	testTrie := NewTrieNode()
+	testTrie.AddField("grace", 1, testFirstName)
+	testTrie.AddField("hopper", 1, testLastName)
+
+	single := testTrie.FindRanked("grace", 10, testRanking)
+	both := testTrie.FindRanked("grace hop", 10, testRanking)
+	if len(single) != 1 || len(both) != 1 {
+		t.Fatalf("expected one result each but got %+v and %+v", single, both)
+	}
+	if both[0].Score <= single[0].Score {
+		t.Errorf("expected more matched words to score higher: %v <= %v", both[0].Score, single[0].Score)
+	}
+	if both[0].Fields != testFirstName|testLastName {
+		t.Errorf("expected both fields but got %b", both[0].Fields)
+	}
+}
+
+//TestFindRankedStopsEarly checks that stopping the walk once no longer
+//key can make the cut finds the same results as scoring every match
+func TestFindRankedStopsEarly(t *testing.T) {
+	property := func(ops indexOps, seed int64) bool {
+		testTrie := NewTrieNode()
+		for _, op := range ops {
+			if op.remove {
+				testTrie.Remove(op.key, op.value)
+			} else {
+				testTrie.AddField(op.key, op.value, Field(1<<(op.value%3)))
+			}
+		}
+		opts := &RankOptions{
+			ExactWeight:   testRanking.ExactWeight,
+			FieldWeights:  testRanking.FieldWeights,
+			Recency:       func(value int64) float64 { return float64((value+seed)%5) / 4 },
+			RecencyWeight: 0.5,
+		}
+		for _, query := range []string{"a", "b", "ab", "a b", "王", "é a"} {
+			all := testTrie.FindRanked(query, 100, opts)
+			for _, max := range []int{1, 2, 3} {
+				expected := all
+				if len(expected) > max {
+					expected = expected[:max]
+				}
+				if result := testTrie.FindRanked(query, max, opts); !reflect.DeepEqual(result, expected) {
+					t.Logf("query %q max %d: expected %+v but got %+v", query, max, expected, result)
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+//BenchmarkFindRanked compares ranked search of a large trie with Find,
+//which stops at the first `max` values in key order
+func BenchmarkFindRanked(b *testing.B) {
+	testTrie := NewTrieNode()
+	for i, key := range benchmarkKeys(200000) {
+		testTrie.AddField(key, int64(i), Field(1<<(i%3)))
+	}
+	for _, prefix := range []string{"a", "ada1"} {
+		b.Run("Find/"+prefix, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				testTrie.Find(prefix, 20)
+			}
+		})
+		b.Run("FindRanked/"+prefix, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				testTrie.FindRanked(prefix, 20, testRanking)
+			}
+		})
+	}
+}
+
+func BenchmarkFindRankedMultiTerm(b *testing.B) {
+	testTrie := NewTrieNode()
+	first := []string{"ada", "alan", "grace", "adam", "alice", "anita"}
+	last := []string{"lovelace", "turing", "hopper", "lovell", "liskov", "borg"}
+	for i := int64(0); i < 10000; i++ {
+		testTrie.AddField(first[i%6], i, testFirstName)
+		testTrie.AddField(last[(i/6)%6], i, testLastName)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		testTrie.FindRanked("a lov", 20, testRanking)
+	}
+}
@@ -2,12 +2,15 @@ package indexes
 
 import "sort"
 
+//set is a set of values
+type set[V comparable] map[V]struct{}
+
 //int64set is a set of int64 values
-type int64set map[int64]struct{}
+type int64set = set[int64]
 
 //add adds a value to the set and returns
 //true if the value didn't already exist in the set.
-func (s int64set) add(value int64) bool {
+func (s set[V]) add(value V) bool {
 	// `ok` is true if value is within set
 	_, ok := s[value]
 	if ok {
@@ -21,7 +24,7 @@ func (s int64set) add(value int64) bool {
 
 //remove removes a value from the set and returns
 //true if that value was in the set, false otherwise.
-func (s int64set) remove(value int64) bool {
+func (s set[V]) remove(value V) bool {
 	_, ok := s[value]
 	//value is in the set
 	if ok {
@@ -34,20 +37,29 @@ func (s int64set) remove(value int64) bool {
 
 //has returns true if value is in the set,
 //or false if it is not in the set.
-func (s int64set) has(value int64) bool {
+func (s set[V]) has(value V) bool {
 	_, ok := s[value]
 	return ok
 }
 
 //all returns all values in the set as a slice.
 //The returned slice will always be non-nil, but
-//the order will be random. Use sort.Slice to
-//sort the slice if necessary.
-func (s int64set) all() []int64 {
-	int64Values := []int64{}
+//the order will be random. Use sorted to sort
+//the slice if necessary.
+func (s set[V]) all() []V {
+	values := []V{}
 	for value := range s {
-		int64Values = append(int64Values, value)
+		values = append(values, value)
+	}
+	return values
+}
+
+//sorted returns all values in the set as a slice, sorted
+//with less. If less is nil the order will be random.
+func (s set[V]) sorted(less func(a, b V) bool) []V {
+	values := s.all()
+	if less != nil {
+		sort.Slice(values, func(i, j int) bool { return less(values[i], values[j]) })
 	}
-	sort.Slice(int64Values, func(i, j int) bool { return int64Values[i] < int64Values[j] })
-	return int64Values
+	return values
 }
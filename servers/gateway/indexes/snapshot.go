@@ -0,0 +1,207 @@
+package indexes
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+//A snapshot is laid out as:
+//
+//	magic    "TRIE"
+//	version  uint16, big endian
+//	count    uvarint number of entries
+//	entries  count times: uvarint key length, normalized key bytes,
+//	         varint value, fields byte
+//	checksum uint32 CRC-32C of everything before it, big endian
+//
+//Keys are stored normalized and are not normalized again when read,
+//so the version must change whenever Normalize does.
+
+//snapshotMagic starts every trie snapshot
+const snapshotMagic = "TRIE"
+
+//SnapshotVersion is the version of the snapshot format WriteTo writes
+const SnapshotVersion = 1
+
+//maxSnapshotKeyLen bounds key lengths, so a corrupt snapshot can't
+//make ReadFrom allocate huge buffers before the checksum is checked
+const maxSnapshotKeyLen = 1 << 16
+
+//ErrSnapshotFormat is returned when a snapshot is not a trie snapshot
+var ErrSnapshotFormat = errors.New("not a trie snapshot")
+
+//ErrSnapshotVersion is returned when a snapshot has an unsupported version
+var ErrSnapshotVersion = errors.New("unsupported trie snapshot version")
+
+//ErrSnapshotChecksum is returned when a snapshot is corrupt
+var ErrSnapshotChecksum = errors.New("trie snapshot checksum mismatch")
+
+//crcTable is the Castagnoli table used for snapshot checksums
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//snapshotEntry is one key/value pair in a snapshot
+type snapshotEntry[V comparable] struct {
+	key    []rune
+	value  V
+	fields Field
+}
+
+//WriteTo writes a snapshot of the trie to w, in key order, and returns
+//the number of bytes written. It implements io.WriterTo.
+func (t *TrieNode) WriteTo(w io.Writer) (int64, error) {
+	current := t.load()
+	sw := &snapshotWriter{w: w, crc: crc32.New(crcTable)}
+	sw.write([]byte(snapshotMagic))
+	sw.write(binary.BigEndian.AppendUint16(nil, SnapshotVersion))
+	sw.write(binary.AppendUvarint(nil, uint64(current.size)))
+	current.root.entriesDFS(nil, t.less, func(e snapshotEntry[int64]) bool {
+		key := string(e.key)
+		buf := binary.AppendUvarint(nil, uint64(len(key)))
+		buf = append(buf, key...)
+		buf = binary.AppendVarint(buf, e.value)
+		buf = append(buf, byte(e.fields))
+		sw.write(buf)
+		return sw.err == nil
+	})
+	// the checksum itself is not part of the checksum
+	sum := sw.crc.Sum32()
+	sw.crc = nil
+	sw.write(binary.BigEndian.AppendUint32(nil, sum))
+	return sw.n, sw.err
+}
+
+//ReadFrom replaces the contents of the trie with a snapshot read from r,
+//and returns the number of bytes read. It reads exactly the snapshot,
+//so more data may follow it in r. The trie is left unchanged unless the
+//whole snapshot is read and its checksum matches. It implements
+//io.ReaderFrom.
+func (t *TrieNode) ReadFrom(r io.Reader) (int64, error) {
+	sr := &snapshotReader{r: r, crc: crc32.New(crcTable)}
+
+	magic := make([]byte, len(snapshotMagic))
+	if err := sr.readFull(magic); err != nil {
+		return sr.n, err
+	}
+	if string(magic) != snapshotMagic {
+		return sr.n, ErrSnapshotFormat
+	}
+	version := make([]byte, 2)
+	if err := sr.readFull(version); err != nil {
+		return sr.n, err
+	}
+	if v := binary.BigEndian.Uint16(version); v != SnapshotVersion {
+		return sr.n, fmt.Errorf("%w: %d", ErrSnapshotVersion, v)
+	}
+	count, err := binary.ReadUvarint(sr)
+	if err != nil {
+		return sr.n, err
+	}
+
+	fresh := newTrieVersion[int64]()
+	for i := uint64(0); i < count; i++ {
+		keyLen, err := binary.ReadUvarint(sr)
+		if err != nil {
+			return sr.n, err
+		}
+		if keyLen == 0 || keyLen > maxSnapshotKeyLen {
+			return sr.n, ErrSnapshotFormat
+		}
+		key := make([]byte, keyLen)
+		if err := sr.readFull(key); err != nil {
+			return sr.n, err
+		}
+		value, err := binary.ReadVarint(sr)
+		if err != nil {
+			return sr.n, err
+		}
+		fields, err := sr.ReadByte()
+		if err != nil {
+			return sr.n, err
+		}
+		fresh.insert([]rune(string(key)), value, Field(fields))
+	}
+
+	sum := sr.crc.Sum32()
+	sr.crc = nil
+	checksum := make([]byte, 4)
+	if err := sr.readFull(checksum); err != nil {
+		return sr.n, err
+	}
+	if binary.BigEndian.Uint32(checksum) != sum {
+		return sr.n, ErrSnapshotChecksum
+	}
+
+	t.publish(fresh)
+	return sr.n, nil
+}
+
+//entriesDFS is a private function that calls fn with every entry at or
+//below this node, whose key is `key`, in key order, until fn returns false
+func (t *trieNode[V]) entriesDFS(key []rune, less func(a, b V) bool, fn func(e snapshotEntry[V]) bool) bool {
+	for _, value := range t.values.sorted(less) {
+		if !fn(snapshotEntry[V]{key, value, t.fields[value]}) {
+			return false
+		}
+	}
+	for _, r := range t.sortedChildren() {
+		if !t.children[r].entriesDFS(append(key[:len(key):len(key)], r), less, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+//snapshotWriter counts and checksums what it writes, and
+//remembers the first error so callers can check it once
+type snapshotWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	n   int64
+	err error
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	n, err := sw.w.Write(p)
+	sw.n += int64(n)
+	sw.err = err
+	if sw.crc != nil {
+		sw.crc.Write(p[:n])
+	}
+}
+
+//snapshotReader counts and checksums what it reads. It reads
+//byte by byte where needed, so it never reads past the snapshot;
+//wrap slow readers in a bufio.Reader.
+type snapshotReader struct {
+	r   io.Reader
+	crc hash.Hash32
+	n   int64
+	buf [1]byte
+}
+
+func (sr *snapshotReader) readFull(p []byte) error {
+	n, err := io.ReadFull(sr.r, p)
+	sr.n += int64(n)
+	if sr.crc != nil {
+		sr.crc.Write(p[:n])
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+//ReadByte implements io.ByteReader for reading varints
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	if err := sr.readFull(sr.buf[:]); err != nil {
+		return 0, err
+	}
+	return sr.buf[0], nil
+}
@@ -0,0 +1,171 @@
+package indexes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+//newSnapshotTestTrie returns a trie with multilingual keys and fields
+func newSnapshotTestTrie() *TrieNode {
+	testTrie := NewTrieNode()
+	testTrie.AddField("Ada", 1, testFirstName)
+	testTrie.AddField("Lovelace", 1, testLastName)
+	testTrie.AddField("ada", 1, testUserName)
+	testTrie.AddField("José", 2, testFirstName)
+	testTrie.AddField("王小明", 3, testUserName)
+	testTrie.Add("adam", 4)
+	return testTrie
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	original := newSnapshotTestTrie()
+	var buf bytes.Buffer
+	written, err := original.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error writing snapshot: %v", err)
+	}
+	if written != int64(buf.Len()) {
+		t.Errorf("WriteTo reported %d bytes but wrote %d", written, buf.Len())
+	}
+
+	// ReadFrom replaces whatever the trie held before
+	restored := NewTrieNode()
+	restored.Add("stale", 99)
+	read, err := restored.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading snapshot: %v", err)
+	}
+	if read != written {
+		t.Errorf("ReadFrom reported %d bytes but %d were written", read, written)
+	}
+
+	if restored.Len() != original.Len() {
+		t.Errorf("expected %d entries but got %d", original.Len(), restored.Len())
+	}
+	if found := restored.Find("stale", 10); found != nil {
+		t.Errorf("expected old entries to be replaced but found %v", found)
+	}
+	for _, prefix := range []string{"ada", "lov", "jose", "王", "adam"} {
+		want := original.FindRanked(prefix, 10, testRanking)
+		got := restored.FindRanked(prefix, 10, testRanking)
+		if len(got) != len(want) {
+			t.Errorf("prefix %s: expected %+v but got %+v", prefix, want, got)
+			continue
+		}
+		for idx := range want {
+			if got[idx] != want[idx] {
+				t.Errorf("prefix %s: expected %+v but got %+v", prefix, want, got)
+				break
+			}
+		}
+	}
+
+	// the same trie always writes the same bytes
+	var first, second bytes.Buffer
+	original.WriteTo(&first)
+	restored.WriteTo(&second)
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("expected identical snapshots from identical tries")
+	}
+}
+
+func TestSnapshotLeavesTrailingData(t *testing.T) {
+	var buf bytes.Buffer
+	newSnapshotTestTrie().WriteTo(&buf)
+	buf.WriteString("after")
+
+	if _, err := NewTrieNode().ReadFrom(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rest, _ := io.ReadAll(&buf); string(rest) != "after" {
+		t.Errorf("expected data after the snapshot to be left unread but got %q", rest)
+	}
+}
+
+func TestSnapshotEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewTrieNode().WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	restored := newSnapshotTestTrie()
+	if _, err := restored.ReadFrom(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.Len() != 0 {
+		t.Errorf("expected empty trie but got %d entries", restored.Len())
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	var buf bytes.Buffer
+	newSnapshotTestTrie().WriteTo(&buf)
+	valid := buf.Bytes()
+
+	corrupt := append([]byte(nil), valid...)
+	corrupt[len(corrupt)-10] ^= 0xff
+	badVersion := append([]byte(nil), valid...)
+	binary.BigEndian.PutUint16(badVersion[4:], SnapshotVersion+1)
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"Corrupt Entry", corrupt, ErrSnapshotChecksum},
+		{"Wrong Magic", append([]byte("JUNK"), valid[4:]...), ErrSnapshotFormat},
+		{"Newer Version", badVersion, ErrSnapshotVersion},
+		{"Truncated", valid[:len(valid)-2], io.ErrUnexpectedEOF},
+		{"Empty", nil, io.ErrUnexpectedEOF},
+	}
+
+	for _, c := range cases {
+		restored := NewTrieNode()
+		restored.Add("kept", 1)
+		_, err := restored.ReadFrom(bytes.NewReader(c.data))
+		if !errors.Is(err, c.expected) {
+			t.Errorf("case %s: expected %v but got %v", c.name, c.expected, err)
+		}
+		if found := restored.Find("kept", 10); len(found) != 1 {
+			t.Errorf("case %s: expected the trie to be unchanged after a failed read", c.name)
+		}
+	}
+}
+
+func TestValuesAndRemoveValues(t *testing.T) {
+	testTrie := newSnapshotTestTrie()
+	values := testTrie.Values()
+	if len(values) != 4 || values[0] != 1 || values[3] != 4 {
+		t.Fatalf("expected [1 2 3 4] but got %v", values)
+	}
+
+	testTrie.RemoveValues(1, 3)
+	if values := testTrie.Values(); len(values) != 2 || values[0] != 2 || values[1] != 4 {
+		t.Errorf("expected [2 4] but got %v", values)
+	}
+	if found := testTrie.Find("lov", 10); found != nil {
+		t.Errorf("expected every key of a removed value to be gone but found %v", found)
+	}
+	if found := testTrie.Find("ada", 10); len(found) != 1 || found[0] != 4 {
+		t.Errorf("expected shared prefixes to keep other values but got %v", found)
+	}
+	if l := testTrie.Len(); l != 2 {
+		t.Errorf("expected 2 entries left but got %d", l)
+	}
+}
+
+func BenchmarkSnapshotReadFrom(b *testing.B) {
+	testTrie := NewTrieNode()
+	for i := int64(0); i < 10000; i++ {
+		testTrie.AddField(string(rune('a'+i%26))+string(rune('a'+(i/26)%26))+"name", i, testFirstName)
+	}
+	var buf bytes.Buffer
+	testTrie.WriteTo(&buf)
+	data := buf.Bytes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewTrieNode().ReadFrom(bytes.NewReader(data))
+	}
+}
@@ -1,8 +1,11 @@
 package indexes
 
 import (
+	"container/heap"
+	"maps"
 	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 //PRO TIP: if you are having troubles and want to see
@@ -10,94 +13,265 @@ import (
 //either use the debugger, or try this package:
 //https://github.com/davecgh/go-spew
 
-//TrieNode implements a trie data structure mapping strings to int64s
-//that is safe for concurrent use.
-type TrieNode struct {
-	children map[rune]*TrieNode // Each letter will point to a new subTrieNode
-	values   int64set           // IDs for the same name will be stored in set
-	mx       sync.RWMutex       // Read/write mutex
+//Trie implements a trie data structure mapping strings to values of
+//any comparable type, such as IDs or channel names, that is safe for
+//concurrent use. Keys and search prefixes are normalized first, so
+//"José", "JOSE" and "ｊｏｓｅ" are all one key. Results are in key
+//order, and values of the same key are ordered by the trie's less
+//function; without one they are in no particular order.
+//
+//Readers never wait for writers. The nodes of a published version are
+//never changed: writers copy the nodes on the path to the key they
+//change, then swap in the new version atomically, so searches see
+//either the whole change or none of it without taking any lock.
+//Writers take turns.
+type Trie[V comparable] struct {
+	current atomic.Pointer[trieVersion[V]] // Published version, nil until the first write
+	mx      sync.Mutex                     // Serializes writers, never taken by readers
+	normal  Normalizer                     // Applied to keys and prefixes
+	less    func(a, b V) bool              // Orders values of the same key
 }
 
-//NewTrieNode constructs a new TrieNode.
-func NewTrieNode() *TrieNode {
-	return &TrieNode{}
+//trieNode is one node of a Trie. It is only changed in place while
+//the version holding it is being built, before it is published.
+type trieNode[V comparable] struct {
+	children map[rune]*trieNode[V] // Each letter will point to a new subTrie
+	values   set[V]                // Values for the same name will be stored in set
+	fields   map[V]Field           // Fields each value's key came from, if known
 }
 
-//Len returns the number of entries in the trie.
-func (t *TrieNode) Len() int {
-	t.mx.RLock()
-	defer t.mx.RUnlock()
-	return t.len()
+//trieVersion is one version of the contents of a Trie
+type trieVersion[V comparable] struct {
+	root *trieNode[V]
+	size int // Number of key/value pairs
+	//keys maps each value to the set[string] of keys it is stored
+	//under. Sets are replaced rather than changed. Versions made by
+	//Add and Remove share it, so it may be a write ahead of root;
+	//FindAfter only uses it to skip values.
+	keys *sync.Map
+}
+
+//NewTrie constructs a new Trie that normalizes keys with Normalize and
+//orders values of the same key with less, which may be nil.
+func NewTrie[V comparable](less func(a, b V) bool) *Trie[V] {
+	return &Trie[V]{less: less}
+}
+
+//NewTrieWithNormalizer constructs a new Trie that orders values of the
+//same key with less, which may be nil, and normalizes keys and prefixes
+//with the given Normalizer, such as FoldCase to keep accents significant.
+func NewTrieWithNormalizer[V comparable](less func(a, b V) bool, normal Normalizer) *Trie[V] {
+	return &Trie[V]{less: less, normal: normal}
+}
+
+//newTrieVersion returns an empty version
+func newTrieVersion[V comparable]() *trieVersion[V] {
+	return &trieVersion[V]{root: &trieNode[V]{}, keys: &sync.Map{}}
+}
+
+//load returns the published version, or an empty one before the first write
+func (t *Trie[V]) load() *trieVersion[V] {
+	if current := t.current.Load(); current != nil {
+		return current
+	}
+	return newTrieVersion[V]()
 }
 
-func (t *TrieNode) len() int {
-	entryCount := len(t.values)     // Find the length of self
-	for child := range t.children { // Find length of children
-		entryCount += t.children[child].len()
+//normalize applies the trie's Normalizer to a key or prefix
+func (t *Trie[V]) normalize(key string) string {
+	if t.normal == nil {
+		return Normalize(key)
 	}
-	return entryCount
+	return t.normal(key)
+}
+
+//Len returns the number of entries in the trie.
+func (t *Trie[V]) Len() int {
+	return t.load().size
 }
 
 //Add adds a key and value to the trie.
-func (t *TrieNode) Add(key string, value int64) {
+func (t *Trie[V]) Add(key string, value V) {
+	t.AddField(key, value, 0)
+}
+
+//AddField adds a key and value to the trie, recording which field
+//of the value's record the key came from so FindRanked can weight it.
+func (t *Trie[V]) AddField(key string, value V, field Field) {
+	// normalize before taking the lock, it's the slowest part
+	runes := []rune(t.normalize(key))
+	// a key made only of marks normalizes to nothing
+	if len(runes) == 0 {
+		return
+	}
 	t.mx.Lock()
 	defer t.mx.Unlock()
-	runes := []rune(key)
-	t.add(runes, value)
+	current := t.load()
+	root, added := current.root.with(runes, value, field)
+	if root == current.root {
+		return
+	}
+	next := &trieVersion[V]{root: root, size: current.size, keys: current.keys}
+	if added {
+		next.size++
+	}
+	next.addKey(value, string(runes))
+	t.current.Store(next)
+}
+
+//insert adds a key and value to a version that isn't published yet,
+//changing its nodes in place, and records the key among the value's keys
+func (v *trieVersion[V]) insert(key []rune, value V, field Field) {
+	if v.root.add(key, value, field) {
+		v.size++
+	}
+	v.addKey(value, string(key))
+}
+
+//keysOf returns the keys value is stored under, which must not be changed
+func (v *trieVersion[V]) keysOf(value V) set[string] {
+	keys, _ := v.keys.Load(value)
+	s, _ := keys.(set[string])
+	return s
+}
+
+//addKey records key among the keys of value
+func (v *trieVersion[V]) addKey(value V, key string) {
+	keys := v.keysOf(value)
+	if keys.has(key) {
+		return
+	}
+	keys = maps.Clone(keys)
+	if keys == nil {
+		keys = make(set[string])
+	}
+	keys.add(key)
+	v.keys.Store(value, keys)
 }
 
-// add is a private helper method that adds a key and value to the trie
-func (t *TrieNode) add(key []rune, value int64) {
+//removeKey removes key from the keys of value
+func (v *trieVersion[V]) removeKey(value V, key string) {
+	keys := v.keysOf(value)
+	if !keys.has(key) {
+		return
+	}
+	if len(keys) == 1 {
+		v.keys.Delete(value)
+		return
+	}
+	keys = maps.Clone(keys)
+	keys.remove(key)
+	v.keys.Store(value, keys)
+}
+
+// add is a private helper method that adds a key and value below the
+// node in place, and returns true if the pair wasn't already there
+func (t *trieNode[V]) add(key []rune, value V, field Field) bool {
 	// if children do not exist, make sure it is an empty map
 	if len(t.children) == 0 {
-		t.children = make(map[rune]*TrieNode)
+		t.children = make(map[rune]*trieNode[V])
 	}
 	// if the child does not exist, create a new trie node and store it there
 	if t.children[key[0]] == nil {
-		t.children[key[0]] = NewTrieNode()
+		t.children[key[0]] = &trieNode[V]{}
 	}
 	if len(key) == 1 {
 		if len(t.children[key[0]].values) == 0 {
-			t.children[key[0]].values = make(map[int64]struct{})
+			t.children[key[0]].values = make(set[V])
 		}
 		// add the value and then return
-		t.children[key[0]].values.add(value)
-		return
+		t.children[key[0]].addField(value, field)
+		return t.children[key[0]].values.add(value)
 	}
 	// otherwise, call the add method again on the child node (recursively)
-	t.children[key[0]].add(key[1:len(key)], value)
+	return t.children[key[0]].add(key[1:], value, field)
+}
+
+//with returns a copy of the node with the key and value added below
+//it, sharing every node off the key's path, and true if the pair wasn't
+//already there. If nothing changes it returns the node itself.
+func (t *trieNode[V]) with(key []rune, value V, field Field) (*trieNode[V], bool) {
+	child := t.children[key[0]]
+	var newChild *trieNode[V]
+	added := true
+	switch {
+	case child == nil:
+		// nothing can be reading a new branch, so build it in place
+		newChild = &trieNode[V]{}
+		if len(key) == 1 {
+			newChild.values = set[V]{value: struct{}{}}
+			newChild.addField(value, field)
+		} else {
+			newChild.add(key[1:], value, field)
+		}
+	case len(key) == 1:
+		newChild, added = child.withValue(value, field)
+	default:
+		newChild, added = child.with(key[1:], value, field)
+	}
+	if newChild == child {
+		return t, false
+	}
+	children := maps.Clone(t.children)
+	if children == nil {
+		children = make(map[rune]*trieNode[V])
+	}
+	children[key[0]] = newChild
+	return &trieNode[V]{children: children, values: t.values, fields: t.fields}, added
+}
+
+//withValue returns a copy of the node with the value added, and true
+//if it wasn't already there. If nothing changes it returns the node itself.
+func (t *trieNode[V]) withValue(value V, field Field) (*trieNode[V], bool) {
+	added := !t.values.has(value)
+	if !added && t.fields[value]&field == field {
+		return t, false
+	}
+	copied := &trieNode[V]{children: t.children, values: t.values, fields: t.fields}
+	if added {
+		copied.values = maps.Clone(t.values)
+		if copied.values == nil {
+			copied.values = make(set[V])
+		}
+		copied.values.add(value)
+	}
+	if field != 0 {
+		copied.fields = maps.Clone(t.fields)
+		copied.addField(value, field)
+	}
+	return copied, added
+}
+
+//empty returns true if the node has no values and no children
+func (t *trieNode[V]) empty() bool {
+	return len(t.values) == 0 && len(t.children) == 0
 }
 
 //Find finds `max` values matching `prefix`. If the trie
 //is entirely empty, or the prefix is empty, or max == 0,
 //or the prefix is not found, this returns a nil slice.
-func (t *TrieNode) Find(prefix string, max int) []int64 {
-	t.mx.RLock()
-	defer t.mx.RUnlock()
-
-	if len(t.children) == 0 || prefix == "" || max <= 0 {
+func (t *Trie[V]) Find(prefix string, max int) []V {
+	prefix = t.normalize(prefix)
+	root := t.load().root
+	if len(root.children) == 0 || prefix == "" || max <= 0 {
 		return nil
 	}
 
 	// iterate through trie until at end of prefix | O(1)
-	prefixRunes := []rune(prefix)
-	triePointer := t
-	for _, s := range prefixRunes {
-		if triePointer.children[s] == nil {
-			return nil
-		}
-		triePointer = triePointer.children[s]
+	triePointer := root.descend([]rune(prefix))
+	if triePointer == nil {
+		return nil
 	}
-	// create int64 slice
-	var returnSlice []int64
-	triePointer.findDFS(&returnSlice, max)
+	// create value slice
+	var returnSlice []V
+	triePointer.findDFS(&returnSlice, max, t.less)
 	return returnSlice
 }
 
 //findDFS is a private function that recursively goes down the trie to return a slice of results
-func (t *TrieNode) findDFS(list *[]int64, max int) {
+func (t *trieNode[V]) findDFS(list *[]V, max int, less func(a, b V) bool) {
 	// add all current values in node to list (or until hit max)
-	values := t.values.all()
+	values := t.values.sorted(less)
 	canGet := max - len(*list)
 	if len(values) > canGet {
 		*list = append(*list, values[0:canGet]...)
@@ -108,7 +282,18 @@ func (t *TrieNode) findDFS(list *[]int64, max int) {
 	if len(*list) == max || len(t.children) == 0 {
 		return
 	}
-	// sort children
+	// for every child in order, recurse and add to list and check for max
+	for _, child := range t.sortedChildren() {
+		t.children[child].findDFS(list, max, less)
+		if len(*list) == max {
+			return
+		}
+	}
+	return
+}
+
+//sortedChildren returns the runes of the node's children in order
+func (t *trieNode[V]) sortedChildren() []rune {
 	children := make([]rune, 0, len(t.children))
 	for k := range t.children {
 		children = append(children, k)
@@ -116,33 +301,325 @@ func (t *TrieNode) findDFS(list *[]int64, max int) {
 	sort.Slice(children, func(i, j int) bool {
 		return children[i] < children[j]
 	})
-	// for every child, recurse and add to list and check for max
-	for _, child := range children {
-		t.children[child].findDFS(list, max)
-		if len(*list) == max {
+	return children
+}
+
+//fuzzyMatch is a value found by FindFuzzy, with the key it was found
+//under and that key's edit distance from the searched prefix
+type fuzzyMatch[V comparable] struct {
+	key      string
+	value    V
+	distance int
+}
+
+//FindFuzzy finds `max` values whose keys start with a string that is
+//within `maxEdits` insertions, deletions or substitutions of `prefix`,
+//so "jonh" finds "john". Results are ranked by edit distance and then
+//by key. Only the `max` best are kept as the trie is walked, and
+//branches that can't beat the worst of them are skipped. If the trie
+//is entirely empty, or the prefix is empty, or max == 0, or
+//maxEdits < 0, or nothing is close enough, this returns a nil slice.
+func (t *Trie[V]) FindFuzzy(prefix string, maxEdits int, max int) []V {
+	prefix = t.normalize(prefix)
+	root := t.load().root
+	if len(root.children) == 0 || prefix == "" || max <= 0 || maxEdits < 0 {
+		return nil
+	}
+
+	// the first Levenshtein row is the distance from each prefix of
+	// the query to the empty key
+	query := []rune(prefix)
+	row := make([]int, len(query)+1)
+	for i := range row {
+		row[i] = i
+	}
+	best := &fuzzyHeap[V]{less: t.less, pos: make(map[V]int), max: max}
+	root.fuzzyDFS(query, row, len(query), nil, maxEdits, best)
+
+	matches := best.matches
+	sort.Slice(matches, func(i, j int) bool {
+		return best.better(matches[i], matches[j])
+	})
+	var returnSlice []V
+	for _, match := range matches {
+		returnSlice = append(returnSlice, match.value)
+	}
+	return returnSlice
+}
+
+//fuzzyHeap keeps the best match of up to max values, with the worst on top
+type fuzzyHeap[V comparable] struct {
+	matches []fuzzyMatch[V]
+	pos     map[V]int         // Index of each value's match
+	less    func(a, b V) bool // Trie's value order, for ties
+	max     int
+}
+
+//better returns true if a ranks before b
+func (h *fuzzyHeap[V]) better(a, b fuzzyMatch[V]) bool {
+	if a.distance != b.distance {
+		return a.distance < b.distance
+	}
+	if a.key != b.key || h.less == nil {
+		return a.key < b.key
+	}
+	return h.less(a.value, b.value)
+}
+
+//limit returns the largest distance a match found later in the walk,
+//and so under a larger key, can have and still be kept
+func (h *fuzzyHeap[V]) limit(maxEdits int) int {
+	if len(h.matches) < h.max {
+		return maxEdits
+	}
+	return minInt(maxEdits, h.matches[0].distance-1)
+}
+
+//add keeps the match if it's the value's best so far and among the
+//best max values
+func (h *fuzzyHeap[V]) add(match fuzzyMatch[V]) {
+	if idx, found := h.pos[match.value]; found {
+		// a value can be under several keys, keep its best ranked match
+		if h.better(match, h.matches[idx]) {
+			h.matches[idx] = match
+			heap.Fix(h, idx)
+		}
+		return
+	}
+	if len(h.matches) < h.max {
+		heap.Push(h, match)
+		return
+	}
+	if h.better(match, h.matches[0]) {
+		delete(h.pos, h.matches[0].value)
+		h.matches[0] = match
+		h.pos[match.value] = 0
+		heap.Fix(h, 0)
+	}
+}
+
+func (h *fuzzyHeap[V]) Len() int           { return len(h.matches) }
+func (h *fuzzyHeap[V]) Less(i, j int) bool { return h.better(h.matches[j], h.matches[i]) }
+func (h *fuzzyHeap[V]) Swap(i, j int) {
+	h.matches[i], h.matches[j] = h.matches[j], h.matches[i]
+	h.pos[h.matches[i].value] = i
+	h.pos[h.matches[j].value] = j
+}
+func (h *fuzzyHeap[V]) Push(x any) {
+	match := x.(fuzzyMatch[V])
+	h.pos[match.value] = len(h.matches)
+	h.matches = append(h.matches, match)
+}
+func (h *fuzzyHeap[V]) Pop() any {
+	last := h.matches[len(h.matches)-1]
+	h.matches = h.matches[:len(h.matches)-1]
+	delete(h.pos, last.value)
+	return last
+}
+
+//fuzzyDFS is a private function that walks the trie in key order computing one
+//row of the Levenshtein matrix per rune. row[i] is the edit distance between the
+//first i runes of the query and the key so far, and best is the smallest distance
+//between the whole query and any prefix of the key so far. Since keys found later
+//are larger, a branch is skipped once every match in it would rank below the
+//worst kept so far.
+func (t *trieNode[V]) fuzzyDFS(query []rune, row []int, best int, key []rune, maxEdits int, matches *fuzzyHeap[V]) {
+	for _, r := range t.sortedChildren() {
+		limit := matches.limit(maxEdits)
+		// every match below is at least this far from the query
+		if minInt(best, row...) > limit {
 			return
 		}
+		child := t.children[r]
+		childRow := make([]int, len(row))
+		childRow[0] = row[0] + 1
+		rowMin := childRow[0]
+		for i := 1; i < len(row); i++ {
+			cost := 1
+			if query[i-1] == r {
+				cost = 0
+			}
+			childRow[i] = minInt(childRow[i-1]+1, row[i]+1, row[i-1]+cost)
+			rowMin = minInt(rowMin, childRow[i])
+		}
+		childBest := minInt(best, childRow[len(query)])
+		childKey := append(key[:len(key):len(key)], r)
+
+		if childBest <= limit && len(child.values) > 0 {
+			keyString := string(childKey)
+			for _, value := range child.values.sorted(matches.less) {
+				matches.add(fuzzyMatch[V]{keyString, value, childBest})
+			}
+		}
+		// keep going while the distance can still shrink, or while
+		// everything below is already a match
+		if minInt(rowMin, childBest) <= matches.limit(maxEdits) {
+			child.fuzzyDFS(query, childRow, childBest, childKey, maxEdits, matches)
+		}
 	}
-	return
 }
 
-//Remove removes a key/value pair from the trie
-//and trims branches with no values.
-func (t *TrieNode) Remove(key string, value int64) {
+//minInt returns the smallest of the given ints
+func minInt(first int, rest ...int) int {
+	for _, v := range rest {
+		if v < first {
+			first = v
+		}
+	}
+	return first
+}
+
+//Remove removes a key/value pair from the trie, whichever fields
+//the key was added for, and trims branches with no values.
+func (t *Trie[V]) Remove(key string, value V) {
+	runes := []rune(t.normalize(key))
+	if len(runes) == 0 {
+		return
+	}
 	t.mx.Lock()
 	defer t.mx.Unlock()
-	runes := []rune(key)
-	t.remove(runes, value)
+	current := t.load()
+	root, removed := current.root.without(runes, value)
+	if !removed {
+		return
+	}
+	next := &trieVersion[V]{root: root, size: current.size - 1, keys: current.keys}
+	t.current.Store(next)
+	next.removeKey(value, string(runes))
 }
 
-func (t *TrieNode) remove(key []rune, value int64) {
+//without returns a copy of the node with the key and value removed
+//below it, sharing every node off the key's path and trimming branches
+//with no values, and true. If the pair isn't there it returns the node
+//itself and false.
+func (t *trieNode[V]) without(key []rune, value V) (*trieNode[V], bool) {
 	if len(key) == 0 {
-		t.values.remove(value)
-		return
+		if !t.values.has(value) {
+			return t, false
+		}
+		copied := &trieNode[V]{children: t.children, values: maps.Clone(t.values), fields: t.fields}
+		copied.values.remove(value)
+		if _, found := t.fields[value]; found {
+			copied.fields = maps.Clone(t.fields)
+			delete(copied.fields, value)
+		}
+		return copied, true
 	}
 	focusChild := t.children[key[0]]
-	focusChild.remove(key[1:], value)
-	if len(focusChild.children) == 0 && len(focusChild.values) == 0 {
-		delete(t.children, key[0])
+	// key is not in the trie, nothing to remove
+	if focusChild == nil {
+		return t, false
+	}
+	newChild, removed := focusChild.without(key[1:], value)
+	if !removed {
+		return t, false
+	}
+	copied := &trieNode[V]{children: maps.Clone(t.children), values: t.values, fields: t.fields}
+	if newChild.empty() {
+		delete(copied.children, key[0])
+	} else {
+		copied.children[key[0]] = newChild
+	}
+	return copied, true
+}
+
+//Values returns every distinct value in the trie, ordered by the
+//trie's less function.
+func (t *Trie[V]) Values() []V {
+	values := make(set[V])
+	t.load().root.valuesDFS(values)
+	return values.sorted(t.less)
+}
+
+func (t *trieNode[V]) valuesDFS(values set[V]) {
+	for value := range t.values {
+		values.add(value)
+	}
+	for _, child := range t.children {
+		child.valuesDFS(values)
+	}
+}
+
+//RemoveValues removes the given values from every key they are
+//stored under, in one walk of the trie, and trims branches with
+//no values. Use it when the keys a value was added with are unknown.
+func (t *Trie[V]) RemoveValues(values ...V) {
+	if len(values) == 0 {
+		return
+	}
+	removed := make(set[V], len(values))
+	for _, value := range values {
+		removed.add(value)
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	current := t.load()
+	root, count := current.root.withoutValues(removed)
+	if count == 0 {
+		return
+	}
+	next := &trieVersion[V]{root: root, size: current.size - count, keys: current.keys}
+	t.current.Store(next)
+	for value := range removed {
+		next.keys.Delete(value)
+	}
+}
+
+//withoutValues returns a copy of the node with the given values removed
+//at and below it, sharing every node they aren't under and trimming
+//branches with no values, and the number of pairs removed. If there
+//are none it returns the node itself.
+func (t *trieNode[V]) withoutValues(removed set[V]) (*trieNode[V], int) {
+	copied := trieNode[V]{children: t.children, values: t.values, fields: t.fields}
+	count := 0
+	for value := range t.values {
+		if !removed.has(value) {
+			continue
+		}
+		if count == 0 {
+			copied.values = maps.Clone(t.values)
+			copied.fields = maps.Clone(t.fields)
+		}
+		copied.values.remove(value)
+		delete(copied.fields, value)
+		count++
+	}
+	childCount := 0
+	for r, child := range t.children {
+		newChild, n := child.withoutValues(removed)
+		if n == 0 {
+			continue
+		}
+		if childCount == 0 {
+			copied.children = maps.Clone(t.children)
+		}
+		if newChild.empty() {
+			delete(copied.children, r)
+		} else {
+			copied.children[r] = newChild
+		}
+		childCount += n
+	}
+	if count+childCount == 0 {
+		return t, 0
 	}
+	return &copied, count + childCount
+}
+
+//Replace replaces the contents of the trie with those of other, so
+//callers holding this trie see the whole change at once. other must
+//not be used afterwards.
+func (t *Trie[V]) Replace(other *Trie[V]) {
+	other.mx.Lock()
+	version := other.load()
+	other.mx.Unlock()
+	t.publish(version)
+}
+
+//publish makes version, which must not be changed afterwards, the
+//contents of the trie
+func (t *Trie[V]) publish(version *trieVersion[V]) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.current.Store(version)
 }
@@ -1,6 +1,11 @@
 package indexes
 
-import "testing"
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
 
 //TODO: implement automated tests for your trie data structure
 func TestAddLen(t *testing.T) {
@@ -216,6 +221,22 @@ func TestAddRemove(t *testing.T) {
 			[]int64{2},
 			1,
 		},
+		{
+			"Remove Missing Key",
+			[]string{"Eric"},
+			[]int64{1},
+			[]string{"Guo", "Er", "Erica"},
+			[]int64{1, 1, 1},
+			1,
+		},
+		{
+			"Remove Same Key Twice",
+			[]string{"Eric"},
+			[]int64{1},
+			[]string{"Eric", "Eric"},
+			[]int64{1, 1},
+			0,
+		},
 	}
 
 	for _, c := range cases {
@@ -234,3 +255,259 @@ func TestAddRemove(t *testing.T) {
 		}
 	}
 }
+
+func TestFindFuzzy(t *testing.T) {
+	cases := []struct {
+		name     string
+		keys     []string
+		values   []int64
+		prefix   string
+		maxEdits int
+		max      int
+		expected []int64
+	}{
+		{
+			"Transposed Letters",
+			[]string{"john", "joan", "mary"},
+			[]int64{1, 2, 3},
+			"jonh",
+			2,
+			10,
+			[]int64{1, 2},
+		},
+		{
+			"Exact Prefix Ranks First",
+			[]string{"jonathan", "john"},
+			[]int64{1, 2},
+			"jon",
+			1,
+			10,
+			[]int64{1, 2},
+		},
+		{
+			"Ranked By Distance",
+			[]string{"bob", "rob", "ron"},
+			[]int64{1, 2, 3},
+			"rob",
+			2,
+			10,
+			[]int64{2, 1, 3},
+		},
+		{
+			"Ties Ranked By Key",
+			[]string{"dan", "dam", "dap"},
+			[]int64{1, 2, 3},
+			"daz",
+			1,
+			10,
+			[]int64{2, 1, 3},
+		},
+		{
+			"Missing Letter",
+			[]string{"alexander"},
+			[]int64{1},
+			"alxand",
+			1,
+			10,
+			[]int64{1},
+		},
+		{
+			"Too Many Edits",
+			[]string{"john"},
+			[]int64{1},
+			"mary",
+			2,
+			10,
+			nil,
+		},
+		{
+			"Zero Edits Is Exact Prefix",
+			[]string{"john", "joan"},
+			[]int64{1, 2},
+			"joh",
+			0,
+			10,
+			[]int64{1},
+		},
+		{
+			"Value Under Several Keys",
+			[]string{"john", "smith", "jon"},
+			[]int64{1, 1, 2},
+			"jon",
+			1,
+			10,
+			[]int64{2, 1},
+		},
+		{
+			"Max Results",
+			[]string{"dan", "dam", "dap"},
+			[]int64{1, 2, 3},
+			"daz",
+			1,
+			2,
+			[]int64{2, 1},
+		},
+		{
+			"Empty Prefix",
+			[]string{"john"},
+			[]int64{1},
+			"",
+			1,
+			10,
+			nil,
+		},
+		{
+			"Non ASCII Keys",
+			[]string{"józef", "jozef"},
+			[]int64{1, 2},
+			"józef",
+			1,
+			10,
+			[]int64{1, 2},
+		},
+	}
+
+	for _, c := range cases {
+		testTrie := NewTrieNode()
+		for idx, k := range c.keys {
+			testTrie.Add(k, c.values[idx])
+		}
+		slice := testTrie.FindFuzzy(c.prefix, c.maxEdits, c.max)
+		if len(slice) != len(c.expected) {
+			t.Errorf("case %s: incorrect search result length: expected %v but got %v",
+				c.name, c.expected, slice)
+			continue
+		}
+		for idx, v := range c.expected {
+			if slice[idx] != v {
+				t.Errorf("case %s: incorrect search result: expected %v but got %v", c.name, c.expected, slice)
+				break
+			}
+		}
+	}
+}
+
+//TestFindFuzzyBounded checks that keeping only the best `max` matches
+//and skipping branches that can't beat them finds the same results as
+//keeping every match
+func TestFindFuzzyBounded(t *testing.T) {
+	property := func(ops indexOps) bool {
+		testTrie := NewTrieNode()
+		for _, op := range ops {
+			if op.remove {
+				testTrie.Remove(op.key, op.value)
+			} else {
+				testTrie.Add(op.key, op.value)
+			}
+		}
+		for _, query := range []string{"a", "ab", "bAa", "王玉", "éb"} {
+			for maxEdits := 0; maxEdits <= 2; maxEdits++ {
+				all := testTrie.FindFuzzy(query, maxEdits, 100)
+				for _, max := range []int{1, 2, 3} {
+					expected := all
+					if len(expected) > max {
+						expected = expected[:max]
+					}
+					if result := testTrie.FindFuzzy(query, maxEdits, max); !reflect.DeepEqual(result, expected) {
+						t.Logf("query %q maxEdits %d max %d: expected %v but got %v", query, maxEdits, max, expected, result)
+						return false
+					}
+				}
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+//BenchmarkFindFuzzy compares typo tolerant search of a large trie with
+//Find, which only follows the prefix
+func BenchmarkFindFuzzy(b *testing.B) {
+	testTrie := NewTrieNode()
+	for i, key := range benchmarkKeys(200000) {
+		testTrie.Add(key, int64(i))
+	}
+	cases := []struct {
+		prefix   string
+		maxEdits int
+	}{
+		{"ada", 1},
+		{"adaml", 2},
+		{"abc", 1},
+	}
+	for _, c := range cases {
+		b.Run(fmt.Sprintf("Find/%s", c.prefix), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				testTrie.Find(c.prefix, 20)
+			}
+		})
+		b.Run(fmt.Sprintf("FindFuzzy/%s/edits=%d", c.prefix, c.maxEdits), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				testTrie.FindFuzzy(c.prefix, c.maxEdits, 20)
+			}
+		})
+	}
+}
+
+func TestTrieStringValues(t *testing.T) {
+	// channel names indexed by the words of their topics
+	channels := NewTrie(func(a, b string) bool { return a < b })
+	channels.Add("general", "general")
+	channels.Add("random", "random")
+	channels.Add("Go", "golang")
+	channels.Add("Go", "gophers")
+	channels.Add("gardening", "garden-club")
+
+	if result := channels.Find("g", 10); !reflect.DeepEqual(result, []string{"garden-club", "general", "golang", "gophers"}) {
+		t.Errorf("expected channels in key then name order but got %v", result)
+	}
+	if l := channels.Len(); l != 5 {
+		t.Errorf("expected 5 entries but got %d", l)
+	}
+
+	page := channels.FindAfter("go", "go", "golang", 10)
+	if !reflect.DeepEqual(page, []EntryOf[string]{{"go", "gophers"}}) {
+		t.Errorf("expected the page after golang to be gophers but got %v", page)
+	}
+
+	active := map[string]float64{"gophers": 1}
+	ranked := channels.FindRanked("go", 10, &RankOptionsOf[string]{
+		Recency:       func(name string) float64 { return active[name] },
+		RecencyWeight: 1,
+	})
+	if len(ranked) != 2 || ranked[0].Value != "gophers" || ranked[1].Value != "golang" {
+		t.Errorf("expected the active channel first but got %+v", ranked)
+	}
+
+	channels.Remove("go", "golang")
+	if result := channels.Find("go", 10); !reflect.DeepEqual(result, []string{"gophers"}) {
+		t.Errorf("expected [gophers] after removing golang but got %v", result)
+	}
+}
+
+func TestTrieUnorderedValues(t *testing.T) {
+	// comparable values with no order come back in key order, with
+	// values of the same key in no particular order
+	type hashtag struct {
+		channel string
+		message int
+	}
+	tags := NewTrie[hashtag](nil)
+	tags.Add("launch", hashtag{"general", 1})
+	tags.Add("launch", hashtag{"random", 2})
+	tags.Add("lunch", hashtag{"random", 3})
+
+	result := tags.Find("l", 10)
+	if len(result) != 3 || result[2] != (hashtag{"random", 3}) {
+		t.Errorf("expected both launch tags before lunch but got %v", result)
+	}
+	// without an order a page can only resume at the next key
+	page := tags.FindAfter("l", "launch", result[0], 10)
+	if !reflect.DeepEqual(page, []EntryOf[hashtag]{{"lunch", hashtag{"random", 3}}}) {
+		t.Errorf("expected the page after launch to start at lunch but got %v", page)
+	}
+}
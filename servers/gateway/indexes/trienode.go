@@ -0,0 +1,31 @@
+package indexes
+
+//TrieNode is a Trie of int64 values, such as user IDs, ordered from
+//smallest to largest, that can be saved to and loaded from snapshots.
+type TrieNode struct {
+	Trie[int64]
+}
+
+//lessInt64 orders int64 values from smallest to largest
+func lessInt64(a, b int64) bool {
+	return a < b
+}
+
+//NewTrieNode constructs a new TrieNode that normalizes keys with Normalize.
+func NewTrieNode() *TrieNode {
+	return &TrieNode{Trie[int64]{less: lessInt64}}
+}
+
+//NewTrieNodeWithNormalizer constructs a new TrieNode that
+//normalizes keys and prefixes with the given Normalizer,
+//such as FoldCase to keep accents significant.
+func NewTrieNodeWithNormalizer(normal Normalizer) *TrieNode {
+	return &TrieNode{Trie[int64]{less: lessInt64, normal: normal}}
+}
+
+//Replace replaces the contents of the trie with those of other, so
+//callers holding this trie see the whole change at once. other must
+//not be used afterwards.
+func (t *TrieNode) Replace(other *TrieNode) {
+	t.Trie.Replace(&other.Trie)
+}
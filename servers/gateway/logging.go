@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+//newLogger returns the logger for the gateway's logs and access logs.
+//LOGFORMAT is "json" (the default) or "text", and LOGLEVEL is the
+//lowest level logged: "debug", "info" (the default), "warn" or "error".
+func newLogger() (*slog.Logger, error) {
+	var level slog.Level
+	if name := os.Getenv("LOGLEVEL"); len(name) != 0 {
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return nil, fmt.Errorf("invalid LOGLEVEL %q", name)
+		}
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch format := strings.ToLower(os.Getenv("LOGFORMAT")); format {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOGFORMAT %q", format)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/proxy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//requestRoute returns the pattern of the gateway's own route a request
+//matches, or of the proxied service's prefix if it's proxied
+func requestRoute(mux *http.ServeMux, routes *proxy.Reloader) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if _, pattern := mux.Handler(r); pattern != "/" {
+			return pattern
+		}
+		return routes.Route(r)
+	}
+}
+
+//registerTrieSize exports the number of entries in the user store's
+//search trie, counted when metrics are scraped
+func registerTrieSize(store users.Store) {
+	var trie *indexes.TrieNode
+	switch store := store.(type) {
+	case *users.PostgressStore:
+		trie = store.TrieNode
+	case *users.MemStore:
+		trie = store.TrieNode
+	default:
+		return
+	}
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Subsystem: "trie",
+		Name:      "entries",
+		Help:      "Entries in the user search trie.",
+	}, func() float64 {
+		return float64(trie.Len())
+	}))
+}
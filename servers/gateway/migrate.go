@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/migrations"
+)
+
+//errMigrateUsage describes the migrate subcommand
+var errMigrateUsage = errors.New("usage: gateway migrate up|down|status")
+
+//runMigrateCommand handles `gateway migrate up|down|status`
+func runMigrateCommand(db *sql.DB, driver string, args []string) error {
+	if len(args) != 1 {
+		return errMigrateUsage
+	}
+	migrator, err := migrations.NewMigrator(db, driver)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migration(s)", count)
+	case "down":
+		migration, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Rolled back migration %d_%s", migration.Version, migration.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(os.Stdout, "%04d_%s\t%s\n", status.Migration.Version, status.Migration.Name, state)
+		}
+	default:
+		return errMigrateUsage
+	}
+	return nil
+}
+
+//migrateOnStartup applies pending migrations before the gateway starts serving
+func migrateOnStartup(db *sql.DB, driver string) error {
+	migrator, err := migrations.NewMigrator(db, driver)
+	if err != nil {
+		return err
+	}
+	count, err := migrator.Up(context.Background())
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		slog.Info("Applied migrations", "count", count)
+	}
+	return nil
+}
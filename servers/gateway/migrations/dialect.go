@@ -0,0 +1,104 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const sqlCreateMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT NOT NULL PRIMARY KEY,
+    name VARCHAR(191) NOT NULL,
+    applied_at BIGINT NOT NULL
+);`
+
+const sqlSelectApplied = `SELECT version, applied_at from schema_migrations;`
+
+const sqlInsertApplied = `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?,?,?);`
+const sqlDeleteApplied = `DELETE from schema_migrations where version=?;`
+const sqlGetLock = `SELECT GET_LOCK(?, ?);`
+const sqlReleaseLock = `SELECT RELEASE_LOCK(?);`
+
+const sqlPgInsertApplied = `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1,$2,$3);`
+const sqlPgDeleteApplied = `DELETE from schema_migrations where version=$1;`
+const sqlPgTryLock = `SELECT pg_try_advisory_lock($1);`
+const sqlPgReleaseLock = `SELECT pg_advisory_unlock($1);`
+
+//pgLockKey is the advisory lock key standing in for lockName on PostgreSQL
+const pgLockKey int64 = 0x676174657761 // "gatewa"
+
+//pgLockRetry is how often to retry a PostgreSQL advisory lock held by another gateway
+const pgLockRetry = 250 * time.Millisecond
+
+//dialect holds the bookkeeping SQL and locking for one database driver
+type dialect struct {
+	insertApplied string
+	deleteApplied string
+	lock          func(ctx context.Context, conn *sql.Conn, timeout time.Duration) error
+	unlock        func(conn *sql.Conn)
+}
+
+//dialects maps database/sql driver names to their dialect
+var dialects = map[string]*dialect{
+	"mysql": {
+		insertApplied: sqlInsertApplied,
+		deleteApplied: sqlDeleteApplied,
+		lock:          mysqlLock,
+		unlock: func(conn *sql.Conn) {
+			conn.ExecContext(context.Background(), sqlReleaseLock, lockName)
+		},
+	},
+	"postgres": {
+		insertApplied: sqlPgInsertApplied,
+		deleteApplied: sqlPgDeleteApplied,
+		lock:          pgLock,
+		unlock: func(conn *sql.Conn) {
+			conn.ExecContext(context.Background(), sqlPgReleaseLock, pgLockKey)
+		},
+	},
+}
+
+//dialectFor returns the dialect for a driver name
+func dialectFor(driver string) (*dialect, error) {
+	d, found := dialects[driver]
+	if !found {
+		return nil, fmt.Errorf("no migrations for database driver %s", driver)
+	}
+	return d, nil
+}
+
+//mysqlLock waits for the named lock with GET_LOCK, which has its own timeout
+func mysqlLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, sqlGetLock, lockName, int(timeout/time.Second)).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return ErrLockTimeout
+	}
+	return nil
+}
+
+//pgLock polls pg_try_advisory_lock until it succeeds or the timeout passes
+func pgLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, sqlPgTryLock, pgLockKey).Scan(&acquired); err != nil {
+			return err
+		}
+		if acquired {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pgLockRetry):
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//files holds the versioned up/down SQL files compiled into the binary,
+//with one directory per database driver
+//go:embed sql/mysql/*.sql sql/postgres/*.sql
+var files embed.FS
+
+//lockName is the named lock held while migrations run, so that
+//concurrently starting gateways don't apply the same migration twice
+const lockName = "gateway_schema_migrations"
+
+//defaultLockTimeout is how long to wait for another gateway's migration run
+const defaultLockTimeout = 30 * time.Second
+
+//fileNamePattern matches migration file names like 0001_create_user.up.sql
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down|check)\.sql$`)
+
+//ErrLockTimeout is returned when the migration lock can't be acquired in time
+var ErrLockTimeout = errors.New("timed out waiting for the migration lock")
+
+//ErrNoMigration is returned by Down when no migration has been applied
+var ErrNoMigration = errors.New("no applied migration to roll back")
+
+//ErrCheckFailed is returned by Up when a migration's check finds data
+//the migration can't be applied to
+var ErrCheckFailed = errors.New("migration check failed")
+
+//Migration is a single versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	//Check is an optional query run before Up. Each row it returns is
+	//a reason the migration can't be applied yet, such as duplicates
+	//a new unique index would reject, since MySQL can't roll back DDL.
+	Check string
+}
+
+//Status reports whether a migration has been applied to the database
+type Status struct {
+	Migration *Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+//Migrator applies migrations to a database
+type Migrator struct {
+	DB          *sql.DB
+	Migrations  []*Migration
+	LockTimeout time.Duration
+	dialect     *dialect
+}
+
+//NewMigrator constructs a Migrator for the migrations embedded in the
+//binary for the given database/sql driver ("mysql" or "postgres")
+func NewMigrator(db *sql.DB, driver string) (*Migrator, error) {
+	if db == nil {
+		panic("nil database pointer passed to Migrator")
+	}
+	dialect, err := dialectFor(driver)
+	if err != nil {
+		return nil, err
+	}
+	driverFiles, err := fs.Sub(files, path.Join("sql", driver))
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(driverFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		DB:          db,
+		Migrations:  migrations,
+		LockTimeout: defaultLockTimeout,
+		dialect:     dialect,
+	}, nil
+}
+
+//Load reads the up/down SQL files at the root of fsys, and the optional
+//check files, and returns the migrations sorted by version. Every
+//version needs both an up and a down file.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	names, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, name := range names {
+		match := fileNamePattern.FindStringSubmatch(path.Base(name))
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, m.Name, match[2])
+		}
+		switch match[3] {
+		case "up":
+			m.Up = string(body)
+		case "down":
+			m.Down = string(body)
+		case "check":
+			m.Check = string(body)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both up and down SQL", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+//Up applies every pending migration in version order and
+//returns the number of migrations applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	count := 0
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.Migrations {
+			if _, done := applied[migration.Version]; done {
+				continue
+			}
+			if err := check(ctx, conn, migration); err != nil {
+				return err
+			}
+			if err := apply(ctx, conn, migration.Up, m.dialect.insertApplied, migration.Version, migration.Name, time.Now().Unix()); err != nil {
+				return fmt.Errorf("applying migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+//Down rolls back the most recently applied migration and returns it
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var rolledBack *Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			migration := m.Migrations[i]
+			if _, done := applied[migration.Version]; !done {
+				continue
+			}
+			if err := apply(ctx, conn, migration.Down, m.dialect.deleteApplied, migration.Version); err != nil {
+				return fmt.Errorf("rolling back migration %d_%s: %v", migration.Version, migration.Name, err)
+			}
+			rolledBack = migration
+			return nil
+		}
+		return ErrNoMigration
+	})
+	return rolledBack, err
+}
+
+//Status returns the applied state of every known migration
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, sqlCreateMigrationsTable); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*Status, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		appliedAt, done := applied[migration.Version]
+		statuses = append(statuses, &Status{
+			Migration: migration,
+			Applied:   done,
+			AppliedAt: appliedAt,
+		})
+	}
+	return statuses, nil
+}
+
+//withLock runs fn on a single connection while holding the named
+//migration lock. The lock is session scoped, so every statement
+//must go through the same connection.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := m.dialect.lock(ctx, conn, m.LockTimeout); err != nil {
+		return err
+	}
+	defer m.dialect.unlock(conn)
+
+	if _, err := conn.ExecContext(ctx, sqlCreateMigrationsTable); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+//appliedVersions returns the applied migration versions and when they were applied
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, sqlSelectApplied)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt int64
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(appliedAt, 0)
+	}
+	return applied, rows.Err()
+}
+
+//check runs the migration's Check query, if any, and returns an
+//ErrCheckFailed listing the reasons it returned
+func check(ctx context.Context, conn *sql.Conn, migration *Migration) error {
+	if strings.TrimSpace(migration.Check) == "" {
+		return nil
+	}
+	rows, err := conn.QueryContext(ctx, migration.Check)
+	if err != nil {
+		return fmt.Errorf("checking migration %d_%s: %v", migration.Version, migration.Name, err)
+	}
+	defer rows.Close()
+	var reasons []string
+	for rows.Next() {
+		var reason string
+		if err := rows.Scan(&reason); err != nil {
+			return fmt.Errorf("checking migration %d_%s: %v", migration.Version, migration.Name, err)
+		}
+		reasons = append(reasons, reason)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("checking migration %d_%s: %v", migration.Version, migration.Name, err)
+	}
+	if len(reasons) > 0 {
+		return fmt.Errorf("%w: %d_%s: %s", ErrCheckFailed, migration.Version, migration.Name, strings.Join(reasons, "; "))
+	}
+	return nil
+}
+
+//apply runs the statements of one migration file and then records
+//the change in schema_migrations, all in a single transaction.
+//Note that MySQL commits DDL statements implicitly, unlike PostgreSQL.
+func apply(ctx context.Context, conn *sql.Conn, body string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range splitStatements(body) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+//splitStatements splits a migration file into individual statements,
+//since the mysql driver rejects multiple statements per Exec. Semicolons
+//in quoted strings and identifiers, PostgreSQL dollar-quoted bodies and
+//comments don't end a statement. Comments are dropped, so a file may
+//start or end with one. Quotes are escaped by doubling them; backslash
+//escapes aren't understood, as PostgreSQL doesn't honor them either.
+func splitStatements(body string) []string {
+	var stmts []string
+	var stmt strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(stmt.String()); s != "" {
+			stmts = append(stmts, s+";")
+		}
+		stmt.Reset()
+	}
+	for i := 0; i < len(body); {
+		c := body[i]
+		switch {
+		case c == ';':
+			flush()
+			i++
+		case strings.HasPrefix(body[i:], "--"):
+			if end := strings.IndexByte(body[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(body)
+			}
+		case strings.HasPrefix(body[i:], "/*"):
+			if end := strings.Index(body[i+2:], "*/"); end >= 0 {
+				i += end + 4
+			} else {
+				i = len(body)
+			}
+			stmt.WriteByte(' ')
+		case c == '\'' || c == '"' || c == '`':
+			end := quotedEnd(body, i+1, string(c))
+			stmt.WriteString(body[i:end])
+			i = end
+		case c == '$' && (i == 0 || !isIdentByte(body[i-1])):
+			tag := dollarTag.FindString(body[i:])
+			if tag == "" {
+				stmt.WriteByte(c)
+				i++
+				break
+			}
+			end := len(body)
+			if idx := strings.Index(body[i+len(tag):], tag); idx >= 0 {
+				end = i + len(tag) + idx + len(tag)
+			}
+			stmt.WriteString(body[i:end])
+			i = end
+		default:
+			stmt.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	return stmts
+}
+
+//dollarTag matches the tag opening a PostgreSQL dollar-quoted string,
+//such as $$ or $body$
+var dollarTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+//quotedEnd returns the index just past the quote closing the string
+//that starts at start, or the end of the body if it is never closed
+func quotedEnd(body string, start int, quote string) int {
+	for i := start; i < len(body); {
+		idx := strings.Index(body[i:], quote)
+		if idx < 0 {
+			break
+		}
+		i += idx + 1
+		//a doubled quote is an escaped one
+		if !strings.HasPrefix(body[i:], quote) {
+			return i
+		}
+		i++
+	}
+	return len(body)
+}
+
+//isIdentByte returns true if c can be part of an unquoted identifier
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
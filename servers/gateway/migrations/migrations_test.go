@@ -0,0 +1,373 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoad(t *testing.T) {
+	cases := []struct {
+		name        string
+		files       fstest.MapFS
+		expected    []int64
+		expectError bool
+	}{
+		{
+			"Sorted By Version",
+			fstest.MapFS{
+				"0002_b.up.sql":   {Data: []byte("B;")},
+				"0002_b.down.sql": {Data: []byte("B;")},
+				"0001_a.up.sql":   {Data: []byte("A;")},
+				"0001_a.down.sql": {Data: []byte("A;")},
+			},
+			[]int64{1, 2},
+			false,
+		},
+		{
+			"With Check File",
+			fstest.MapFS{
+				"0001_a.up.sql":    {Data: []byte("A;")},
+				"0001_a.down.sql":  {Data: []byte("A;")},
+				"0001_a.check.sql": {Data: []byte("SELECT 'a'")},
+			},
+			[]int64{1},
+			false,
+		},
+		{
+			"Missing Down File",
+			fstest.MapFS{
+				"0001_a.up.sql": {Data: []byte("A;")},
+			},
+			nil,
+			true,
+		},
+		{
+			"Invalid File Name",
+			fstest.MapFS{
+				"create_user.sql": {Data: []byte("A;")},
+			},
+			nil,
+			true,
+		},
+		{
+			"Conflicting Names",
+			fstest.MapFS{
+				"0001_a.up.sql":   {Data: []byte("A;")},
+				"0001_b.down.sql": {Data: []byte("B;")},
+			},
+			nil,
+			true,
+		},
+	}
+
+	for _, c := range cases {
+		migrations, err := Load(c.files)
+		if c.expectError {
+			if err == nil {
+				t.Errorf("case %s: expected error but got none", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %s: unexpected error: %v", c.name, err)
+		}
+		if len(migrations) != len(c.expected) {
+			t.Fatalf("case %s: expected %d migrations but got %d", c.name, len(c.expected), len(migrations))
+		}
+		for idx, version := range c.expected {
+			if migrations[idx].Version != version {
+				t.Errorf("case %s: expected version %d at %d but got %d", c.name, version, idx, migrations[idx].Version)
+			}
+		}
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for driver := range dialects {
+		driverFiles, err := fs.Sub(files, path.Join("sql", driver))
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		migrations, err := Load(driverFiles)
+		if err != nil {
+			t.Fatalf("%s: embedded migrations are invalid: %v", driver, err)
+		}
+		if len(migrations) == 0 {
+			t.Fatalf("%s: no embedded migrations found", driver)
+		}
+		for idx, m := range migrations {
+			if m.Version != int64(idx+1) {
+				t.Errorf("%s: migration versions must be sequential: expected %d but got %d", driver, idx+1, m.Version)
+			}
+		}
+	}
+}
+
+func TestMigrationsMatchAcrossDrivers(t *testing.T) {
+	mysql, err := NewMigrator(&sql.DB{}, "mysql")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	postgres, err := NewMigrator(&sql.DB{}, "postgres")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mysql.Migrations) != len(postgres.Migrations) {
+		t.Fatalf("mysql has %d migrations but postgres has %d", len(mysql.Migrations), len(postgres.Migrations))
+	}
+	for idx, m := range mysql.Migrations {
+		pg := postgres.Migrations[idx]
+		if m.Version != pg.Version || m.Name != pg.Name {
+			t.Errorf("migration %d differs: %d_%s vs %d_%s", idx, m.Version, m.Name, pg.Version, pg.Name)
+		}
+	}
+	if _, err := NewMigrator(&sql.DB{}, "sqlite3"); err == nil {
+		t.Errorf("expected error for unsupported driver")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			"Statements",
+			"CREATE TABLE a (id INT);\n\nCREATE INDEX i ON a (id);\n",
+			[]string{"CREATE TABLE a (id INT);", "CREATE INDEX i ON a (id);"},
+		},
+		{
+			"Missing Last Semicolon",
+			"DROP TABLE a;\nDROP TABLE b",
+			[]string{"DROP TABLE a;", "DROP TABLE b;"},
+		},
+		{
+			"Line Comments",
+			"-- first; second\nDO 0; -- trailing;\n-- only a comment\n",
+			[]string{"DO 0;"},
+		},
+		{
+			"Block Comments",
+			"/* a; b */ SELECT 1 /* ; */ + 1;",
+			[]string{"SELECT 1   + 1;"},
+		},
+		{
+			"Quoted Strings",
+			"INSERT INTO a VALUES ('a;b', 'it''s; fine', '-- not a comment');",
+			[]string{"INSERT INTO a VALUES ('a;b', 'it''s; fine', '-- not a comment');"},
+		},
+		{
+			"Quoted Identifiers",
+			"SELECT \"a;b\", `c;d` FROM a;",
+			[]string{"SELECT \"a;b\", `c;d` FROM a;"},
+		},
+		{
+			"Dollar Quoted Bodies",
+			"CREATE FUNCTION f() RETURNS INT AS $body$ SELECT 1; $$ $body$ LANGUAGE SQL;\nDO $$ BEGIN PERFORM 1; END $$;",
+			[]string{
+				"CREATE FUNCTION f() RETURNS INT AS $body$ SELECT 1; $$ $body$ LANGUAGE SQL;",
+				"DO $$ BEGIN PERFORM 1; END $$;",
+			},
+		},
+		{
+			"Dollars In Identifiers",
+			"SELECT a$b$c FROM a; SELECT $1;",
+			[]string{"SELECT a$b$c FROM a;", "SELECT $1;"},
+		},
+		{
+			"Only Comments",
+			"-- nothing to do\n/* at all */",
+			nil,
+		},
+	}
+	for _, c := range cases {
+		stmts := splitStatements(c.body)
+		if len(stmts) != len(c.expected) {
+			t.Errorf("case %s: expected %d statements but got %d: %q", c.name, len(c.expected), len(stmts), stmts)
+			continue
+		}
+		for idx, stmt := range c.expected {
+			if stmts[idx] != stmt {
+				t.Errorf("case %s: expected statement %q but got %q", c.name, stmt, stmts[idx])
+			}
+		}
+	}
+}
+
+//newTestMigrator returns a migrator over two single-statement migrations
+func newTestMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator := &Migrator{
+		DB: db,
+		Migrations: []*Migration{
+			{Version: 1, Name: "a", Up: "CREATE TABLE a (id INT);", Down: "DROP TABLE a;"},
+			{Version: 2, Name: "b", Up: "CREATE TABLE b (id INT);", Down: "DROP TABLE b;"},
+		},
+		LockTimeout: defaultLockTimeout,
+		dialect:     dialects["mysql"],
+	}
+	return migrator, mock
+}
+
+//expectLock sets up the expectations for acquiring the migration lock
+func expectLock(mock sqlmock.Sqlmock, acquired int64) {
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetLock)).
+		WithArgs(lockName, 30).
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(acquired))
+}
+
+func TestUpAppliesPending(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	expectLock(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(sqlCreateMigrationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectApplied)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, 1580000000))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE b (id INT);")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(sqlInsertApplied)).
+		WithArgs(2, "b", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(sqlReleaseLock)).WithArgs(lockName).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	count, err := migrator.Up(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 migration applied but got %d", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpCheckFailed(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+	migrator.Migrations[1].Check = "SELECT reason FROM b_problems"
+
+	expectLock(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(sqlCreateMigrationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectApplied)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, 1580000000))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT reason FROM b_problems")).
+		WillReturnRows(sqlmock.NewRows([]string{"reason"}).AddRow("2 duplicate emails"))
+	mock.ExpectExec(regexp.QuoteMeta(sqlReleaseLock)).WithArgs(lockName).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	count, err := migrator.Up(context.Background())
+	if !errors.Is(err, ErrCheckFailed) || !strings.Contains(err.Error(), "2 duplicate emails") {
+		t.Errorf("expected ErrCheckFailed with the reason but got %v", err)
+	}
+	if count != 0 {
+		t.Errorf("expected no migrations applied but got %d", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpLockTimeout(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	expectLock(mock, 0)
+
+	if _, err := migrator.Up(context.Background()); err != ErrLockTimeout {
+		t.Errorf("expected ErrLockTimeout but got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDownRollsBackLatest(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	expectLock(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(sqlCreateMigrationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectApplied)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).
+			AddRow(1, 1580000000).AddRow(2, 1580000001))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE b;")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(sqlDeleteApplied)).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(sqlReleaseLock)).WithArgs(lockName).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	migration, err := migrator.Down(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if migration.Version != 2 {
+		t.Errorf("expected migration 2 rolled back but got %d", migration.Version)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDownNothingApplied(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+
+	expectLock(mock, 1)
+	mock.ExpectExec(regexp.QuoteMeta(sqlCreateMigrationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectApplied)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectExec(regexp.QuoteMeta(sqlReleaseLock)).WithArgs(lockName).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if _, err := migrator.Down(context.Background()); err != ErrNoMigration {
+		t.Errorf("expected ErrNoMigration but got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUpPostgres(t *testing.T) {
+	migrator, mock := newTestMigrator(t)
+	migrator.dialect = dialects["postgres"]
+
+	mock.ExpectQuery(regexp.QuoteMeta(sqlPgTryLock)).
+		WithArgs(pgLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(false))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlPgTryLock)).
+		WithArgs(pgLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"lock"}).AddRow(true))
+	mock.ExpectExec(regexp.QuoteMeta(sqlCreateMigrationsTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectApplied)).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	for _, m := range migrator.Migrations {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(m.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(sqlPgInsertApplied)).
+			WithArgs(m.Version, m.Name, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec(regexp.QuoteMeta(sqlPgReleaseLock)).WithArgs(pgLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	count, err := migrator.Up(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 migrations applied but got %d", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS user (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    email VARCHAR(191) NOT NULL,
+    passhash BINARY(60) NOT NULL,
+    user_name VARCHAR(191) NOT NULL,
+    first_name VARCHAR(128),
+    last_name VARCHAR(128),
+    photo_url VARCHAR(191) NOT NULL,
+    INDEX index_username (user_name),
+    INDEX index_email (email)
+);
@@ -0,0 +1,4 @@
+-- Each index is dropped and re-added as a plain one, whether or not it
+-- was made unique, so this also undoes a partially applied migration.
+ALTER TABLE user DROP INDEX index_username, ADD INDEX index_username (user_name);
+ALTER TABLE user DROP INDEX index_email, ADD INDEX index_email (email);
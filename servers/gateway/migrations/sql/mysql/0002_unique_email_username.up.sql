@@ -0,0 +1,5 @@
+-- MySQL commits each ALTER on its own, so if the second fails the first
+-- stays applied. Each one drops and re-adds its index, so running this
+-- again once the duplicates are gone finishes the job.
+ALTER TABLE user DROP INDEX index_username, ADD UNIQUE INDEX index_username (user_name);
+ALTER TABLE user DROP INDEX index_email, ADD UNIQUE INDEX index_email (email);
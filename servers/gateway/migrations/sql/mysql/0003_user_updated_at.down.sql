@@ -0,0 +1,2 @@
+DROP INDEX index_updated_at ON user;
+ALTER TABLE user DROP COLUMN updated_at;
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_deleted (
+    id INT NOT NULL PRIMARY KEY,
+    deleted_at BIGINT NOT NULL,
+    INDEX index_deleted_at (deleted_at)
+);
@@ -0,0 +1,4 @@
+-- MySQL's default collations compare case-insensitively, so the unique
+-- indexes from 0002 already reject emails and user names that only
+-- differ in case. This keeps the versions in step with PostgreSQL.
+DO 0;
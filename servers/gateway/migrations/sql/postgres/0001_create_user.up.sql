@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "user" (
+    id SERIAL PRIMARY KEY,
+    email VARCHAR(191) NOT NULL,
+    passhash BYTEA NOT NULL,
+    user_name VARCHAR(191) NOT NULL,
+    first_name VARCHAR(128),
+    last_name VARCHAR(128),
+    photo_url VARCHAR(191) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS index_username ON "user" (user_name);
+CREATE INDEX IF NOT EXISTS index_email ON "user" (email);
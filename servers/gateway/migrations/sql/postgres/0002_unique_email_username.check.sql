@@ -0,0 +1,7 @@
+SELECT CONCAT(COUNT(*), ' email(s) belong to more than one user, list them with SELECT email FROM "user" GROUP BY email HAVING COUNT(*) > 1 and remove the duplicates before migrating')
+FROM (SELECT email FROM "user" GROUP BY email HAVING COUNT(*) > 1) AS duplicates
+HAVING COUNT(*) > 0
+UNION ALL
+SELECT CONCAT(COUNT(*), ' user name(s) belong to more than one user, list them with SELECT user_name FROM "user" GROUP BY user_name HAVING COUNT(*) > 1 and remove the duplicates before migrating')
+FROM (SELECT user_name FROM "user" GROUP BY user_name HAVING COUNT(*) > 1) AS duplicates
+HAVING COUNT(*) > 0
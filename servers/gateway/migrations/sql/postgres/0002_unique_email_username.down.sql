@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS index_username;
+CREATE INDEX index_username ON "user" (user_name);
+DROP INDEX IF EXISTS index_email;
+CREATE INDEX index_email ON "user" (email);
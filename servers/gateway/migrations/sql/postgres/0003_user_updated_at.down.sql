@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS index_updated_at;
+ALTER TABLE "user" DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,2 @@
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS updated_at BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS index_updated_at ON "user" (updated_at);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_deleted (
+    id INTEGER PRIMARY KEY,
+    deleted_at BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS index_deleted_at ON user_deleted (deleted_at);
@@ -0,0 +1,7 @@
+SELECT CONCAT(COUNT(*), ' email(s) belong to more than one user once case is ignored, list them with SELECT LOWER(email) FROM "user" GROUP BY LOWER(email) HAVING COUNT(*) > 1 and remove the duplicates before migrating')
+FROM (SELECT LOWER(email) FROM "user" GROUP BY LOWER(email) HAVING COUNT(*) > 1) AS duplicates
+HAVING COUNT(*) > 0
+UNION ALL
+SELECT CONCAT(COUNT(*), ' user name(s) belong to more than one user once case is ignored, list them with SELECT LOWER(user_name) FROM "user" GROUP BY LOWER(user_name) HAVING COUNT(*) > 1 and remove the duplicates before migrating')
+FROM (SELECT LOWER(user_name) FROM "user" GROUP BY LOWER(user_name) HAVING COUNT(*) > 1) AS duplicates
+HAVING COUNT(*) > 0
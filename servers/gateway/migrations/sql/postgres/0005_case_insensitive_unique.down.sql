@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS index_username_lower;
+DROP INDEX IF EXISTS index_email_lower;
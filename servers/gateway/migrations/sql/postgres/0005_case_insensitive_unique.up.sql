@@ -0,0 +1,5 @@
+-- Reject emails and user names that only differ in case, like MySQL's
+-- case-insensitive collations do. The indexes from 0002 stay, since
+-- lookups compare the columns as they are.
+CREATE UNIQUE INDEX IF NOT EXISTS index_email_lower ON "user" (LOWER(email));
+CREATE UNIQUE INDEX IF NOT EXISTS index_username_lower ON "user" (LOWER(user_name));
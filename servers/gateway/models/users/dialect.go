@@ -0,0 +1,157 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+//mysqlDuplicateEntry is the MySQL error number for unique key violations
+const mysqlDuplicateEntry = 1062
+
+//pgUniqueViolation is the PostgreSQL error code for unique key violations
+const pgUniqueViolation = "23505"
+
+//unique index names from the migrations, used to tell which column clashed
+const emailIndex = "index_email"
+const userNameIndex = "index_username"
+
+const sqlPgInsertStatement = `
+INSERT INTO "user" (email, passhash, user_name, first_name, last_name, photo_url, updated_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id;`
+
+const sqlPgUpdateStatement = `
+  UPDATE "user" SET first_name=$1, last_name=$2, updated_at=$3 where id=$4;`
+
+const sqlPgDeleteStatement = `
+  Delete from "user" where id=$1;`
+
+const sqlPgGetByIDStatement = `SELECT ` + sqlUserColumns + ` from "user" where id=$1;`
+const sqlPgGetByIDsStatement = `SELECT ` + sqlUserColumns + ` from "user" where id IN (%s);`
+const sqlPgGetByEmailStatement = `SELECT ` + sqlUserColumns + ` from "user" where email=$1;`
+const sqlPgGetByUserNameStatement = `SELECT ` + sqlUserColumns + ` from "user" where user_name=$1;`
+const sqlPgSelectTrieStatement = `SELECT ` + sqlTrieColumns + ` from "user";`
+const sqlPgSelectTrieSinceStatement = `SELECT ` + sqlTrieColumns + ` from "user" where updated_at>=$1;`
+const sqlPgInsertDeletedStatement = `INSERT INTO user_deleted (id, deleted_at) VALUES ($1,$2)
+  ON CONFLICT (id) DO UPDATE SET deleted_at=EXCLUDED.deleted_at;`
+const sqlPgSelectDeletedSinceStatement = `SELECT id from user_deleted where deleted_at>=$1;`
+
+//Dialect holds the SQL that differs between the supported databases
+type Dialect struct {
+	//Driver is the database/sql driver name, also used to pick migrations
+	Driver string
+	//returningID is true when Insert reads the new ID with RETURNING
+	//instead of LastInsertId, which lib/pq does not support
+	returningID bool
+	//placeholder returns the bind parameter for the nth (1-based) argument
+	placeholder func(n int) string
+
+	insert        string
+	update        string
+	delete        string
+	getByID       string
+	getByIDs      string
+	getByEmail    string
+	getByUserName string
+	//selectTrie and selectTrieSince read only the columns the trie needs
+	selectTrie      string
+	selectTrieSince string
+	//insertDeleted and selectDeletedSince record and read deletions,
+	//which leave no row behind to replay
+	insertDeleted      string
+	selectDeletedSince string
+}
+
+//MySQL is the dialect for MySQL databases
+var MySQL = &Dialect{
+	Driver:             "mysql",
+	placeholder:        func(n int) string { return "?" },
+	insert:             sqlInsertStatement,
+	update:             sqlUpdateStatement,
+	delete:             sqlDeleteStatement,
+	getByID:            sqlGetByIDStatement,
+	getByIDs:           sqlGetByIDsStatement,
+	getByEmail:         sqlGetByEmailStatement,
+	getByUserName:      sqlGetByUserNameStatement,
+	selectTrie:         sqlSelectTrieStatement,
+	selectTrieSince:    sqlSelectTrieSinceStatement,
+	insertDeleted:      sqlInsertDeletedStatement,
+	selectDeletedSince: sqlSelectDeletedSinceStatement,
+}
+
+//Postgres is the dialect for PostgreSQL databases
+var Postgres = &Dialect{
+	Driver:             "postgres",
+	returningID:        true,
+	placeholder:        func(n int) string { return fmt.Sprintf("$%d", n) },
+	insert:             sqlPgInsertStatement,
+	update:             sqlPgUpdateStatement,
+	delete:             sqlPgDeleteStatement,
+	getByID:            sqlPgGetByIDStatement,
+	getByIDs:           sqlPgGetByIDsStatement,
+	getByEmail:         sqlPgGetByEmailStatement,
+	getByUserName:      sqlPgGetByUserNameStatement,
+	selectTrie:         sqlPgSelectTrieStatement,
+	selectTrieSince:    sqlPgSelectTrieSinceStatement,
+	insertDeleted:      sqlPgInsertDeletedStatement,
+	selectDeletedSince: sqlPgSelectDeletedSinceStatement,
+}
+
+//DialectFromDSN picks the dialect for a DSN. PostgreSQL DSNs are
+//URLs with a postgres:// or postgresql:// scheme, or lib/pq key=value
+//lists such as host=db user=root dbname=mydb; anything else is
+//treated as a go-sql-driver/mysql DSN such as user:pass@tcp(host)/db.
+func DialectFromDSN(dsn string) *Dialect {
+	lower := strings.ToLower(strings.TrimSpace(dsn))
+	if strings.HasPrefix(lower, "postgres://") || strings.HasPrefix(lower, "postgresql://") {
+		return Postgres
+	}
+	if isKeyValueDSN(lower) {
+		return Postgres
+	}
+	return MySQL
+}
+
+//isKeyValueDSN returns true if the DSN starts with a keyword=value
+//pair. A MySQL DSN can't: anything before its first '=' is part of
+//the user, password, address or database, which include one of
+//'@', ':', '/' or '('.
+func isKeyValueDSN(dsn string) bool {
+	key, _, found := strings.Cut(dsn, "=")
+	key = strings.TrimSpace(key)
+	if !found || key == "" {
+		return false
+	}
+	for _, r := range key {
+		if (r < 'a' || r > 'z') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+//placeholders returns a comma separated list of n bind parameters
+func (d *Dialect) placeholders(n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = d.placeholder(i + 1)
+	}
+	return strings.Join(params, ",")
+}
+
+//duplicateKey reports whether err is a unique key violation from either
+//driver, along with the text that names the violated index
+func duplicateKey(err error) (string, bool) {
+	var mysqlErr *mysql.MySQLError
+	var pqErr *pq.Error
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return mysqlErr.Message, true
+	}
+	if errors.As(err, &pqErr) && pqErr.Code == pgUniqueViolation {
+		return pqErr.Constraint, true
+	}
+	return "", false
+}
@@ -0,0 +1,259 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
+)
+
+//MemStore represents an in-process memory user store.
+//This should be used only for testing and local development.
+//Production systems should use a PostgressStore.
+type MemStore struct {
+	TrieNode *indexes.TrieNode
+	//Ranking weights the results of FindRankedInTrie
+	Ranking *indexes.RankOptions
+	users   map[int64]*User
+	//emails and userNames map case-folded emails and usernames to
+	//their users' IDs, since the SQL stores' unique indexes ignore case
+	emails    map[string]int64
+	userNames map[string]int64
+	lastID    int64
+	mx        sync.RWMutex
+}
+
+//NewMemStore constructs and returns a new, empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{
+		TrieNode:  indexes.NewTrieNode(),
+		Ranking:   DefaultRanking(),
+		users:     make(map[int64]*User),
+		emails:    make(map[string]int64),
+		userNames: make(map[string]int64),
+	}
+}
+
+//foldKey case-folds an email or username for the uniqueness checks
+func foldKey(key string) string {
+	return strings.ToLower(key)
+}
+
+//copyUser returns a copy of the user, so callers can't modify stored users
+func copyUser(user *User) *User {
+	copied := *user
+	copied.PassHash = append([]byte(nil), user.PassHash...)
+	return &copied
+}
+
+//FindInTrie returns relevant user IDs with names starts with prefix
+func (ms *MemStore) FindInTrie(prefix string, max int) ([]int64, error) {
+	return ms.TrieNode.Find(prefix, max), nil
+}
+
+//FindRankedInTrie returns the most relevant user IDs with names
+//starting with prefix, best first, with their scores
+func (ms *MemStore) FindRankedInTrie(prefix string, max int) ([]indexes.Result, error) {
+	return ms.TrieNode.FindRanked(prefix, max, ms.Ranking), nil
+}
+
+//FindAfterInTrie returns the next max user IDs with names starting
+//with prefix after the given entry, in name order, for paging
+func (ms *MemStore) FindAfterInTrie(prefix string, afterKey string, afterID int64, max int) ([]indexes.Entry, error) {
+	return ms.TrieNode.FindAfter(prefix, afterKey, afterID, max), nil
+}
+
+//FindFuzzyInTrie returns relevant user IDs with names starting with
+//something within maxEdits typos of prefix, closest first
+func (ms *MemStore) FindFuzzyInTrie(prefix string, maxEdits int, max int) ([]int64, error) {
+	return ms.TrieNode.FindFuzzy(prefix, maxEdits, max), nil
+}
+
+//LoadTrie rebuilds the Trie from the stored user accounts
+func (ms *MemStore) LoadTrie() error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	//replace the contents rather than the trie, so anything holding
+	//ms.TrieNode sees the new one
+	trie := indexes.NewTrieNode()
+	for _, user := range ms.users {
+		AddUserToTrie(user, trie)
+	}
+	ms.TrieNode.Replace(trie)
+	return nil
+}
+
+//GetByID returns the User with the given ID
+func (ms *MemStore) GetByID(id int64) (*User, error) {
+	ms.mx.RLock()
+	defer ms.mx.RUnlock()
+	user, found := ms.users[id]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	return copyUser(user), nil
+}
+
+//GetByIDs returns the Users with the given IDs in the same order,
+//skipping IDs with no matching user
+func (ms *MemStore) GetByIDs(ids []int64) ([]*User, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("No IDs provided")
+	}
+	ms.mx.RLock()
+	defer ms.mx.RUnlock()
+	seen := make(map[int64]bool, len(ids))
+	users := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		user, found := ms.users[id]
+		if !found || seen[id] {
+			continue
+		}
+		seen[id] = true
+		users = append(users, copyUser(user))
+	}
+	return users, nil
+}
+
+//GetByEmail returns the User with the given email
+func (ms *MemStore) GetByEmail(email string) (*User, error) {
+	return ms.findFirst(func(user *User) bool { return user.Email == email })
+}
+
+//GetByUserName returns the User with the given Username
+func (ms *MemStore) GetByUserName(username string) (*User, error) {
+	return ms.findFirst(func(user *User) bool { return user.UserName == username })
+}
+
+//findFirst returns a copy of the first stored user matching the predicate
+func (ms *MemStore) findFirst(matches func(user *User) bool) (*User, error) {
+	ms.mx.RLock()
+	defer ms.mx.RUnlock()
+	for _, user := range ms.users {
+		if matches(user) {
+			return copyUser(user), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+//Insert inserts the user, assigning it the next ID. Like the SQL
+//store, emails and usernames must be unique, ignoring case.
+func (ms *MemStore) Insert(user *User) (*User, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	email, userName := foldKey(user.Email), foldKey(user.UserName)
+	if _, taken := ms.emails[email]; taken {
+		return nil, ErrEmailTaken
+	}
+	if _, taken := ms.userNames[userName]; taken {
+		return nil, ErrUserNameTaken
+	}
+	ms.lastID++
+	user.ID = ms.lastID
+	ms.users[user.ID] = copyUser(user)
+	ms.emails[email] = user.ID
+	ms.userNames[userName] = user.ID
+	AddUserToTrie(user, ms.TrieNode)
+	return user, nil
+}
+
+//Update applies UserUpdates to the given user ID
+//and returns the newly-updated user
+func (ms *MemStore) Update(id int64, updates *Updates) (*User, error) {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	user, found := ms.users[id]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	RemoveUserFromTrie(user, ms.TrieNode)
+	user.FirstName = updates.FirstName
+	user.LastName = updates.LastName
+	AddUserToTrie(user, ms.TrieNode)
+	return copyUser(user), nil
+}
+
+//Delete deletes the user with the given ID
+func (ms *MemStore) Delete(id int64) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	user, found := ms.users[id]
+	if !found {
+		return ErrUserNotFound
+	}
+	RemoveUserFromTrie(user, ms.TrieNode)
+	delete(ms.users, id)
+	delete(ms.emails, foldKey(user.Email))
+	delete(ms.userNames, foldKey(user.UserName))
+	return nil
+}
+
+//The Context variants only check that ctx is still live, since
+//MemStore never blocks on I/O.
+
+//LoadTrieContext is LoadTrie, failing if ctx is already done
+func (ms *MemStore) LoadTrieContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ms.LoadTrie()
+}
+
+//GetByIDContext is GetByID, failing if ctx is already done
+func (ms *MemStore) GetByIDContext(ctx context.Context, id int64) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ms.GetByID(id)
+}
+
+//GetByIDsContext is GetByIDs, failing if ctx is already done
+func (ms *MemStore) GetByIDsContext(ctx context.Context, ids []int64) ([]*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ms.GetByIDs(ids)
+}
+
+//GetByEmailContext is GetByEmail, failing if ctx is already done
+func (ms *MemStore) GetByEmailContext(ctx context.Context, email string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ms.GetByEmail(email)
+}
+
+//GetByUserNameContext is GetByUserName, failing if ctx is already done
+func (ms *MemStore) GetByUserNameContext(ctx context.Context, username string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ms.GetByUserName(username)
+}
+
+//InsertContext is Insert, failing if ctx is already done
+func (ms *MemStore) InsertContext(ctx context.Context, user *User) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ms.Insert(user)
+}
+
+//UpdateContext is Update, failing if ctx is already done
+func (ms *MemStore) UpdateContext(ctx context.Context, id int64, updates *Updates) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return ms.Update(id, updates)
+}
+
+//DeleteContext is Delete, failing if ctx is already done
+func (ms *MemStore) DeleteContext(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ms.Delete(id)
+}
@@ -0,0 +1,47 @@
+package users_test
+
+import (
+	"testing"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users/storetest"
+)
+
+func TestMemStoreContract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) users.Store {
+		return users.NewMemStore()
+	})
+}
+
+func TestMemStoreReturnsCopies(t *testing.T) {
+	store := users.NewMemStore()
+	inserted, err := store.Insert(&users.User{Email: "ada@uw.edu", UserName: "ada", FirstName: "Ada"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	inserted.FirstName = "Changed"
+	found, _ := store.GetByID(inserted.ID)
+	found.LastName = "Changed"
+	found, _ = store.GetByID(inserted.ID)
+	if found.FirstName != "Ada" || found.LastName != "" {
+		t.Errorf("stored user was modified through a returned pointer: %+v", found)
+	}
+}
+
+func TestMemStoreLoadTrieKeepsTrie(t *testing.T) {
+	store := users.NewMemStore()
+	trie := store.TrieNode
+	inserted, err := store.Insert(&users.User{Email: "ada@uw.edu", UserName: "ada", FirstName: "Ada"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := store.LoadTrie(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.TrieNode != trie {
+		t.Fatal("LoadTrie replaced the trie, so holders of the old one see no more changes")
+	}
+	if ids := trie.Find("ada", 10); len(ids) != 1 || ids[0] != inserted.ID {
+		t.Errorf("expected the reloaded trie to contain %d but got %v", inserted.ID, ids)
+	}
+}
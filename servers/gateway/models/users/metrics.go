@@ -0,0 +1,28 @@
+package users
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//queryDuration times the queries of every PostgressStore by operation
+//and result
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gateway",
+	Subsystem: "users",
+	Name:      "query_duration_seconds",
+	Help:      "Time for the user database to answer each query, by operation and result.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"operation", "result"})
+
+//observeQuery records a query for the operation that started at start
+//and failed with err, if not nil
+func observeQuery(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	queryDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
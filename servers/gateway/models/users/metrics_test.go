@@ -0,0 +1,46 @@
+package users
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+//observations returns how many queries for the operation with the result were timed
+func observations(t *testing.T, operation string, result string) uint64 {
+	metric := &dto.Metric{}
+	observer := queryDuration.WithLabelValues(operation, result)
+	if err := observer.(prometheus.Metric).Write(metric); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestQueryMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+
+	okBefore, errorBefore := observations(t, "get_by_id", "ok"), observations(t, "get_by_id", "error")
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetByIDStatement)).WithArgs(1).WillReturnRows(userRows(1))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetByIDStatement)).WithArgs(2).WillReturnRows(userRows())
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetByIDStatement)).WithArgs(3).WillReturnError(errors.New("connection reset"))
+	store.GetByID(1)
+	//a user that doesn't exist is still an answer from the database
+	store.GetByID(2)
+	store.GetByID(3)
+
+	if count := observations(t, "get_by_id", "ok"); count != okBefore+2 {
+		t.Errorf("expected 2 successful queries but got %d", count-okBefore)
+	}
+	if count := observations(t, "get_by_id", "error"); count != errorBefore+1 {
+		t.Errorf("expected 1 failed query but got %d", count-errorBefore)
+	}
+}
@@ -1,54 +1,142 @@
 package users
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"os"
+	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
-	//import mysql
 	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/requestlog"
 )
 
+//sqlUserColumns lists the user columns in the order ScanRowsIntoUser reads them
+const sqlUserColumns = `id, email, passhash, user_name, first_name, last_name, photo_url`
+
+//sqlTrieColumns lists the only user columns the trie needs, so loading
+//it never reads password hashes
+const sqlTrieColumns = `id, user_name, first_name, last_name, updated_at`
+
 const sqlInsertStatement = `
-INSERT INTO user (email, passhash, user_name, first_name, last_name, photo_url)
-VALUES (?,?,?,?,?,?);`
+INSERT INTO user (email, passhash, user_name, first_name, last_name, photo_url, updated_at)
+VALUES (?,?,?,?,?,?,?);`
 
 const sqlUpdateStatement = `
-  UPDATE user SET first_name=?, last_name=? where id=?;`
+  UPDATE user SET first_name=?, last_name=?, updated_at=? where id=?;`
 
 const sqlDeleteStatement = `
   Delete from user where id=?;`
 
-const sqlGetByIDStatement = `SELECT * from user where id=?;`
-const sqlGetByEmailStatement = `SELECT * from user where email=?;`
-const sqlGetByUserNameStatement = `SELECT * from user where user_name=?;`
+const sqlGetByIDStatement = `SELECT ` + sqlUserColumns + ` from user where id=?;`
+const sqlGetByIDsStatement = `SELECT ` + sqlUserColumns + ` from user where id IN (%s);`
+const sqlGetByEmailStatement = `SELECT ` + sqlUserColumns + ` from user where email=?;`
+const sqlGetByUserNameStatement = `SELECT ` + sqlUserColumns + ` from user where user_name=?;`
+const sqlSelectTrieStatement = `SELECT ` + sqlTrieColumns + ` from user;`
+const sqlSelectTrieSinceStatement = `SELECT ` + sqlTrieColumns + ` from user where updated_at>=?;`
+const sqlInsertDeletedStatement = `INSERT INTO user_deleted (id, deleted_at) VALUES (?,?)
+  ON DUPLICATE KEY UPDATE deleted_at=VALUES(deleted_at);`
+const sqlSelectDeletedSinceStatement = `SELECT id from user_deleted where deleted_at>=?;`
+
+//DefaultQueryTimeout bounds each query made by a PostgressStore
+const DefaultQueryTimeout = 5 * time.Second
+
+//updatedAt returns the updated_at value for a row written now
+func updatedAt() int64 {
+	return time.Now().UnixNano()
+}
+
+//connectRetries and connectRetryInterval control how long
+//ConnectToPostgres waits for the database to come up
+const connectRetries = 10
+const connectRetryInterval = 3 * time.Second
 
-//PostgressStore stores db pointer
+//PostgressStore stores db pointer. It is backed by either MySQL
+//or PostgreSQL, depending on its Dialect.
 type PostgressStore struct {
 	PostgressDB *sql.DB
 	TrieNode    *indexes.TrieNode
+	Dialect     *Dialect
+	//QueryTimeout bounds every query except the full scan in LoadTrie
+	QueryTimeout time.Duration
+	//Ranking weights the results of FindRankedInTrie
+	Ranking *indexes.RankOptions
+	//Publisher, if set, tells other gateway replicas about changes
+	//this store makes to the trie. See ApplyTrieEvent.
+	Publisher TriePublisher
+	//ReplicaID identifies this store's events to ApplyTrieEvent
+	ReplicaID string
+	//trieSince is the latest updated_at the trie was loaded or
+	//changed up to
+	trieSince int64
+	//versions orders trie changes from every replica
+	versions *trieVersions
+	//trieMx orders changes to TrieNode against replacing it
+	trieMx sync.Mutex
+	//pending holds the changes made while a new trie is being built,
+	//to be made to it too; it is nil the rest of the time
+	pending []func(trie *indexes.TrieNode)
+	//rebuildMx lets only one new trie be built at a time
+	rebuildMx sync.Mutex
 }
 
-//NewPostgressStore constructs a new MySQLStore
-func NewPostgressStore(db *sql.DB) *PostgressStore {
+//NewPostgressStore constructs a new SQL store speaking the given dialect
+func NewPostgressStore(db *sql.DB, dialect *Dialect) *PostgressStore {
 	if db == nil {
 		panic("nil database pointer passed to PostgressStore")
 	}
+	if dialect == nil {
+		panic("nil dialect passed to PostgressStore")
+	}
 	return &PostgressStore{
-		PostgressDB: db,
+		PostgressDB:  db,
+		TrieNode:     indexes.NewTrieNode(),
+		Dialect:      dialect,
+		QueryTimeout: DefaultQueryTimeout,
+		Ranking:      DefaultRanking(),
+		ReplicaID:    newReplicaID(),
+		versions:     newTrieVersions(),
 	}
 }
 
+//withTimeout derives a context bounded by the store's QueryTimeout
+func (store *PostgressStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if store.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, store.QueryTimeout)
+}
+
 //FindInTrie returns relevant user IDs with names starts with prefix
 func (store *PostgressStore) FindInTrie(prefix string, max int) ([]int64, error) {
 	results := store.TrieNode.Find(prefix, max)
 	return results, nil
 }
 
+//FindRankedInTrie returns the most relevant user IDs with names
+//starting with prefix, best first, with their scores
+func (store *PostgressStore) FindRankedInTrie(prefix string, max int) ([]indexes.Result, error) {
+	results := store.TrieNode.FindRanked(prefix, max, store.Ranking)
+	return results, nil
+}
+
+//FindAfterInTrie returns the next max user IDs with names starting
+//with prefix after the given entry, in name order, for paging
+func (store *PostgressStore) FindAfterInTrie(prefix string, afterKey string, afterID int64, max int) ([]indexes.Entry, error) {
+	results := store.TrieNode.FindAfter(prefix, afterKey, afterID, max)
+	return results, nil
+}
+
+//FindFuzzyInTrie returns relevant user IDs with names starting with
+//something within maxEdits typos of prefix, closest first
+func (store *PostgressStore) FindFuzzyInTrie(prefix string, maxEdits int, max int) ([]int64, error) {
+	results := store.TrieNode.FindFuzzy(prefix, maxEdits, max)
+	return results, nil
+}
+
 //ScanRowsIntoUser scans rows into the user struct.
 func ScanRowsIntoUser(rows *sql.Rows, err error, store *PostgressStore) ([]*User, error) {
 	var users []*User
@@ -56,7 +144,7 @@ func ScanRowsIntoUser(rows *sql.Rows, err error, store *PostgressStore) ([]*User
 		newUser := &User{}
 		if err := rows.Scan(&newUser.ID, &newUser.Email, &newUser.PassHash, &newUser.UserName,
 			&newUser.FirstName, &newUser.LastName, &newUser.PhotoURL); err != nil {
-			fmt.Printf("error scanning row: %v\n", err)
+			slog.Error("Failed to scan user row", "error", err)
 		}
 		users = append(users, newUser)
 	}
@@ -69,197 +157,341 @@ func ScanRowsIntoUser(rows *sql.Rows, err error, store *PostgressStore) ([]*User
 	return users, nil
 }
 
-//GetByID Get user by given ID. Will return a user struct and error (nil if no error).
-func (store *PostgressStore) GetByID(id int64) (*User, error) {
-	rows, err := store.PostgressDB.Query(sqlGetByIDStatement, id)
-	defer rows.Close()
+//queryError wraps the error from a failed query, preferring the context's
+//error so callers can tell timeouts and cancellations from SQL errors
+func queryError(ctx context.Context, failure string, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	return fmt.Errorf("%s: %w", failure, err)
+}
+
+//queryOne runs a query for the operation expected to match at most one user
+func (store *PostgressStore) queryOne(ctx context.Context, operation string, failure string, query string, args ...interface{}) (*User, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	rows, err := store.PostgressDB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, errors.New("Failed GET query using user id")
+		observeQuery(operation, start, err)
+		return nil, queryError(ctx, failure, err)
 	}
+	defer rows.Close()
 	newUsers, newErr := ScanRowsIntoUser(rows, err, store)
+	observeQuery(operation, start, newErr)
 	if newErr != nil {
 		return nil, errors.New("Failed scanning rows")
 	}
-	fmt.Printf("Get query success using user id=%d\n", id)
+	if len(newUsers) == 0 {
+		return nil, ErrUserNotFound
+	}
 	return newUsers[0], nil
 }
 
-//GetByIDs Get user by given ID. Will return a user struct and error (nil if no error).
+//GetByID Get user by given ID. Will return a user struct and error (nil if no error).
+func (store *PostgressStore) GetByID(id int64) (*User, error) {
+	return store.GetByIDContext(context.Background(), id)
+}
+
+//GetByIDContext is GetByID bounded by ctx and the store's QueryTimeout
+func (store *PostgressStore) GetByIDContext(ctx context.Context, id int64) (*User, error) {
+	user, err := store.queryOne(ctx, "get_by_id", "Failed GET query using user id", store.Dialect.getByID, id)
+	if err != nil {
+		return nil, err
+	}
+	requestlog.Logger(ctx).Debug("Got user by id", "user_id", id)
+	return user, nil
+}
+
+//GetByIDs Get users by given IDs with a single query. Users are returned in the
+//same order as the given IDs, and IDs with no matching user are skipped.
 func (store *PostgressStore) GetByIDs(ids []int64) ([]*User, error) {
+	return store.GetByIDsContext(context.Background(), ids)
+}
+
+//GetByIDsContext is GetByIDs bounded by ctx and the store's QueryTimeout
+func (store *PostgressStore) GetByIDsContext(ctx context.Context, ids []int64) ([]*User, error) {
 	if len(ids) == 0 {
 		return nil, errors.New("No IDs provided")
 	}
-	var users []*User
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := fmt.Sprintf(store.Dialect.getByIDs, store.Dialect.placeholders(len(ids)))
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	rows, err := store.PostgressDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		observeQuery("get_by_ids", start, err)
+		return nil, queryError(ctx, "Failed GET query using user ids", err)
+	}
+	defer rows.Close()
+	newUsers, newErr := ScanRowsIntoUser(rows, err, store)
+	observeQuery("get_by_ids", start, newErr)
+	if newErr != nil {
+		return nil, errors.New("Failed scanning rows")
+	}
+	//index rows by ID so results follow the order of the given IDs
+	byID := make(map[int64]*User, len(newUsers))
+	for _, user := range newUsers {
+		byID[user.ID] = user
+	}
+	users := make([]*User, 0, len(newUsers))
 	for _, id := range ids {
-		rows, err := store.PostgressDB.Query(sqlGetByIDStatement, id)
-		defer rows.Close()
-		if err != nil {
-			return nil, errors.New("Failed GET query using user id")
+		if user, found := byID[id]; found {
+			users = append(users, user)
+			//skip duplicate IDs
+			delete(byID, id)
 		}
-		newUsers, newErr := ScanRowsIntoUser(rows, err, store)
-		if newErr != nil {
-			return nil, errors.New("Failed scanning rows")
-		}
-		users = append(users, newUsers[0])
 	}
 	return users, nil
 }
 
 //GetByEmail Get user by given email. Will return a user struct and error (nil if no error).
 func (store *PostgressStore) GetByEmail(email string) (*User, error) {
-	rows, err := store.PostgressDB.Query(sqlGetByEmailStatement, email)
-	defer rows.Close()
+	return store.GetByEmailContext(context.Background(), email)
+}
+
+//GetByEmailContext is GetByEmail bounded by ctx and the store's QueryTimeout
+func (store *PostgressStore) GetByEmailContext(ctx context.Context, email string) (*User, error) {
+	user, err := store.queryOne(ctx, "get_by_email", "Failed GET query using email", store.Dialect.getByEmail, email)
 	if err != nil {
-		return nil, errors.New("Failed GET query using email")
+		return nil, err
 	}
-	newUsers, newErr := ScanRowsIntoUser(rows, err, store)
-	if newErr != nil {
-		return nil, errors.New("Failed scanning rows")
-	}
-	fmt.Printf("Get query success using email\n")
-	return newUsers[0], nil
-
+	requestlog.Logger(ctx).Debug("Got user by email", "user_id", user.ID)
+	return user, nil
 }
 
 //GetByUserName Get user by given username. Will return a user struct and error (nil if no error).
 func (store *PostgressStore) GetByUserName(username string) (*User, error) {
-	rows, err := store.PostgressDB.Query(sqlGetByUserNameStatement, username)
-	defer rows.Close()
+	return store.GetByUserNameContext(context.Background(), username)
+}
+
+//GetByUserNameContext is GetByUserName bounded by ctx and the store's QueryTimeout
+func (store *PostgressStore) GetByUserNameContext(ctx context.Context, username string) (*User, error) {
+	user, err := store.queryOne(ctx, "get_by_user_name", "Failed GET query using UserName", store.Dialect.getByUserName, username)
 	if err != nil {
-		return nil, errors.New("Failed GET query using UserName")
-	}
-	newUsers, newErr := ScanRowsIntoUser(rows, err, store)
-	if newErr != nil {
-		return nil, errors.New("Failed scanning rows")
+		return nil, err
 	}
-	fmt.Printf("Get query success using UserName\n")
-	return newUsers[0], nil
-
+	requestlog.Logger(ctx).Debug("Got user by user name", "user_id", user.ID)
+	return user, nil
 }
 
 //Insert a contact with the given user. Will return a user struct and an error (nil if no error).
 func (store *PostgressStore) Insert(user *User) (*User, error) {
+	return store.InsertContext(context.Background(), user)
+}
 
-	res, err := store.PostgressDB.Exec(sqlInsertStatement, user.Email, user.PassHash, user.UserName,
-		user.FirstName, user.LastName, user.PhotoURL)
-	if err != nil {
-		fmt.Printf("error inserting new row: %v\n", err)
-	} else {
-		//get the auto-assigned ID for the new row
-		fmt.Printf("Success inserting new row:")
-		lastInsertID, err := res.LastInsertId()
+//InsertContext is Insert bounded by ctx and the store's QueryTimeout
+func (store *PostgressStore) InsertContext(ctx context.Context, user *User) (*User, error) {
+	now := updatedAt()
+	args := []interface{}{user.Email, user.PassHash, user.UserName,
+		user.FirstName, user.LastName, user.PhotoURL, now}
+	queryCtx, cancel := store.withTimeout(ctx)
+	defer cancel()
+	if store.Dialect.returningID {
+		//lib/pq has no LastInsertId, so read the ID back with RETURNING
+		var newID int64
+		start := time.Now()
+		err := store.PostgressDB.QueryRowContext(queryCtx, store.Dialect.insert, args...).Scan(&newID)
+		observeQuery("insert", start, err)
 		if err != nil {
-			fmt.Printf("error reading new row: %v\n", err)
+			requestlog.Logger(ctx).Debug("Failed to insert user", "error", err)
+			if uniqueErr := store.uniqueViolation(ctx, err, user); uniqueErr != nil {
+				return nil, uniqueErr
+			}
+			return nil, queryError(queryCtx, "Failed Insert", err)
 		}
-		user.ID = lastInsertID
-		AddUserToTrie(user, store)
-		return user, err
+		user.ID = newID
+		store.changeTrie(func(trie *indexes.TrieNode) {
+			AddUserToTrie(user, trie)
+		})
+		store.publishUser(user, now)
+		return user, nil
 	}
-	return nil, errors.New("Failed Insert")
 
+	start := time.Now()
+	res, err := store.PostgressDB.ExecContext(queryCtx, store.Dialect.insert, args...)
+	observeQuery("insert", start, err)
+	if err != nil {
+		requestlog.Logger(ctx).Debug("Failed to insert user", "error", err)
+		if uniqueErr := store.uniqueViolation(ctx, err, user); uniqueErr != nil {
+			return nil, uniqueErr
+		}
+		return nil, queryError(queryCtx, "Failed Insert", err)
+	}
+	//get the auto-assigned ID for the new row
+	lastInsertID, err := res.LastInsertId()
+	if err != nil {
+		requestlog.Logger(ctx).Error("Failed to read inserted user id", "error", err)
+	}
+	user.ID = lastInsertID
+	store.changeTrie(func(trie *indexes.TrieNode) {
+		AddUserToTrie(user, trie)
+	})
+	store.publishUser(user, now)
+	return user, err
+}
+
+//uniqueViolation converts a duplicate key error from inserting the user into
+//ErrEmailTaken or ErrUserNameTaken. It returns nil for any other error.
+func (store *PostgressStore) uniqueViolation(ctx context.Context, err error, user *User) error {
+	index, duplicate := duplicateKey(err)
+	if !duplicate {
+		return nil
+	}
+	switch {
+	case strings.Contains(index, emailIndex):
+		return ErrEmailTaken
+	case strings.Contains(index, userNameIndex):
+		return ErrUserNameTaken
+	}
+	//not every server names the index, so check which value clashed
+	if _, err := store.GetByEmailContext(ctx, user.Email); err == nil {
+		return ErrEmailTaken
+	}
+	return ErrUserNameTaken
 }
 
 //Update a contact with the given ID and update fields. Will return nil or an error.
 func (store *PostgressStore) Update(id int64, updates *Updates) (*User, error) {
-	oldUser, err := store.GetByID(id)
+	return store.UpdateContext(context.Background(), id, updates)
+}
+
+//UpdateContext is Update bounded by ctx and the store's QueryTimeout
+func (store *PostgressStore) UpdateContext(ctx context.Context, id int64, updates *Updates) (*User, error) {
+	oldUser, err := store.GetByIDContext(ctx, id)
 	if err != nil {
-		return nil, errors.New("Failed to retrieve user with given ID")
-	}
-	//remove user's old names from trie
-	fname := strings.ToLower(oldUser.FirstName)
-	lname := strings.ToLower(oldUser.LastName)
-	store.TrieNode.Remove(fname, id)
-	store.TrieNode.Remove(lname, id)
-	//Update fields in sql
-	res, err := store.PostgressDB.Exec(sqlUpdateStatement, updates.FirstName, updates.LastName, id)
-	fmt.Printf("Res:%v", res)
+		return nil, fmt.Errorf("Failed to retrieve user with given ID: %w", err)
+	}
+	//Update fields in sql. The old names stay searchable until it succeeds.
+	queryCtx, cancel := store.withTimeout(ctx)
+	defer cancel()
+	now := updatedAt()
+	start := time.Now()
+	_, err = store.PostgressDB.ExecContext(queryCtx, store.Dialect.update, updates.FirstName, updates.LastName, now, id)
+	observeQuery("update", start, err)
 	if err != nil {
-		return nil, errors.New("Failed Update")
+		return nil, queryError(queryCtx, "Failed Update", err)
 	}
-	user, err := store.GetByID(id)
+	user, err := store.GetByIDContext(ctx, id)
 	if err != nil {
-		return nil, errors.New("Failed to get user")
+		//the update went through, so index the new names without rereading them
+		newUser := *oldUser
+		newUser.FirstName = updates.FirstName
+		newUser.LastName = updates.LastName
+		store.changeTrie(func(trie *indexes.TrieNode) {
+			RemoveUserFromTrie(oldUser, trie)
+			AddUserToTrie(&newUser, trie)
+		})
+		store.publishUser(&newUser, now)
+		return nil, fmt.Errorf("Failed to get user: %w", err)
 	}
-	//Add new user names back to Trie
-	AddUserToTrie(user, store)
+	//Swap the user's old names in the trie for the new ones
+	store.changeTrie(func(trie *indexes.TrieNode) {
+		RemoveUserFromTrie(oldUser, trie)
+		AddUserToTrie(user, trie)
+	})
+	store.publishUser(user, now)
 	return user, err
 }
 
 //Delete a contact with the given ID. Will return nil or an error.
 func (store *PostgressStore) Delete(id int64) error {
+	return store.DeleteContext(context.Background(), id)
+}
 
-	res, err := store.PostgressDB.Exec(sqlDeleteStatement, id)
+//DeleteContext is Delete bounded by ctx and the store's QueryTimeout
+func (store *PostgressStore) DeleteContext(ctx context.Context, id int64) error {
+	oldUser, err := store.GetByIDContext(ctx, id)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Res found: %v", res)
-	fmt.Printf("Res deleted: %v", id)
+	queryCtx, cancel := store.withTimeout(ctx)
+	defer cancel()
+	now := updatedAt()
+	start := time.Now()
+	err = store.deleteRow(queryCtx, id, now)
+	observeQuery("delete", start, err)
+	if err != nil {
+		return queryError(queryCtx, "Failed Delete", err)
+	}
+	store.changeTrie(func(trie *indexes.TrieNode) {
+		RemoveUserFromTrie(oldUser, trie)
+	})
+	store.publish(&TrieEvent{ID: id, Deleted: true, UpdatedAt: now})
+	requestlog.Logger(ctx).Debug("Deleted user", "user_id", id)
 	return nil
 }
 
-//ConnectToPostgres opens db connection
-func ConnectToPostgres(dsn string) (*PostgressStore, error) {
-	db, err := sql.Open("mysql", dsn)
+//deleteRow deletes the user's row and records when in user_deleted,
+//so loading a trie snapshot only replays the users deleted since. An id
+//deleted before (ids can be reused) has its deleted_at refreshed
+func (store *PostgressStore) deleteRow(ctx context.Context, id int64, deletedAt int64) error {
+	tx, err := store.PostgressDB.BeginTx(ctx, nil)
 	if err != nil {
-		fmt.Printf("error opening database: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, store.Dialect.delete, id); err != nil {
+		tx.Rollback()
+		return err
 	}
+	if _, err := tx.ExecContext(ctx, store.Dialect.insertDeleted, id, deletedAt); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
 
-	err = db.Ping()
+//ConnectToPostgres opens db connection, using the MySQL or PostgreSQL
+//driver depending on the DSN (see DialectFromDSN)
+func ConnectToPostgres(dsn string) (*PostgressStore, error) {
+	return ConnectToPostgresContext(context.Background(), dsn)
+}
+
+//ConnectToPostgresContext is ConnectToPostgres, but gives up waiting
+//for the database once ctx is done
+func ConnectToPostgresContext(ctx context.Context, dsn string) (*PostgressStore, error) {
+	dialect := DialectFromDSN(dsn)
+	db, err := sql.Open(dialect.Driver, dsn)
 	if err != nil {
-		counter := 0
-		for {
-			time.Sleep(3 * time.Second)
-			counter++
-			if err = db.Ping(); err == nil {
-				break
-			}
-			if counter == 10 {
-				return nil, errors.New("Unable to ping db")
-			}
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+
+	for counter := 0; ; counter++ {
+		if err = db.PingContext(ctx); err == nil {
+			break
+		}
+		if counter == connectRetries {
+			db.Close()
+			return nil, fmt.Errorf("Unable to ping db: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("Unable to ping db: %w", ctx.Err())
+		case <-time.After(connectRetryInterval):
 		}
 	}
-	newPostgressStore := NewPostgressStore(db)
+	newPostgressStore := NewPostgressStore(db, dialect)
 
 	return newPostgressStore, nil
 }
 
 //LoadTrie populates Trie with existing user accounts
 func (store *PostgressStore) LoadTrie() error {
-	store.TrieNode = indexes.NewTrieNode()
-	rows, err := store.PostgressDB.Query("SELECT * from user")
-	if err != nil {
-		return errors.New("User store not ready")
-	}
-	defer rows.Close()
-	newUsers, newErr := ScanRowsIntoUser(rows, err, store)
-	if newErr != nil {
-		return errors.New("Failed scanning rows")
-	}
-	for _, user := range newUsers {
-		AddUserToTrie(user, store)
-	}
-	return nil
+	return store.LoadTrieContext(context.Background())
 }
 
-//AddUserToTrie inserts username, lastname, firstname of the given user into Trie
-func AddUserToTrie(user *User, store *PostgressStore) {
-	//To lower case
-	fname := strings.ToLower(user.FirstName)
-	lname := strings.ToLower(user.LastName)
-	uname := strings.ToLower(user.UserName)
-	//Split string on white-space
-	fslice := strings.Fields(fname)
-	lslice := strings.Fields(lname)
-	uslice := strings.Fields(uname)
-
-	for _, f := range fslice {
-		store.TrieNode.Add(f, user.ID)
-	}
-	for _, l := range lslice {
-		store.TrieNode.Add(l, user.ID)
-	}
-	for _, u := range uslice {
-		store.TrieNode.Add(u, user.ID)
-	}
+//LoadTrieContext is LoadTrie bounded by ctx. The full table scan is
+//not bound by QueryTimeout, since it grows with the number of users.
+func (store *PostgressStore) LoadTrieContext(ctx context.Context) error {
+	return store.replaceTrie(func(trie *indexes.TrieNode) (int64, error) {
+		start := time.Now()
+		since, err := replayTrie(ctx, store.PostgressDB, trie, store.Dialect.selectTrie)
+		observeQuery("load_trie", start, err)
+		return since, err
+	})
 }
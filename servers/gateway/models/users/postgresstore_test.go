@@ -1,8 +1,14 @@
 package users
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 )
@@ -13,7 +19,7 @@ func TestPostgressStore(t *testing.T) {
 		t.Fatalf("error creating sqlmock: %v", err)
 	}
 	defer db.Close()
-	postgresstore := NewPostgressStore(db)
+	postgresstore := NewPostgressStore(db, MySQL)
 
 	//Insert()
 	expectedInsertSQL := regexp.QuoteMeta(sqlInsertStatement)
@@ -39,6 +45,7 @@ func TestPostgressStore(t *testing.T) {
 			newUser.FirstName,
 			newUser.LastName,
 			newUser.PhotoURL,
+			sqlmock.AnyArg(),
 		).
 		// with these results
 		WillReturnResult(sqlmock.NewResult(newID, 1))
@@ -88,6 +95,254 @@ func TestPostgressStore(t *testing.T) {
 
 }
 
+//userColumns are the columns of the user table in schema order
+var userColumns = []string{"id", "email", "passhash", "user_name", "first_name", "last_name", "photo_url"}
+
+//expectedGetByIDsSQL returns the IN query expected for n ids
+func expectedGetByIDsSQL(n int) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", n), ",")
+	return regexp.QuoteMeta(fmt.Sprintf(sqlGetByIDsStatement, placeholders))
+}
+
+//userRows returns mock rows for users with the given ids
+func userRows(ids ...int64) *sqlmock.Rows {
+	rows := sqlmock.NewRows(userColumns)
+	for _, id := range ids {
+		rows.AddRow(id, fmt.Sprintf("user%d@uw.edu", id), []byte{1, 2, 3},
+			fmt.Sprintf("user%d", id), "first", "last", "photo")
+	}
+	return rows
+}
+
+func TestGetByIDs(t *testing.T) {
+	cases := []struct {
+		name     string
+		ids      []int64
+		rowIDs   []int64
+		expected []int64
+	}{
+		{
+			"Single ID",
+			[]int64{1},
+			[]int64{1},
+			[]int64{1},
+		},
+		{
+			"Order Follows Given IDs",
+			[]int64{3, 1, 2},
+			[]int64{1, 2, 3},
+			[]int64{3, 1, 2},
+		},
+		{
+			"Missing IDs Skipped",
+			[]int64{4, 1, 5, 2},
+			[]int64{1, 2},
+			[]int64{1, 2},
+		},
+		{
+			"No Matching Users",
+			[]int64{7, 8},
+			[]int64{},
+			[]int64{},
+		},
+		{
+			"Duplicate IDs",
+			[]int64{2, 1, 2},
+			[]int64{1, 2},
+			[]int64{2, 1},
+		},
+	}
+
+	for _, c := range cases {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error creating sqlmock: %v", err)
+		}
+		store := NewPostgressStore(db, MySQL)
+
+		args := make([]driver.Value, len(c.ids))
+		for i, id := range c.ids {
+			args[i] = id
+		}
+		mock.ExpectQuery(expectedGetByIDsSQL(len(c.ids))).
+			WithArgs(args...).
+			WillReturnRows(userRows(c.rowIDs...))
+
+		users, err := store.GetByIDs(c.ids)
+		if err != nil {
+			t.Fatalf("case %s: unexpected error: %v", c.name, err)
+		}
+		if len(users) != len(c.expected) {
+			t.Fatalf("case %s: incorrect number of users: expected %d but got %d",
+				c.name, len(c.expected), len(users))
+		}
+		for idx, id := range c.expected {
+			if users[idx].ID != id {
+				t.Errorf("case %s: incorrect user at %d: expected %d but got %d",
+					c.name, idx, id, users[idx].ID)
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("case %s: there were unfulfilled expectations: %s", c.name, err)
+		}
+		db.Close()
+	}
+}
+
+func TestGetByIDsEmpty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+
+	if _, err := store.GetByIDs(nil); err == nil {
+		t.Errorf("expected error when no IDs are provided")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("no query expected for empty IDs: %s", err)
+	}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+	store.QueryTimeout = 10 * time.Millisecond
+
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetByIDStatement)).
+		WithArgs(1).
+		WillDelayFor(time.Second).
+		WillReturnRows(userRows(1))
+
+	start := time.Now()
+	_, err = store.GetByID(1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("query was not abandoned after the timeout, took %v", elapsed)
+	}
+}
+
+//TestUpdateKeepsUserSearchable checks that a user stays in the trie
+//under their old names while the update runs and after it fails
+func TestUpdateKeepsUserSearchable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+	AddUserToTrie(&User{ID: 1, UserName: "user1", FirstName: "first", LastName: "last"}, store.TrieNode)
+	entries := store.TrieNode.Len()
+
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetByIDStatement)).
+		WithArgs(1).
+		WillReturnRows(userRows(1))
+	mock.ExpectExec(regexp.QuoteMeta(sqlUpdateStatement)).
+		WithArgs("new", "name", sqlmock.AnyArg(), 1).
+		WillDelayFor(100 * time.Millisecond).
+		WillReturnError(errors.New("lock wait timeout"))
+
+	done := make(chan error)
+	go func() {
+		_, err := store.Update(1, &Updates{FirstName: "new", LastName: "name"})
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if ids, _ := store.FindInTrie("first", 10); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("expected user 1 to be searchable during the update but got %v", ids)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("expected the update to fail")
+	}
+	if ids, _ := store.FindInTrie("first", 10); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("expected user 1 under their old name after the failed update but got %v", ids)
+	}
+	if l := store.TrieNode.Len(); l != entries {
+		t.Errorf("expected %d trie entries after the failed update but got %d", entries, l)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+//TestDeleteTwice checks that deleting an id already in user_deleted,
+//as happens when ids are reused, refreshes its tombstone
+func TestDeleteTwice(t *testing.T) {
+	for _, dialect := range []*Dialect{MySQL, Postgres} {
+		t.Run(dialect.Driver, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("error creating sqlmock: %v", err)
+			}
+			defer db.Close()
+			store := NewPostgressStore(db, dialect)
+
+			for i := 0; i < 2; i++ {
+				mock.ExpectQuery(regexp.QuoteMeta(dialect.getByID)).
+					WithArgs(1).
+					WillReturnRows(userRows(1))
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(dialect.delete)).
+					WithArgs(1).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				//the second insert hits the existing tombstone and updates it
+				mock.ExpectExec(regexp.QuoteMeta(dialect.insertDeleted)).
+					WithArgs(1, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, int64(i+1)))
+				mock.ExpectCommit()
+			}
+			for i := 0; i < 2; i++ {
+				if err := store.Delete(1); err != nil {
+					t.Fatalf("delete %d: unexpected error: %v", i+1, err)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+			for _, statement := range []string{"ON DUPLICATE KEY UPDATE", "ON CONFLICT"} {
+				if strings.Contains(dialect.insertDeleted, statement) {
+					return
+				}
+			}
+			t.Errorf("expected %q to update an existing tombstone", dialect.insertDeleted)
+		})
+	}
+}
+
+func BenchmarkGetByIDs(b *testing.B) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		b.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+
+	//maxNumResult in handlers caps searches at 20 ids
+	ids := make([]int64, 20)
+	for i := range ids {
+		ids[i] = int64(len(ids) - i)
+	}
+	query := expectedGetByIDsSQL(len(ids))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		mock.ExpectQuery(query).WillReturnRows(userRows(ids...))
+		b.StartTimer()
+		if _, err := store.GetByIDs(ids); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
 // func TestSelectById(t *testing.T) {
 
 // }
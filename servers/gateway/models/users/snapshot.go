@@ -0,0 +1,152 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
+)
+
+//A trie snapshot file is a 12 byte header, holding the updated_at the
+//trie was loaded up to and a CRC-32C of it, followed by the trie
+//itself in the indexes snapshot format.
+
+//snapshotHeaderLen is the length of the header before the trie
+const snapshotHeaderLen = 12
+
+//snapshotClockSkew is how far back from a snapshot's updated_at rows
+//are replayed, since updated_at comes from each gateway's own clock
+const snapshotClockSkew = time.Minute
+
+//ErrSnapshotHeader is returned when a snapshot's header is corrupt
+var ErrSnapshotHeader = errors.New("trie snapshot header checksum mismatch")
+
+//crcTable is the Castagnoli table used for snapshot header checksums
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//SaveTrieSnapshot writes a snapshot of the trie to w. Loading it with
+//LoadTrieSnapshot and replaying the rows changed since is much faster
+//than LoadTrie, and never reads password hashes.
+func (store *PostgressStore) SaveTrieSnapshot(w io.Writer) error {
+	header := binary.BigEndian.AppendUint64(nil, uint64(atomic.LoadInt64(&store.trieSince)))
+	header = binary.BigEndian.AppendUint32(header, crc32.Checksum(header, crcTable))
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("Failed writing trie snapshot: %w", err)
+	}
+	if _, err := store.TrieNode.WriteTo(w); err != nil {
+		return fmt.Errorf("Failed writing trie snapshot: %w", err)
+	}
+	return nil
+}
+
+//LoadTrieSnapshot populates the Trie from a snapshot written by
+//SaveTrieSnapshot, then catches it up with the database
+func (store *PostgressStore) LoadTrieSnapshot(r io.Reader) error {
+	return store.LoadTrieSnapshotContext(context.Background(), r)
+}
+
+//LoadTrieSnapshotContext is LoadTrieSnapshot bounded by ctx. Rows
+//updated since the snapshot are replayed into the trie, and users
+//deleted since are removed. If this fails the Trie is left unchanged,
+//and LoadTrie can be used instead.
+func (store *PostgressStore) LoadTrieSnapshotContext(ctx context.Context, r io.Reader) error {
+	header := make([]byte, snapshotHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("Failed reading trie snapshot: %w", err)
+	}
+	if crc32.Checksum(header[:8], crcTable) != binary.BigEndian.Uint32(header[8:]) {
+		return ErrSnapshotHeader
+	}
+	since := int64(binary.BigEndian.Uint64(header))
+	from := since - int64(snapshotClockSkew)
+
+	return store.replaceTrie(func(trie *indexes.TrieNode) (int64, error) {
+		if _, err := trie.ReadFrom(r); err != nil {
+			return 0, fmt.Errorf("Failed reading trie snapshot: %w", err)
+		}
+		replayed, err := replayTrie(ctx, store.PostgressDB, trie, store.Dialect.selectTrieSince, from)
+		if err != nil {
+			return 0, err
+		}
+		if replayed > since {
+			since = replayed
+		}
+		if err := replayDeletions(ctx, store.PostgressDB, trie, store.Dialect.selectDeletedSince, from); err != nil {
+			return 0, err
+		}
+		return since, nil
+	})
+}
+
+//replayTrie indexes the users returned by a query for the trie
+//columns, replacing any keys they were indexed under before. It
+//returns the latest updated_at it read.
+func replayTrie(ctx context.Context, db *sql.DB, trie *indexes.TrieNode, query string, args ...interface{}) (int64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, queryError(ctx, "User store not ready", err)
+	}
+	defer rows.Close()
+
+	var latest int64
+	var changed []*User
+	for rows.Next() {
+		user := &User{}
+		var firstName, lastName sql.NullString
+		var rowUpdatedAt int64
+		if err := rows.Scan(&user.ID, &user.UserName, &firstName, &lastName, &rowUpdatedAt); err != nil {
+			return 0, fmt.Errorf("Failed scanning rows: %w", err)
+		}
+		user.FirstName = firstName.String
+		user.LastName = lastName.String
+		changed = append(changed, user)
+		if rowUpdatedAt > latest {
+			latest = rowUpdatedAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, queryError(ctx, "Failed scanning rows", err)
+	}
+
+	//the snapshot only has the keys, so drop every key of a changed user
+	ids := make([]int64, len(changed))
+	for idx, user := range changed {
+		ids[idx] = user.ID
+	}
+	trie.RemoveValues(ids...)
+	for _, user := range changed {
+		AddUserToTrie(user, trie)
+	}
+	return latest, nil
+}
+
+//replayDeletions removes the users deleted since the given updated_at
+//from the trie, since deleted rows leave no updated_at to replay
+func replayDeletions(ctx context.Context, db *sql.DB, trie *indexes.TrieNode, query string, since int64) error {
+	rows, err := db.QueryContext(ctx, query, since)
+	if err != nil {
+		return queryError(ctx, "User store not ready", err)
+	}
+	defer rows.Close()
+
+	var deleted []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("Failed scanning rows: %w", err)
+		}
+		deleted = append(deleted, id)
+	}
+	if err := rows.Err(); err != nil {
+		return queryError(ctx, "Failed scanning rows", err)
+	}
+	trie.RemoveValues(deleted...)
+	return nil
+}
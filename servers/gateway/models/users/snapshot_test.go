@@ -0,0 +1,118 @@
+package users
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+//trieColumns are the columns LoadTrie reads, which never include passhash
+var trieColumns = []string{"id", "user_name", "first_name", "last_name", "updated_at"}
+
+func TestTrieSnapshot(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+
+	// a full load reads only the trie columns, and remembers the
+	// latest updated_at so the snapshot can be caught up later
+	var since int64 = 1580000000000000000
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectTrieStatement)).
+		WillReturnRows(sqlmock.NewRows(trieColumns).
+			AddRow(1, "ada", "Ada", "Lovelace", since-10).
+			AddRow(2, "alan", "Alan", "Turing", since).
+			AddRow(3, "grace", nil, nil, since-20))
+	if err := store.LoadTrie(); err != nil {
+		t.Fatalf("unexpected error loading trie: %v", err)
+	}
+	var snapshot bytes.Buffer
+	if err := store.SaveTrieSnapshot(&snapshot); err != nil {
+		t.Fatalf("unexpected error saving snapshot: %v", err)
+	}
+
+	// since the snapshot ada was renamed, alan was deleted and
+	// barbara signed up
+	restarted := NewPostgressStore(db, MySQL)
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectTrieSinceStatement)).
+		WithArgs(since - int64(snapshotClockSkew)).
+		WillReturnRows(sqlmock.NewRows(trieColumns).
+			AddRow(1, "ada", "Augusta", "King", since+10).
+			AddRow(4, "barbara", "Barbara", "Liskov", since+20))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectDeletedSinceStatement)).
+		WithArgs(since - int64(snapshotClockSkew)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	if err := restarted.LoadTrieSnapshot(&snapshot); err != nil {
+		t.Fatalf("unexpected error loading snapshot: %v", err)
+	}
+
+	cases := []struct {
+		prefix   string
+		expected []int64
+	}{
+		{"lovelace", nil},
+		{"king", []int64{1}},
+		{"ada", []int64{1}},
+		{"turing", nil},
+		{"grace", []int64{3}},
+		{"liskov", []int64{4}},
+	}
+	for _, c := range cases {
+		ids, _ := restarted.FindInTrie(c.prefix, 10)
+		if len(ids) != len(c.expected) || (len(ids) > 0 && ids[0] != c.expected[0]) {
+			t.Errorf("prefix %s: expected %v but got %v", c.prefix, c.expected, ids)
+		}
+	}
+	if restarted.trieSince != since+20 {
+		t.Errorf("expected the trie to be current to %d but got %d", since+20, restarted.trieSince)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTrieSnapshotCorrupt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+	store.TrieNode.Add("kept", 1)
+
+	var snapshot bytes.Buffer
+	if err := store.SaveTrieSnapshot(&snapshot); err != nil {
+		t.Fatalf("unexpected error saving snapshot: %v", err)
+	}
+	data := snapshot.Bytes()
+	badHeader := append([]byte(nil), data...)
+	badHeader[0] ^= 0xff
+	badTrie := append([]byte(nil), data...)
+	badTrie[len(badTrie)-6] ^= 0xff
+
+	for _, corrupt := range [][]byte{badHeader, badTrie, data[:5]} {
+		restarted := NewPostgressStore(db, MySQL)
+		restarted.TrieNode.Add("before", 2)
+		if err := restarted.LoadTrieSnapshot(bytes.NewReader(corrupt)); err == nil {
+			t.Errorf("expected an error loading a corrupt snapshot")
+		}
+		if ids := restarted.TrieNode.Find("before", 10); len(ids) != 1 || ids[0] != 2 {
+			t.Errorf("expected the trie to be unchanged after a failed load but found %v", ids)
+		}
+		if ids := restarted.TrieNode.Find("kept", 10); len(ids) != 0 {
+			t.Errorf("expected nothing from the corrupt snapshot but found %v", ids)
+		}
+	}
+	if err := store.LoadTrieSnapshot(bytes.NewReader(badHeader)); !errors.Is(err, ErrSnapshotHeader) {
+		t.Errorf("expected ErrSnapshotHeader but got %v", err)
+	}
+	// nothing was replayed from a corrupt snapshot
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -1,40 +1,71 @@
 package users
 
 import (
+	"context"
 	"errors"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
 )
 
 //ErrUserNotFound is returned when the user can't be found
 var ErrUserNotFound = errors.New("user not found")
 
-//Store represents a store for Users
+//ErrEmailTaken is returned by Insert when another user has the same email
+var ErrEmailTaken = errors.New("email already in use")
+
+//ErrUserNameTaken is returned by Insert when another user has the same username
+var ErrUserNameTaken = errors.New("username already in use")
+
+//Store represents a store for Users. Every method that reaches the
+//database has a Context variant that stops waiting once the context
+//is done, such as when the HTTP client goes away.
 type Store interface {
 	//FindInTrie returns relevant user IDs with names starts with prefix
 	FindInTrie(prefix string, max int) ([]int64, error)
 
+	//FindRankedInTrie returns the most relevant user IDs with names
+	//starting with prefix, best first, with their scores
+	FindRankedInTrie(prefix string, max int) ([]indexes.Result, error)
+
+	//FindFuzzyInTrie returns relevant user IDs with names starting with
+	//something within maxEdits typos of prefix, closest first
+	FindFuzzyInTrie(prefix string, maxEdits int, max int) ([]int64, error)
+
+	//FindAfterInTrie returns the next max user IDs with names starting
+	//with prefix after the given entry, in name order, for paging
+	FindAfterInTrie(prefix string, afterKey string, afterID int64, max int) ([]indexes.Entry, error)
+
 	//LoadTrie populates Trie with existing user accounts
 	LoadTrie() error
+	LoadTrieContext(ctx context.Context) error
 
 	//GetByID returns the User with the given ID
 	GetByID(id int64) (*User, error)
+	GetByIDContext(ctx context.Context, id int64) (*User, error)
 
 	//GetByIDs returns array of User struct  the given IDs
 	GetByIDs(ids []int64) ([]*User, error)
+	GetByIDsContext(ctx context.Context, ids []int64) ([]*User, error)
 
 	//GetByEmail returns the User with the given email
 	GetByEmail(email string) (*User, error)
+	GetByEmailContext(ctx context.Context, email string) (*User, error)
 
 	//GetByUserName returns the User with the given Username
 	GetByUserName(username string) (*User, error)
+	GetByUserNameContext(ctx context.Context, username string) (*User, error)
 
 	//Insert inserts the user into the database, and returns
 	//the newly-inserted User, complete with the DBMS-assigned ID
 	Insert(user *User) (*User, error)
+	InsertContext(ctx context.Context, user *User) (*User, error)
 
 	//Update applies UserUpdates to the given user ID
 	//and returns the newly-updated user
 	Update(id int64, updates *Updates) (*User, error)
+	UpdateContext(ctx context.Context, id int64, updates *Updates) (*User, error)
 
 	//Delete deletes the user with the given ID
 	Delete(id int64) error
+	DeleteContext(ctx context.Context, id int64) error
 }
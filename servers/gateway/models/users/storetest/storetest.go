@@ -0,0 +1,390 @@
+//Package storetest is a contract test suite shared by every
+//users.Store implementation.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//NewStoreFunc returns an empty store with its trie loaded
+type NewStoreFunc func(t *testing.T) users.Store
+
+//Run runs the users.Store contract against stores created by newStore.
+//Every subtest gets its own store.
+func Run(t *testing.T, newStore NewStoreFunc) {
+	t.Run("InsertAssignsIDs", func(t *testing.T) { testInsertAssignsIDs(t, newStore(t)) })
+	t.Run("GetByID", func(t *testing.T) { testGetByID(t, newStore(t)) })
+	t.Run("GetByIDs", func(t *testing.T) { testGetByIDs(t, newStore(t)) })
+	t.Run("GetByEmail", func(t *testing.T) { testGetByEmail(t, newStore(t)) })
+	t.Run("GetByUserName", func(t *testing.T) { testGetByUserName(t, newStore(t)) })
+	t.Run("UniqueEmail", func(t *testing.T) { testUniqueEmail(t, newStore(t)) })
+	t.Run("UniqueUserName", func(t *testing.T) { testUniqueUserName(t, newStore(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, newStore(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStore(t)) })
+	t.Run("FindInTrie", func(t *testing.T) { testFindInTrie(t, newStore(t)) })
+	t.Run("FindRankedInTrie", func(t *testing.T) { testFindRankedInTrie(t, newStore(t)) })
+	t.Run("FindAfterInTrie", func(t *testing.T) { testFindAfterInTrie(t, newStore(t)) })
+	t.Run("FindFuzzyInTrie", func(t *testing.T) { testFindFuzzyInTrie(t, newStore(t)) })
+	t.Run("LoadTrie", func(t *testing.T) { testLoadTrie(t, newStore(t)) })
+	t.Run("CanceledContext", func(t *testing.T) { testCanceledContext(t, newStore(t)) })
+}
+
+//passHash is a bcrypt hash shared by every test user, since hashing
+//at the default cost for each insert would make the suite slow
+var passHash, _ = bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+//newTestUser returns a user that hasn't been inserted yet
+func newTestUser(userName, firstName, lastName string) *users.User {
+	return &users.User{
+		Email:     userName + "@uw.edu",
+		PassHash:  passHash,
+		UserName:  userName,
+		FirstName: firstName,
+		LastName:  lastName,
+		PhotoURL:  "https://www.gravatar.com/avatar/" + userName,
+	}
+}
+
+//mustInsert inserts a test user and fails the test on error
+func mustInsert(t *testing.T, store users.Store, userName, firstName, lastName string) *users.User {
+	t.Helper()
+	inserted, err := store.Insert(newTestUser(userName, firstName, lastName))
+	if err != nil {
+		t.Fatalf("unexpected error inserting %s: %v", userName, err)
+	}
+	if inserted == nil {
+		t.Fatalf("nil user returned from insert of %s", userName)
+	}
+	return inserted
+}
+
+//checkUser fails the test if got doesn't match want
+func checkUser(t *testing.T, got *users.User, want *users.User) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("expected user %d but got nil", want.ID)
+	}
+	if got.ID != want.ID || got.Email != want.Email || got.UserName != want.UserName ||
+		got.FirstName != want.FirstName || got.LastName != want.LastName || got.PhotoURL != want.PhotoURL {
+		t.Errorf("incorrect user: expected %+v but got %+v", want, got)
+	}
+	if string(got.PassHash) != string(want.PassHash) {
+		t.Errorf("incorrect PassHash for user %d", want.ID)
+	}
+}
+
+func testInsertAssignsIDs(t *testing.T, store users.Store) {
+	seen := make(map[int64]bool)
+	for i := 0; i < 3; i++ {
+		user := mustInsert(t, store, fmt.Sprintf("user%d", i), "First", "Last")
+		if user.ID <= 0 {
+			t.Errorf("expected positive ID but got %d", user.ID)
+		}
+		if seen[user.ID] {
+			t.Errorf("ID %d assigned twice", user.ID)
+		}
+		seen[user.ID] = true
+	}
+}
+
+func testUniqueEmail(t *testing.T, store users.Store) {
+	ada := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	duplicate := newTestUser("augusta", "Augusta", "King")
+	duplicate.Email = ada.Email
+	if _, err := store.Insert(duplicate); err != users.ErrEmailTaken {
+		t.Errorf("expected ErrEmailTaken but got %v", err)
+	}
+	//the SQL stores' unique indexes ignore case
+	duplicate.Email = strings.ToUpper(ada.Email)
+	if _, err := store.Insert(duplicate); err != users.ErrEmailTaken {
+		t.Errorf("expected ErrEmailTaken for an email differing in case but got %v", err)
+	}
+	if ids, _ := store.FindInTrie("augusta", 10); len(ids) != 0 {
+		t.Errorf("rejected user added to trie: %v", ids)
+	}
+}
+
+func testUniqueUserName(t *testing.T, store users.Store) {
+	mustInsert(t, store, "ada", "Ada", "Lovelace")
+	duplicate := newTestUser("ada", "Augusta", "King")
+	duplicate.Email = "augusta@uw.edu"
+	if _, err := store.Insert(duplicate); err != users.ErrUserNameTaken {
+		t.Errorf("expected ErrUserNameTaken but got %v", err)
+	}
+	duplicate.UserName = "AdA"
+	if _, err := store.Insert(duplicate); err != users.ErrUserNameTaken {
+		t.Errorf("expected ErrUserNameTaken for a username differing in case but got %v", err)
+	}
+	if ids, _ := store.FindInTrie("augusta", 10); len(ids) != 0 {
+		t.Errorf("rejected user added to trie: %v", ids)
+	}
+}
+
+func testGetByID(t *testing.T, store users.Store) {
+	inserted := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	found, err := store.GetByID(inserted.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkUser(t, found, inserted)
+
+	if _, err := store.GetByID(inserted.ID + 1000); err == nil {
+		t.Errorf("expected error getting a missing ID")
+	}
+}
+
+func testGetByIDs(t *testing.T, store users.Store) {
+	ada := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	alan := mustInsert(t, store, "alan", "Alan", "Turing")
+	grace := mustInsert(t, store, "grace", "Grace", "Hopper")
+
+	found, err := store.GetByIDs([]int64{grace.ID, grace.ID + 1000, ada.ID, alan.ID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []*users.User{grace, ada, alan}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %d users but got %d", len(expected), len(found))
+	}
+	for idx, user := range expected {
+		checkUser(t, found[idx], user)
+	}
+
+	if _, err := store.GetByIDs(nil); err == nil {
+		t.Errorf("expected error when no IDs are provided")
+	}
+}
+
+func testGetByEmail(t *testing.T, store users.Store) {
+	inserted := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	found, err := store.GetByEmail(inserted.Email)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkUser(t, found, inserted)
+
+	if _, err := store.GetByEmail("missing@uw.edu"); err == nil {
+		t.Errorf("expected error getting a missing email")
+	}
+}
+
+func testGetByUserName(t *testing.T, store users.Store) {
+	inserted := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	found, err := store.GetByUserName(inserted.UserName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkUser(t, found, inserted)
+
+	if _, err := store.GetByUserName("missing"); err == nil {
+		t.Errorf("expected error getting a missing username")
+	}
+}
+
+func testUpdate(t *testing.T, store users.Store) {
+	inserted := mustInsert(t, store, "ada", "Ada", "Byron")
+	updated, err := store.Update(inserted.ID, &users.Updates{FirstName: "Augusta", LastName: "Lovelace"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.FirstName != "Augusta" || updated.LastName != "Lovelace" {
+		t.Errorf("names not updated: got %s %s", updated.FirstName, updated.LastName)
+	}
+	found, err := store.GetByID(inserted.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if found.FirstName != "Augusta" || found.LastName != "Lovelace" {
+		t.Errorf("update not persisted: got %s %s", found.FirstName, found.LastName)
+	}
+
+	//the trie follows the new names
+	if ids, _ := store.FindInTrie("byron", 10); len(ids) != 0 {
+		t.Errorf("old last name still in trie: %v", ids)
+	}
+	if ids, _ := store.FindInTrie("love", 10); len(ids) != 1 || ids[0] != inserted.ID {
+		t.Errorf("new last name not in trie: %v", ids)
+	}
+
+	if _, err := store.Update(inserted.ID+1000, &users.Updates{FirstName: "a", LastName: "b"}); err == nil {
+		t.Errorf("expected error updating a missing ID")
+	}
+}
+
+func testDelete(t *testing.T, store users.Store) {
+	inserted := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	if err := store.Delete(inserted.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := store.GetByID(inserted.ID); err == nil {
+		t.Errorf("expected error getting a deleted user")
+	}
+	if ids, _ := store.FindInTrie("ada", 10); len(ids) != 0 {
+		t.Errorf("deleted user still in trie: %v", ids)
+	}
+	if err := store.Delete(inserted.ID); err == nil {
+		t.Errorf("expected error deleting a missing user")
+	}
+}
+
+func testFindInTrie(t *testing.T, store users.Store) {
+	ada := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	alan := mustInsert(t, store, "alan", "Alan", "Turing")
+
+	cases := []struct {
+		prefix   string
+		expected []int64
+	}{
+		{"ada", []int64{ada.ID}},
+		{"lov", []int64{ada.ID}},
+		{"tur", []int64{alan.ID}},
+		{"a", []int64{ada.ID, alan.ID}},
+		{"z", nil},
+	}
+	for _, c := range cases {
+		ids, err := store.FindInTrie(c.prefix, 10)
+		if err != nil {
+			t.Fatalf("prefix %s: unexpected error: %v", c.prefix, err)
+		}
+		if len(ids) != len(c.expected) {
+			t.Errorf("prefix %s: expected %v but got %v", c.prefix, c.expected, ids)
+			continue
+		}
+		for idx, id := range c.expected {
+			if ids[idx] != id {
+				t.Errorf("prefix %s: expected %v but got %v", c.prefix, c.expected, ids)
+				break
+			}
+		}
+	}
+}
+
+func testFindRankedInTrie(t *testing.T, store users.Store) {
+	adam := mustInsert(t, store, "adam", "Adam", "Smith")
+	ada := mustInsert(t, store, "lovelace", "Ada", "Byron")
+	adams := mustInsert(t, store, "jq", "John", "Adams")
+
+	results, err := store.FindRankedInTrie("ada", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	//exact first name, then username prefix, then last name prefix
+	expected := []int64{ada.ID, adam.ID, adams.ID}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %v but got %+v", expected, results)
+	}
+	for idx, id := range expected {
+		if results[idx].Value != id {
+			t.Errorf("expected %v but got %+v", expected, results)
+			break
+		}
+	}
+	if results[1].Fields&users.UserNameField == 0 {
+		t.Errorf("expected a username match but got fields %b", results[1].Fields)
+	}
+
+	//every word must match a name of the same user
+	results, _ = store.FindRankedInTrie("ada byr", 10)
+	if len(results) != 1 || results[0].Value != ada.ID {
+		t.Errorf("expected only %d but got %+v", ada.ID, results)
+	}
+}
+
+func testFindAfterInTrie(t *testing.T, store users.Store) {
+	ada := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	alan := mustInsert(t, store, "alan", "Alan", "Turing")
+	anita := mustInsert(t, store, "anita", "Anita", "Borg")
+
+	//pages of two, in name order, each user once
+	var pages [][]int64
+	afterKey, afterID := "", int64(0)
+	for {
+		entries, err := store.FindAfterInTrie("a", afterKey, afterID, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		var page []int64
+		for _, entry := range entries {
+			page = append(page, entry.Value)
+		}
+		pages = append(pages, page)
+		last := entries[len(entries)-1]
+		afterKey, afterID = last.Key, last.Value
+	}
+	if len(pages) != 2 || len(pages[0]) != 2 || pages[0][0] != ada.ID ||
+		pages[0][1] != alan.ID || len(pages[1]) != 1 || pages[1][0] != anita.ID {
+		t.Errorf("expected pages [[%d %d] [%d]] but got %v", ada.ID, alan.ID, anita.ID, pages)
+	}
+}
+
+func testFindFuzzyInTrie(t *testing.T, store users.Store) {
+	ada := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	alan := mustInsert(t, store, "alan", "Alan", "Turing")
+
+	ids, err := store.FindFuzzyInTrie("turnig", 2, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != alan.ID {
+		t.Errorf("expected [%d] but got %v", alan.ID, ids)
+	}
+	// "ada" is an exact match and "alan" is one edit away
+	ids, _ = store.FindFuzzyInTrie("ada", 2, 10)
+	if len(ids) != 2 || ids[0] != ada.ID || ids[1] != alan.ID {
+		t.Errorf("expected [%d %d] but got %v", ada.ID, alan.ID, ids)
+	}
+}
+
+func testLoadTrie(t *testing.T, store users.Store) {
+	ada := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	if err := store.LoadTrie(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ids, _ := store.FindInTrie("lovelace", 10); len(ids) != 1 || ids[0] != ada.ID {
+		t.Errorf("expected reloaded trie to contain %d but got %v", ada.ID, ids)
+	}
+}
+
+func testCanceledContext(t *testing.T, store users.Store) {
+	ada := mustInsert(t, store, "ada", "Ada", "Lovelace")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := store.GetByIDContext(ctx, ada.ID); err == nil {
+		t.Errorf("expected GetByIDContext to fail with a canceled context")
+	}
+	if _, err := store.GetByIDsContext(ctx, []int64{ada.ID}); err == nil {
+		t.Errorf("expected GetByIDsContext to fail with a canceled context")
+	}
+	if _, err := store.GetByEmailContext(ctx, ada.Email); err == nil {
+		t.Errorf("expected GetByEmailContext to fail with a canceled context")
+	}
+	if _, err := store.InsertContext(ctx, newTestUser("alan", "Alan", "Turing")); err == nil {
+		t.Errorf("expected InsertContext to fail with a canceled context")
+	}
+	if _, err := store.UpdateContext(ctx, ada.ID, &users.Updates{FirstName: "a", LastName: "b"}); err == nil {
+		t.Errorf("expected UpdateContext to fail with a canceled context")
+	}
+	if err := store.DeleteContext(ctx, ada.ID); err == nil {
+		t.Errorf("expected DeleteContext to fail with a canceled context")
+	}
+
+	//nothing changed, and a live context still works
+	found, err := store.GetByIDContext(context.Background(), ada.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	checkUser(t, found, ada)
+	if ids, _ := store.FindInTrie("alan", 10); len(ids) != 0 {
+		t.Errorf("user inserted with a canceled context is in the trie: %v", ids)
+	}
+}
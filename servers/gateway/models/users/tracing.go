@@ -0,0 +1,188 @@
+package users
+
+import (
+	"context"
+	"errors"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//tracerName names the tracer of user store calls
+const tracerName = "github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+
+//TracedStore is a Store that records a span for every call to the
+//Store it wraps. Calls without a context start a new trace.
+type TracedStore struct {
+	Store Store
+}
+
+var _ Store = (*TracedStore)(nil)
+
+//NewTracedStore constructs a new TracedStore wrapping the given Store
+func NewTracedStore(store Store) *TracedStore {
+	return &TracedStore{Store: store}
+}
+
+//start starts the span of a call to the named Store method
+func (ts *TracedStore) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "users."+method, trace.WithAttributes(attrs...))
+}
+
+//end ends the span of a call, marking it failed if err is a failure.
+//A user that isn't found is an answer, not a failure.
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+//FindInTrie finds the ids of up to max users whose names start with prefix
+func (ts *TracedStore) FindInTrie(prefix string, max int) ([]int64, error) {
+	_, span := ts.start(context.Background(), "FindInTrie", attribute.Int("users.max", max))
+	ids, err := ts.Store.FindInTrie(prefix, max)
+	span.SetAttributes(attribute.Int("users.count", len(ids)))
+	end(span, err)
+	return ids, err
+}
+
+//FindRankedInTrie finds the most relevant users whose names start with prefix
+func (ts *TracedStore) FindRankedInTrie(prefix string, max int) ([]indexes.Result, error) {
+	_, span := ts.start(context.Background(), "FindRankedInTrie", attribute.Int("users.max", max))
+	results, err := ts.Store.FindRankedInTrie(prefix, max)
+	span.SetAttributes(attribute.Int("users.count", len(results)))
+	end(span, err)
+	return results, err
+}
+
+//FindFuzzyInTrie finds users whose names are within maxEdits of prefix
+func (ts *TracedStore) FindFuzzyInTrie(prefix string, maxEdits int, max int) ([]int64, error) {
+	_, span := ts.start(context.Background(), "FindFuzzyInTrie", attribute.Int("users.max", max))
+	ids, err := ts.Store.FindFuzzyInTrie(prefix, maxEdits, max)
+	span.SetAttributes(attribute.Int("users.count", len(ids)))
+	end(span, err)
+	return ids, err
+}
+
+//FindAfterInTrie finds the page of users after the given entry
+func (ts *TracedStore) FindAfterInTrie(prefix string, afterKey string, afterID int64, max int) ([]indexes.Entry, error) {
+	_, span := ts.start(context.Background(), "FindAfterInTrie", attribute.Int("users.max", max))
+	entries, err := ts.Store.FindAfterInTrie(prefix, afterKey, afterID, max)
+	span.SetAttributes(attribute.Int("users.count", len(entries)))
+	end(span, err)
+	return entries, err
+}
+
+//LoadTrie populates the trie with existing users
+func (ts *TracedStore) LoadTrie() error {
+	return ts.LoadTrieContext(context.Background())
+}
+
+//LoadTrieContext is LoadTrie bounded by ctx
+func (ts *TracedStore) LoadTrieContext(ctx context.Context) error {
+	ctx, span := ts.start(ctx, "LoadTrie")
+	err := ts.Store.LoadTrieContext(ctx)
+	end(span, err)
+	return err
+}
+
+//GetByID gets the user with the given id
+func (ts *TracedStore) GetByID(id int64) (*User, error) {
+	return ts.GetByIDContext(context.Background(), id)
+}
+
+//GetByIDContext is GetByID bounded by ctx
+func (ts *TracedStore) GetByIDContext(ctx context.Context, id int64) (*User, error) {
+	ctx, span := ts.start(ctx, "GetByID", attribute.Int64("user.id", id))
+	user, err := ts.Store.GetByIDContext(ctx, id)
+	end(span, err)
+	return user, err
+}
+
+//GetByIDs gets the users with the given ids
+func (ts *TracedStore) GetByIDs(ids []int64) ([]*User, error) {
+	return ts.GetByIDsContext(context.Background(), ids)
+}
+
+//GetByIDsContext is GetByIDs bounded by ctx
+func (ts *TracedStore) GetByIDsContext(ctx context.Context, ids []int64) ([]*User, error) {
+	ctx, span := ts.start(ctx, "GetByIDs", attribute.Int("users.requested", len(ids)))
+	users, err := ts.Store.GetByIDsContext(ctx, ids)
+	span.SetAttributes(attribute.Int("users.count", len(users)))
+	end(span, err)
+	return users, err
+}
+
+//GetByEmail gets the user with the given email
+func (ts *TracedStore) GetByEmail(email string) (*User, error) {
+	return ts.GetByEmailContext(context.Background(), email)
+}
+
+//GetByEmailContext is GetByEmail bounded by ctx. The email isn't
+//recorded, since traces aren't kept as carefully as the database.
+func (ts *TracedStore) GetByEmailContext(ctx context.Context, email string) (*User, error) {
+	ctx, span := ts.start(ctx, "GetByEmail")
+	user, err := ts.Store.GetByEmailContext(ctx, email)
+	end(span, err)
+	return user, err
+}
+
+//GetByUserName gets the user with the given user name
+func (ts *TracedStore) GetByUserName(username string) (*User, error) {
+	return ts.GetByUserNameContext(context.Background(), username)
+}
+
+//GetByUserNameContext is GetByUserName bounded by ctx
+func (ts *TracedStore) GetByUserNameContext(ctx context.Context, username string) (*User, error) {
+	ctx, span := ts.start(ctx, "GetByUserName")
+	user, err := ts.Store.GetByUserNameContext(ctx, username)
+	end(span, err)
+	return user, err
+}
+
+//Insert inserts the user
+func (ts *TracedStore) Insert(user *User) (*User, error) {
+	return ts.InsertContext(context.Background(), user)
+}
+
+//InsertContext is Insert bounded by ctx
+func (ts *TracedStore) InsertContext(ctx context.Context, user *User) (*User, error) {
+	ctx, span := ts.start(ctx, "Insert")
+	inserted, err := ts.Store.InsertContext(ctx, user)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("user.id", inserted.ID))
+	}
+	end(span, err)
+	return inserted, err
+}
+
+//Update applies the updates to the user with the given id
+func (ts *TracedStore) Update(id int64, updates *Updates) (*User, error) {
+	return ts.UpdateContext(context.Background(), id, updates)
+}
+
+//UpdateContext is Update bounded by ctx
+func (ts *TracedStore) UpdateContext(ctx context.Context, id int64, updates *Updates) (*User, error) {
+	ctx, span := ts.start(ctx, "Update", attribute.Int64("user.id", id))
+	user, err := ts.Store.UpdateContext(ctx, id, updates)
+	end(span, err)
+	return user, err
+}
+
+//Delete deletes the user with the given id
+func (ts *TracedStore) Delete(id int64) error {
+	return ts.DeleteContext(context.Background(), id)
+}
+
+//DeleteContext is Delete bounded by ctx
+func (ts *TracedStore) DeleteContext(ctx context.Context, id int64) error {
+	ctx, span := ts.start(ctx, "Delete", attribute.Int64("user.id", id))
+	err := ts.Store.DeleteContext(ctx, id)
+	end(span, err)
+	return err
+}
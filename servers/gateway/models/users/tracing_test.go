@@ -0,0 +1,73 @@
+package users_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users/storetest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+//recordSpans records the spans ended during the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestTracedStoreContract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) users.Store {
+		return users.NewTracedStore(users.NewMemStore())
+	})
+}
+
+func TestTracedStoreSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	store := users.NewTracedStore(users.NewMemStore())
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+
+	inserted, err := store.InsertContext(ctx, &users.User{Email: "ada@uw.edu", UserName: "ada"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store.GetByIDContext(ctx, inserted.ID)
+	store.GetByIDContext(ctx, inserted.ID+1)
+	store.InsertContext(ctx, &users.User{Email: "ada@uw.edu", UserName: "ada"})
+	store.FindInTrie("ad", 10)
+	parent.End()
+
+	cases := []struct {
+		name      string
+		span      string
+		failed    bool
+		withTrace bool
+	}{
+		{"Insert", "users.Insert", false, true},
+		{"Get", "users.GetByID", false, true},
+		{"Get Missing User", "users.GetByID", false, true},
+		{"Insert Taken Email", "users.Insert", true, true},
+		{"Call Without Context", "users.FindInTrie", false, false},
+	}
+	spans := recorder.Ended()
+	if len(spans) != len(cases)+1 {
+		t.Fatalf("expected %d spans but got %d", len(cases)+1, len(spans))
+	}
+	for idx, c := range cases {
+		span := spans[idx]
+		if span.Name() != c.span {
+			t.Errorf("case %s: expected span %s but got %s", c.name, c.span, span.Name())
+		}
+		if failed := span.Status().Code == codes.Error; failed != c.failed {
+			t.Errorf("case %s: expected the span to have failed: %t, but got %v", c.name, c.failed, span.Status())
+		}
+		if inTrace := span.Parent().SpanID() == parent.SpanContext().SpanID(); inTrace != c.withTrace {
+			t.Errorf("case %s: expected the span to be in the request's trace: %t", c.name, c.withTrace)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package users
+
+import (
+	"strings"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
+)
+
+//The user fields that trie keys are taken from
+const (
+	FirstNameField indexes.Field = 1 << iota
+	LastNameField
+	UserNameField
+)
+
+//DefaultRanking returns the search ranking used by the stores: exact
+//matches first, then usernames, then first and last names, with
+//shorter completions first within each
+func DefaultRanking() *indexes.RankOptions {
+	return &indexes.RankOptions{
+		ExactWeight: 4,
+		FieldWeights: map[indexes.Field]float64{
+			UserNameField:  2,
+			FirstNameField: 1,
+			LastNameField:  1,
+		},
+	}
+}
+
+//trieKey is a key a user is indexed under and the field it came from
+type trieKey struct {
+	key   string
+	field indexes.Field
+}
+
+//trieKeys returns the words of the user's first name, last name and
+//username, which are the keys the user is indexed under. The trie
+//normalizes case and accents itself.
+func trieKeys(user *User) []trieKey {
+	var keys []trieKey
+	names := []struct {
+		name  string
+		field indexes.Field
+	}{
+		{user.FirstName, FirstNameField},
+		{user.LastName, LastNameField},
+		{user.UserName, UserNameField},
+	}
+	for _, n := range names {
+		//Split string on white-space
+		for _, word := range strings.Fields(n.name) {
+			keys = append(keys, trieKey{word, n.field})
+		}
+	}
+	return keys
+}
+
+//AddUserToTrie inserts username, lastname, firstname of the given user into Trie
+func AddUserToTrie(user *User, trie *indexes.TrieNode) {
+	for _, k := range trieKeys(user) {
+		trie.AddField(k.key, user.ID, k.field)
+	}
+}
+
+//RemoveUserFromTrie removes every key AddUserToTrie added for the given user
+func RemoveUserFromTrie(user *User, trie *indexes.TrieNode) {
+	for _, k := range trieKeys(user) {
+		trie.Remove(k.key, user.ID)
+	}
+}
@@ -0,0 +1,229 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/indexes"
+)
+
+//TrieEvent describes a change one gateway replica made to its trie, so
+//the other replicas can make the same change to theirs. It carries the
+//user's names rather than the change, so applying it twice is harmless.
+type TrieEvent struct {
+	//Origin is the ReplicaID of the store that made the change
+	Origin    string `json:"origin"`
+	ID        int64  `json:"id"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	UserName  string `json:"userName,omitempty"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	//UpdatedAt is the user's updated_at after the change, which
+	//orders events for the same user that arrive out of order
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+//TriePublisher sends trie events to the other gateway replicas
+type TriePublisher interface {
+	PublishTrieEvent(event *TrieEvent) error
+}
+
+//newReplicaID returns a random ID for a store's events
+func newReplicaID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("error generating replica ID: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+//trieVersions remembers the latest updated_at applied to the trie
+//for each user, so stale events can be ignored
+type trieVersions struct {
+	mx       sync.Mutex
+	versions map[int64]int64
+}
+
+func newTrieVersions() *trieVersions {
+	return &trieVersions{versions: make(map[int64]int64)}
+}
+
+//advance records updatedAt for the user and returns true, unless
+//a newer change to the user was already applied
+func (tv *trieVersions) advance(id int64, updatedAt int64) bool {
+	tv.mx.Lock()
+	defer tv.mx.Unlock()
+	if updatedAt < tv.versions[id] {
+		return false
+	}
+	tv.versions[id] = updatedAt
+	return true
+}
+
+//forget drops versions older than before, which a full reload has
+//superseded, so the map doesn't grow with every user ever changed
+func (tv *trieVersions) forget(before int64) {
+	tv.mx.Lock()
+	defer tv.mx.Unlock()
+	for id, version := range tv.versions {
+		if version < before {
+			delete(tv.versions, id)
+		}
+	}
+}
+
+//publishUser tells the other replicas about the user's current names
+func (store *PostgressStore) publishUser(user *User, updatedAt int64) {
+	store.publish(&TrieEvent{
+		ID:        user.ID,
+		UserName:  user.UserName,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		UpdatedAt: updatedAt,
+	})
+}
+
+//publish sends the event if the store has a Publisher. The database
+//write already succeeded, so a failure is only logged; the other
+//replicas catch up at their next ReconcileTrie.
+func (store *PostgressStore) publish(event *TrieEvent) {
+	store.versions.advance(event.ID, event.UpdatedAt)
+	store.advanceTrieSince(event.UpdatedAt)
+	if store.Publisher == nil {
+		return
+	}
+	event.Origin = store.ReplicaID
+	if err := store.Publisher.PublishTrieEvent(event); err != nil {
+		slog.Warn("Failed to publish trie event", "user_id", event.ID, "error", err)
+	}
+}
+
+//ApplyTrieEvent makes a change published by another replica to this
+//store's trie. Events from this store, and events older than a change
+//already applied to the same user, are ignored.
+func (store *PostgressStore) ApplyTrieEvent(event *TrieEvent) {
+	if event.Origin == store.ReplicaID {
+		return
+	}
+	if !store.versions.advance(event.ID, event.UpdatedAt) {
+		return
+	}
+	store.changeTrie(func(trie *indexes.TrieNode) {
+		trie.RemoveValues(event.ID)
+		if !event.Deleted {
+			AddUserToTrie(&User{
+				ID:        event.ID,
+				UserName:  event.UserName,
+				FirstName: event.FirstName,
+				LastName:  event.LastName,
+			}, trie)
+		}
+	})
+	store.advanceTrieSince(event.UpdatedAt)
+}
+
+//advanceTrieSince moves trieSince up to updatedAt, once a change made
+//then is in the trie, so the next snapshot doesn't replay it again.
+//Changes made a little earlier by other replicas may still be on
+//their way, but loading a snapshot replays rows from snapshotClockSkew
+//before trieSince, and reconciling repairs anything older.
+func (store *PostgressStore) advanceTrieSince(updatedAt int64) {
+	for {
+		since := atomic.LoadInt64(&store.trieSince)
+		if updatedAt <= since || atomic.CompareAndSwapInt64(&store.trieSince, since, updatedAt) {
+			return
+		}
+	}
+}
+
+//changeTrie makes a change to the Trie. While a new trie is being
+//built the change is kept, to be made to the new trie as well.
+func (store *PostgressStore) changeTrie(change func(trie *indexes.TrieNode)) {
+	store.trieMx.Lock()
+	defer store.trieMx.Unlock()
+	change(store.TrieNode)
+	if store.pending != nil {
+		store.pending = append(store.pending, change)
+	}
+}
+
+//replaceTrie builds a new trie with build, which returns the latest
+//updated_at it read, and replaces the Trie with it. Changes made to
+//the Trie while build runs are made to the new trie before it's
+//swapped in, so events arriving after build read the database aren't
+//lost. If build fails the Trie is left unchanged.
+func (store *PostgressStore) replaceTrie(build func(trie *indexes.TrieNode) (int64, error)) error {
+	store.rebuildMx.Lock()
+	defer store.rebuildMx.Unlock()
+
+	store.trieMx.Lock()
+	store.pending = []func(trie *indexes.TrieNode){}
+	store.trieMx.Unlock()
+
+	trie := indexes.NewTrieNode()
+	since, err := build(trie)
+
+	store.trieMx.Lock()
+	defer store.trieMx.Unlock()
+	pending := store.pending
+	store.pending = nil
+	if err != nil {
+		return err
+	}
+	for _, change := range pending {
+		change(trie)
+	}
+	store.TrieNode.Replace(trie)
+	store.advanceTrieSince(since)
+	return nil
+}
+
+//ReconcileTrie rebuilds the Trie from the database, repairing any
+//events this replica missed
+func (store *PostgressStore) ReconcileTrie() error {
+	return store.ReconcileTrieContext(context.Background())
+}
+
+//ReconcileTrieContext is ReconcileTrie bounded by ctx. Rows changed
+//and users deleted while the table was being scanned, and events
+//applied meanwhile, are caught up before the new trie replaces the
+//old one.
+func (store *PostgressStore) ReconcileTrieContext(ctx context.Context) error {
+	start := time.Now()
+	err := store.reconcileTrie(ctx)
+	observeQuery("reconcile_trie", start, err)
+	return err
+}
+
+//reconcileTrie rebuilds the trie for ReconcileTrieContext
+func (store *PostgressStore) reconcileTrie(ctx context.Context) error {
+	from := updatedAt() - int64(snapshotClockSkew)
+	err := store.replaceTrie(func(trie *indexes.TrieNode) (int64, error) {
+		since, err := replayTrie(ctx, store.PostgressDB, trie, store.Dialect.selectTrie)
+		if err != nil {
+			return 0, err
+		}
+		replayed, err := replayTrie(ctx, store.PostgressDB, trie, store.Dialect.selectTrieSince, from)
+		if err != nil {
+			return 0, err
+		}
+		if replayed > since {
+			since = replayed
+		}
+		if err := replayDeletions(ctx, store.PostgressDB, trie, store.Dialect.selectDeletedSince, from); err != nil {
+			return 0, err
+		}
+		return since, nil
+	})
+	if err != nil {
+		return err
+	}
+	store.versions.forget(from)
+	return nil
+}
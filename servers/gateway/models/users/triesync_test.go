@@ -0,0 +1,251 @@
+package users
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+//fanout delivers every published event to every store, like the
+//RabbitMQ fanout exchange the gateway uses
+type fanout struct {
+	stores []*PostgressStore
+	events []*TrieEvent
+}
+
+func (f *fanout) PublishTrieEvent(event *TrieEvent) error {
+	f.events = append(f.events, event)
+	for _, store := range f.stores {
+		store.ApplyTrieEvent(event)
+	}
+	return nil
+}
+
+func TestTrieSync(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	writer := NewPostgressStore(db, MySQL)
+	replica := NewPostgressStore(db, MySQL)
+	bus := &fanout{stores: []*PostgressStore{writer, replica}}
+	writer.Publisher = bus
+	replica.Publisher = bus
+
+	find := func(store *PostgressStore, prefix string) []int64 {
+		ids, _ := store.FindInTrie(prefix, 10)
+		return ids
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta(sqlInsertStatement)).
+		WithArgs("ada@uw.edu", []byte{1}, "ada", "Ada", "Lovelace", "photo", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if _, err := writer.Insert(&User{Email: "ada@uw.edu", PassHash: []byte{1},
+		UserName: "ada", FirstName: "Ada", LastName: "Lovelace", PhotoURL: "photo"}); err != nil {
+		t.Fatalf("unexpected error inserting: %v", err)
+	}
+	if ids := find(replica, "lovelace"); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("expected the replica to find the new user but got %v", ids)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetByIDStatement)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(1, "ada@uw.edu", []byte{1}, "ada", "Ada", "Lovelace", "photo"))
+	mock.ExpectExec(regexp.QuoteMeta(sqlUpdateStatement)).
+		WithArgs("Augusta", "King", sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetByIDStatement)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(1, "ada@uw.edu", []byte{1}, "ada", "Augusta", "King", "photo"))
+	if _, err := writer.Update(1, &Updates{FirstName: "Augusta", LastName: "King"}); err != nil {
+		t.Fatalf("unexpected error updating: %v", err)
+	}
+	if ids := find(replica, "lovelace"); len(ids) != 0 {
+		t.Errorf("expected the replica to forget the old name but got %v", ids)
+	}
+	if ids := find(replica, "augusta"); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("expected the replica to find the new name but got %v", ids)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(sqlGetByIDStatement)).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(userColumns).
+			AddRow(1, "ada@uw.edu", []byte{1}, "ada", "Augusta", "King", "photo"))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(sqlDeleteStatement)).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(sqlInsertDeletedStatement)).
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := writer.Delete(1); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if ids := find(replica, "ada"); len(ids) != 0 {
+		t.Errorf("expected the replica to forget the deleted user but got %v", ids)
+	}
+
+	if len(bus.events) != 3 {
+		t.Fatalf("expected 3 events but got %d", len(bus.events))
+	}
+	for _, event := range bus.events {
+		if event.Origin != writer.ReplicaID {
+			t.Errorf("expected origin %s but got %s", writer.ReplicaID, event.Origin)
+		}
+	}
+	if !bus.events[2].Deleted {
+		t.Errorf("expected the last event to be a delete")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestApplyTrieEvent(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+	find := func(prefix string) []int64 {
+		ids, _ := store.FindInTrie(prefix, 10)
+		return ids
+	}
+
+	store.ApplyTrieEvent(&TrieEvent{Origin: "other", ID: 1, UserName: "ada",
+		FirstName: "Augusta", LastName: "King", UpdatedAt: 20})
+	// an older rename arriving late must not undo the newer one
+	store.ApplyTrieEvent(&TrieEvent{Origin: "other", ID: 1, UserName: "ada",
+		FirstName: "Ada", LastName: "Lovelace", UpdatedAt: 10})
+	if ids := find("lovelace"); len(ids) != 0 {
+		t.Errorf("expected a stale event to be ignored but found %v", ids)
+	}
+	if ids := find("king"); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("expected the newest names to be kept but found %v", ids)
+	}
+	// the next snapshot doesn't need to replay the applied events
+	if store.trieSince != 20 {
+		t.Errorf("expected the trie to be current to 20 but got %d", store.trieSince)
+	}
+
+	// redelivering the same event is harmless
+	store.ApplyTrieEvent(&TrieEvent{Origin: "other", ID: 1, UserName: "ada",
+		FirstName: "Augusta", LastName: "King", UpdatedAt: 20})
+	if ids := find("king"); len(ids) != 1 {
+		t.Errorf("expected one match after a redelivery but found %v", ids)
+	}
+
+	// this store already applied its own events
+	store.ApplyTrieEvent(&TrieEvent{Origin: store.ReplicaID, ID: 2, UserName: "alan", UpdatedAt: 30})
+	if ids := find("alan"); len(ids) != 0 {
+		t.Errorf("expected the store's own event to be ignored but found %v", ids)
+	}
+
+	store.ApplyTrieEvent(&TrieEvent{Origin: "other", ID: 1, Deleted: true, UpdatedAt: 40})
+	if ids := find("ada"); len(ids) != 0 {
+		t.Errorf("expected the deleted user to be removed but found %v", ids)
+	}
+}
+
+func TestReconcileTrie(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+	// this replica missed ada's rename and grace's sign up, and still
+	// has alan, who was deleted
+	store.TrieNode.Add("lovelace", 1)
+	store.TrieNode.Add("alan", 2)
+
+	var since int64 = 1580000000000000000
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectTrieStatement)).
+		WillReturnRows(sqlmock.NewRows(trieColumns).
+			AddRow(1, "ada", "Augusta", "King", since).
+			AddRow(2, "alan", "Alan", "Turing", since-10))
+	// alan was deleted and grace signed up during the scan
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectTrieSinceStatement)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(trieColumns).
+			AddRow(3, "grace", "Grace", "Hopper", since+10))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectDeletedSinceStatement)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	if err := store.ReconcileTrie(); err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+
+	cases := []struct {
+		prefix   string
+		expected []int64
+	}{
+		{"lovelace", nil},
+		{"king", []int64{1}},
+		{"alan", nil},
+		{"hopper", []int64{3}},
+	}
+	for _, c := range cases {
+		ids, _ := store.FindInTrie(c.prefix, 10)
+		if len(ids) != len(c.expected) || (len(ids) > 0 && ids[0] != c.expected[0]) {
+			t.Errorf("prefix %s: expected %v but got %v", c.prefix, c.expected, ids)
+		}
+	}
+	if store.trieSince != since+10 {
+		t.Errorf("expected the trie to be current to %d but got %d", since+10, store.trieSince)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+//TestReconcileTrieKeepsEvents checks that events applied while the new
+//trie is being built aren't lost when it replaces the old one
+func TestReconcileTrieKeepsEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	store := NewPostgressStore(db, MySQL)
+
+	var since int64 = 1580000000000000000
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectTrieStatement)).
+		WillDelayFor(100 * time.Millisecond).
+		WillReturnRows(sqlmock.NewRows(trieColumns).
+			AddRow(1, "ada", "Ada", "Lovelace", since))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectTrieSinceStatement)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(trieColumns))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectDeletedSinceStatement)).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	done := make(chan error)
+	go func() {
+		done <- store.ReconcileTrie()
+	}()
+	// grace signs up on another replica after the scan began
+	time.Sleep(20 * time.Millisecond)
+	store.ApplyTrieEvent(&TrieEvent{Origin: "other", ID: 2, UserName: "grace",
+		FirstName: "Grace", LastName: "Hopper", UpdatedAt: since + 10})
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+
+	for prefix, expected := range map[string]int64{"lovelace": 1, "hopper": 2} {
+		if ids, _ := store.FindInTrie(prefix, 10); len(ids) != 1 || ids[0] != expected {
+			t.Errorf("prefix %s: expected [%d] but got %v", prefix, expected, ids)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import "time"
+
+//CircuitState is the state of an upstream's circuit breaker
+type CircuitState int
+
+const (
+	//CircuitClosed lets every request through
+	CircuitClosed CircuitState = iota
+	//CircuitOpen lets no requests through until it has been open
+	//for the pool's EjectDuration
+	CircuitOpen
+	//CircuitHalfOpen lets one trial request through, which closes
+	//the circuit if it succeeds and opens it again if it fails
+	CircuitHalfOpen
+)
+
+//String returns the state's name as reported by the admin endpoint
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+//breaker is a circuit breaker guarding one upstream. It isn't safe
+//for concurrent use, the upstream's mutex guards it.
+type breaker struct {
+	state     CircuitState
+	failures  int       // Consecutive failed requests while closed
+	openUntil time.Time // When an open circuit lets a trial request through
+	trial     bool      // Whether a half-open circuit's trial is in flight
+}
+
+//current returns the state of the circuit at now. An open circuit
+//whose time is up is half-open, though it only changes state once
+//its trial request is sent.
+func (b *breaker) current(now time.Time) CircuitState {
+	if b.state == CircuitOpen && !now.Before(b.openUntil) {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+//allow returns true if a request can be sent at now
+func (b *breaker) allow(now time.Time) bool {
+	switch b.current(now) {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return !b.trial
+	}
+	return true
+}
+
+//acquire records that a request is being sent at now, returning
+//false if the circuit doesn't allow it
+func (b *breaker) acquire(now time.Time) bool {
+	if !b.allow(now) {
+		return false
+	}
+	if b.current(now) == CircuitHalfOpen {
+		b.state = CircuitHalfOpen
+		b.trial = true
+	}
+	return true
+}
+
+//success records a request that succeeded, closing the circuit
+func (b *breaker) success() {
+	b.state = CircuitClosed
+	b.failures = 0
+	b.trial = false
+}
+
+//failure records a request that failed at now, returning true if it
+//opened the circuit. A closed circuit opens after maxFails failures
+//in a row, a half-open circuit opens on the failure of its trial.
+func (b *breaker) failure(now time.Time, maxFails int, openFor time.Duration) bool {
+	b.failures++
+	if b.state == CircuitHalfOpen || (maxFails > 0 && b.failures >= maxFails) {
+		b.state = CircuitOpen
+		b.openUntil = now.Add(openFor)
+		b.failures = 0
+		b.trial = false
+		return true
+	}
+	return false
+}
+
+//release records a request that neither succeeded nor failed, such
+//as one the client gave up on, so a half-open circuit can try again
+func (b *breaker) release() {
+	b.trial = false
+}
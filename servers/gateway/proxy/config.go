@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+//Config lists the microservices the gateway proxies requests to
+type Config struct {
+	Services []ServiceConfig `json:"services"`
+}
+
+//ServiceConfig is how the gateway proxies requests to one microservice
+type ServiceConfig struct {
+	Name string `json:"name"`
+	//Upstreams are the absolute URLs of the service's instances
+	Upstreams []string `json:"upstreams"`
+	//Prefixes are the paths proxied to the service, along with every
+	//path below them
+	Prefixes []string `json:"prefixes"`
+	//Public services get requests without the user's identity. Other
+	//services only get requests from users with a valid session, the
+	//gateway responds to the rest with 401.
+	Public bool `json:"public,omitempty"`
+	//Timeout bounds each request to the service, zero means no limit
+	Timeout Duration `json:"timeout,omitempty"`
+	//Strategy is roundrobin, the default, or leastconn
+	Strategy string `json:"strategy,omitempty"`
+	//HealthPath is requested by health probes, defaulting to /
+	HealthPath string `json:"healthPath,omitempty"`
+	//MaxRetries defaults to DefaultMaxRetries
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	//Rewrites change the path of each request before it's proxied.
+	//The first rewrite whose prefix matches is applied.
+	Rewrites []Rewrite `json:"rewrites,omitempty"`
+}
+
+//Rewrite replaces a leading path prefix
+type Rewrite struct {
+	Prefix  string `json:"prefix"`
+	Replace string `json:"replace"`
+}
+
+//apply returns the path rewritten and true, or false if the prefix doesn't match
+func (rw Rewrite) apply(path string) (string, bool) {
+	if !strings.HasPrefix(path, rw.Prefix) {
+		return path, false
+	}
+	rewritten := rw.Replace + path[len(rw.Prefix):]
+	if !strings.HasPrefix(rewritten, "/") {
+		rewritten = "/" + rewritten
+	}
+	return rewritten, true
+}
+
+//Duration is a time.Duration written in JSON as a string like "30s"
+type Duration struct {
+	time.Duration
+}
+
+//UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %v", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+//MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+//LoadConfig reads and validates the config file at path
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	config, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+//ParseConfig reads and validates a JSON config. Unknown fields are
+//errors, so typos don't go unnoticed.
+func ParseConfig(r io.Reader) (*Config, error) {
+	config := &Config{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+//Validate returns every problem with the config, or nil if it can be used
+func (c *Config) Validate() error {
+	var errs []error
+	names := map[string]bool{}
+	prefixes := map[string]string{}
+	for idx, service := range c.Services {
+		fail := func(format string, args ...interface{}) {
+			name := service.Name
+			if len(name) == 0 {
+				name = fmt.Sprintf("#%d", idx+1)
+			}
+			errs = append(errs, fmt.Errorf("service %s: %s", name, fmt.Sprintf(format, args...)))
+		}
+		if len(service.Name) == 0 {
+			fail("name is required")
+		} else if names[service.Name] {
+			fail("name is used by another service")
+		}
+		names[service.Name] = true
+
+		if len(service.Upstreams) == 0 {
+			fail("at least one upstream is required")
+		}
+		for _, upstream := range service.Upstreams {
+			if _, err := parseUpstream(upstream); err != nil {
+				fail("%v", err)
+			}
+		}
+
+		if len(service.Prefixes) == 0 {
+			fail("at least one prefix is required")
+		}
+		for _, prefix := range service.Prefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				fail("prefix %q must start with /", prefix)
+				continue
+			}
+			prefix = strings.TrimSuffix(prefix, "/")
+			if other, exists := prefixes[prefix]; exists {
+				fail("prefix %q is used by service %s", prefix, other)
+			}
+			prefixes[prefix] = service.Name
+		}
+
+		if service.Timeout.Duration < 0 {
+			fail("timeout can't be negative")
+		}
+		if len(service.Strategy) != 0 {
+			if _, err := ParseStrategy(service.Strategy); err != nil {
+				fail("%v", err)
+			}
+		}
+		if len(service.HealthPath) != 0 && !strings.HasPrefix(service.HealthPath, "/") {
+			fail("health path %q must start with /", service.HealthPath)
+		}
+		if service.MaxRetries != nil && *service.MaxRetries < 0 {
+			fail("max retries can't be negative")
+		}
+		for _, rewrite := range service.Rewrites {
+			if !strings.HasPrefix(rewrite.Prefix, "/") {
+				fail("rewrite prefix %q must start with /", rewrite.Prefix)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//parseUpstream parses an upstream's absolute http or https URL
+func parseUpstream(upstream string) (*url.URL, error) {
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || len(target.Host) == 0 {
+		return nil, fmt.Errorf("upstream %q must be an absolute http or https URL", upstream)
+	}
+	return target, nil
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+  "services": [
+    {
+      "name": "messages",
+      "upstreams": ["http://localhost:4000", "http://localhost:4001"],
+      "prefixes": ["/v1/channels", "/v1/messages/"],
+      "timeout": "30s",
+      "strategy": "leastconn",
+      "maxRetries": 0
+    },
+    {
+      "name": "summary",
+      "upstreams": ["https://localhost:8080"],
+      "prefixes": ["/v1/summary"],
+      "public": true,
+      "healthPath": "/healthz",
+      "rewrites": [{"prefix": "/v1/summary", "replace": "/summary"}]
+    }
+  ]
+}`
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config.Services) != 2 {
+		t.Fatalf("expected 2 services but got %d", len(config.Services))
+	}
+	messages, summary := config.Services[0], config.Services[1]
+	if messages.Timeout.Duration != 30*time.Second || messages.MaxRetries == nil || *messages.MaxRetries != 0 {
+		t.Errorf("unexpected messages config: %+v", messages)
+	}
+	if !summary.Public || summary.HealthPath != "/healthz" || len(summary.Rewrites) != 1 || summary.MaxRetries != nil {
+		t.Errorf("unexpected summary config: %+v", summary)
+	}
+
+	path := filepath.Join(t.TempDir(), "gateway.json")
+	os.WriteFile(path, []byte(testConfig), 0600)
+	if _, err := LoadConfig(path); err != nil {
+		t.Errorf("unexpected error loading config file: %v", err)
+	}
+	if _, err := LoadConfig(path + ".missing"); err == nil {
+		t.Errorf("expected error for missing config file")
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   string
+		expected []string
+	}{
+		{
+			"Unknown Field",
+			`{"services": [{"name": "a", "upstream": ["http://a"], "prefixes": ["/a"]}]}`,
+			[]string{`unknown field "upstream"`},
+		},
+		{
+			"Bad Timeout",
+			`{"services": [{"name": "a", "upstreams": ["http://a"], "prefixes": ["/a"], "timeout": 30}]}`,
+			[]string{"duration"},
+		},
+		{
+			"Missing Fields",
+			`{"services": [{}]}`,
+			[]string{"service #1: name is required", "upstream is required", "prefix is required"},
+		},
+		{
+			"Invalid Values",
+			`{"services": [{"name": "a", "upstreams": ["micro-messaging:4000", "ftp://a"], "prefixes": ["v1/a"],
+				"timeout": "-1s", "strategy": "random", "healthPath": "health", "maxRetries": -1,
+				"rewrites": [{"prefix": "a", "replace": "/"}]}]}`,
+			[]string{
+				`upstream "micro-messaging:4000" must be an absolute`,
+				`upstream "ftp://a" must be an absolute`,
+				`prefix "v1/a" must start with /`,
+				"timeout can't be negative",
+				`unknown load balancing strategy "random"`,
+				`health path "health" must start with /`,
+				"max retries can't be negative",
+				`rewrite prefix "a" must start with /`,
+			},
+		},
+		{
+			"Duplicates",
+			`{"services": [
+				{"name": "a", "upstreams": ["http://a"], "prefixes": ["/a"]},
+				{"name": "a", "upstreams": ["http://b"], "prefixes": ["/a/"]}]}`,
+			[]string{"service a: name is used by another service", `prefix "/a" is used by service a`},
+		},
+	}
+
+	for _, c := range cases {
+		_, err := ParseConfig(strings.NewReader(c.config))
+		if err == nil {
+			t.Errorf("case %s: expected error", c.name)
+			continue
+		}
+		for _, expected := range c.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("case %s: expected error to contain %q but got %v", c.name, expected, err)
+			}
+		}
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	cases := []struct {
+		rewrite  Rewrite
+		path     string
+		expected string
+		ok       bool
+	}{
+		{Rewrite{"/v1/summary", "/summary"}, "/v1/summary/x", "/summary/x", true},
+		{Rewrite{"/v1/summary", ""}, "/v1/summary", "/", true},
+		{Rewrite{"/v1", "v2"}, "/v1/a", "/v2/a", true},
+		{Rewrite{"/v1/summary", "/summary"}, "/v1/channels", "/v1/channels", false},
+	}
+	for _, c := range cases {
+		if path, ok := c.rewrite.apply(c.path); path != c.expected || ok != c.ok {
+			t.Errorf("rewrite %+v of %s: expected %s, %v but got %s, %v", c.rewrite, c.path, c.expected, c.ok, path, ok)
+		}
+	}
+}
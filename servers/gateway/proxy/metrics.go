@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//Outcomes of a request to an upstream, as counted by upstreamRequests
+const (
+	//OutcomeSuccess is a response the upstream could handle the request with
+	OutcomeSuccess = "success"
+	//OutcomeBadStatus is a 502, 503 or 504 response
+	OutcomeBadStatus = "bad_status"
+	//OutcomeError is a request that got no response
+	OutcomeError = "error"
+	//OutcomeCanceled is a request the client gave up on
+	OutcomeCanceled = "canceled"
+	//OutcomeNoUpstream is a request no upstream was available for
+	OutcomeNoUpstream = "no_upstream"
+)
+
+//upstreamRequests counts the requests sent to each upstream by outcome.
+//Requests no upstream was available for have an empty upstream.
+var upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gateway",
+	Subsystem: "upstream",
+	Name:      "requests_total",
+	Help:      "Requests proxied to each upstream, by outcome.",
+}, []string{"service", "upstream", "outcome"})
+
+//upstreamDuration times how long each upstream took to respond
+var upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gateway",
+	Subsystem: "upstream",
+	Name:      "request_duration_seconds",
+	Help:      "Time until each upstream responded to a proxied request.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"service", "upstream"})
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpstreamMetrics(t *testing.T) {
+	up := newTestBackend(t, "up")
+	down := newTestBackend(t, "down")
+	down.setDown(true)
+	pool, front := newTestPool(t, RoundRobin, up, down)
+	pool.MaxFails = 0
+
+	//requests the down upstream fails are retried on the other
+	for i := 0; i < 4; i++ {
+		get(t, front, "/v1/channels")
+	}
+	cases := []struct {
+		name     string
+		upstream string
+		outcome  string
+		expected float64
+	}{
+		{"Successes", up.URL, OutcomeSuccess, 4},
+		{"Bad Statuses", down.URL, OutcomeBadStatus, float64(atomic.LoadInt32(&down.hits))},
+		{"No Errors", up.URL, OutcomeError, 0},
+	}
+	for _, c := range cases {
+		if count := testutil.ToFloat64(upstreamRequests.WithLabelValues(pool.Name, c.upstream, c.outcome)); count != c.expected {
+			t.Errorf("case %s: expected %v but got %v", c.name, c.expected, count)
+		}
+	}
+	if count := testutil.CollectAndCount(upstreamDuration, "gateway_upstream_request_duration_seconds"); count < 2 {
+		t.Errorf("expected a latency histogram for each upstream but got %d", count)
+	}
+
+	//without an upstream the request isn't sent at all
+	up.setDown(true)
+	down.Close()
+	up.Close()
+	pool.CheckHealth(t.Context())
+	before := testutil.ToFloat64(upstreamRequests.WithLabelValues(pool.Name, "", OutcomeNoUpstream))
+	if status, _ := get(t, front, "/v1/channels"); status != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d but got %d", http.StatusServiceUnavailable, status)
+	}
+	if count := testutil.ToFloat64(upstreamRequests.WithLabelValues(pool.Name, "", OutcomeNoUpstream)); count != before+1 {
+		t.Errorf("expected a request without an upstream to be counted but got %v", count-before)
+	}
+}
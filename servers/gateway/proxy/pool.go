@@ -0,0 +1,402 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/requestlog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//Strategy decides which available upstream gets the next request
+type Strategy int
+
+const (
+	//RoundRobin sends requests to each available upstream in turn
+	RoundRobin Strategy = iota
+	//LeastConnections sends each request to the available upstream
+	//with the fewest requests in flight
+	LeastConnections
+)
+
+//String returns the name ParseStrategy accepts for the strategy
+func (s Strategy) String() string {
+	if s == LeastConnections {
+		return "leastconn"
+	}
+	return "roundrobin"
+}
+
+//ParseStrategy returns the Strategy with the given name,
+//"roundrobin" or "leastconn"
+func ParseStrategy(name string) (Strategy, error) {
+	switch name {
+	case "roundrobin":
+		return RoundRobin, nil
+	case "leastconn":
+		return LeastConnections, nil
+	}
+	return RoundRobin, fmt.Errorf("unknown load balancing strategy %q", name)
+}
+
+//ErrNoUpstream is returned when every upstream of a pool is unhealthy or ejected
+var ErrNoUpstream = errors.New("no healthy upstream")
+
+//Default pool settings, used by NewPool
+const (
+	DefaultHealthPath     = "/"
+	DefaultHealthInterval = 10 * time.Second
+	DefaultHealthTimeout  = 2 * time.Second
+	DefaultMaxFails       = 3
+	DefaultEjectDuration  = 30 * time.Second
+)
+
+//Upstream is one instance of a service behind the gateway
+type Upstream struct {
+	URL *url.URL
+
+	active int64 // Requests in flight, updated atomically
+
+	mx        sync.Mutex
+	healthy   bool      // Result of the last health probe
+	circuit   breaker   // Trips when requests keep failing
+	lastCheck time.Time // When the last health probe finished
+	lastError string    // Why the last probe or request failed
+}
+
+//available returns true if the upstream can take requests at now
+func (u *Upstream) available(now time.Time) bool {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	return u.healthy && u.circuit.allow(now)
+}
+
+//acquire claims the upstream for a request at now, returning false
+//if it can't take one
+func (u *Upstream) acquire(now time.Time) bool {
+	u.mx.Lock()
+	defer u.mx.Unlock()
+	return u.healthy && u.circuit.acquire(now)
+}
+
+//Pool balances requests over the upstreams of one service. It is an
+//http.RoundTripper, so it can be a ReverseProxy's Transport: it picks
+//the upstream for each request, and each upstream's circuit breaker
+//stops requests to it while they keep failing. Health probes take upstreams out of rotation until
+//they recover.
+type Pool struct {
+	Name     string
+	Strategy Strategy
+	//HealthPath is requested by each probe. Any response below 500
+	//means the upstream is up, since it may require authentication.
+	HealthPath     string
+	HealthInterval time.Duration
+	HealthTimeout  time.Duration
+	//MaxFails consecutive failed requests open an upstream's circuit
+	//for EjectDuration, after which one trial request decides whether
+	//it closes again. A request fails if it gets no response, or a
+	//502, 503 or 504. Circuits never open if MaxFails is 0.
+	MaxFails      int
+	EjectDuration time.Duration
+	//Transport makes the requests and probes
+	Transport http.RoundTripper
+
+	upstreams []*Upstream
+	next      uint64
+	now       func() time.Time
+}
+
+//NewPool constructs a new Pool of the given upstreams with the default
+//health check and circuit breaker settings
+func NewPool(name string, targets []*url.URL, strategy Strategy) *Pool {
+	upstreams := make([]*Upstream, len(targets))
+	for idx, target := range targets {
+		//upstreams are assumed healthy until a probe says otherwise
+		upstreams[idx] = &Upstream{URL: target, healthy: true}
+	}
+	return &Pool{
+		Name:           name,
+		Strategy:       strategy,
+		HealthPath:     DefaultHealthPath,
+		HealthInterval: DefaultHealthInterval,
+		HealthTimeout:  DefaultHealthTimeout,
+		MaxFails:       DefaultMaxFails,
+		EjectDuration:  DefaultEjectDuration,
+		Transport:      http.DefaultTransport,
+		upstreams:      upstreams,
+		now:            time.Now,
+	}
+}
+
+//Upstreams returns the pool's upstreams
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+//adopt replaces the pool's upstreams with those of the old pool that
+//have the same URL
+func (p *Pool) adopt(old *Pool) {
+	for idx, upstream := range p.upstreams {
+		for _, oldUpstream := range old.upstreams {
+			if oldUpstream.URL.String() == upstream.URL.String() {
+				p.upstreams[idx] = oldUpstream
+				break
+			}
+		}
+	}
+}
+
+//Pick returns the upstream for the next request, or ErrNoUpstream.
+//The request must then be sent, since a half-open circuit only lets
+//one through.
+func (p *Pool) Pick() (*Upstream, error) {
+	return p.pick(nil)
+}
+
+//pick returns the upstream for the next request, skipping those in exclude
+func (p *Pool) pick(exclude map[*Upstream]bool) (*Upstream, error) {
+	now := p.now()
+	if p.Strategy == LeastConnections {
+		//another request may claim the best upstream's half-open
+		//circuit first, so look again without it
+		skip := make(map[*Upstream]bool, len(exclude))
+		for upstream := range exclude {
+			skip[upstream] = true
+		}
+		for {
+			var best *Upstream
+			var bestActive int64
+			//start at a rotating offset so ties are spread out
+			start := atomic.AddUint64(&p.next, 1)
+			for i := range p.upstreams {
+				upstream := p.upstreams[p.index(start+uint64(i))]
+				if skip[upstream] || !upstream.available(now) {
+					continue
+				}
+				if active := atomic.LoadInt64(&upstream.active); best == nil || active < bestActive {
+					best, bestActive = upstream, active
+				}
+			}
+			if best == nil {
+				return nil, ErrNoUpstream
+			}
+			if best.acquire(now) {
+				return best, nil
+			}
+			skip[best] = true
+		}
+	}
+	for range p.upstreams {
+		upstream := p.upstreams[p.index(atomic.AddUint64(&p.next, 1)-1)]
+		if !exclude[upstream] && upstream.acquire(now) {
+			return upstream, nil
+		}
+	}
+	return nil, ErrNoUpstream
+}
+
+//index returns the index of the upstream at position n of the
+//rotation. The modulus is taken before converting, so it's never
+//negative when int is 32 bits.
+func (p *Pool) index(n uint64) int {
+	return int(n % uint64(len(p.upstreams)))
+}
+
+//RoundTrip sends the request to the next upstream and records
+//whether it succeeded. It implements http.RoundTripper.
+func (p *Pool) RoundTrip(r *http.Request) (*http.Response, error) {
+	upstream, err := p.Pick()
+	if err != nil {
+		return nil, err
+	}
+	return p.send(r, upstream)
+}
+
+//send sends the request to the upstream the pool picked for it
+func (p *Pool) send(r *http.Request, upstream *Upstream) (*http.Response, error) {
+	//RoundTrip must not change the caller's request
+	outreq := r.Clone(r.Context())
+	outreq.URL.Scheme = upstream.URL.Scheme
+	outreq.URL.Host = upstream.URL.Host
+	outreq.Host = upstream.URL.Host
+	upstreamURL := upstream.URL.String()
+	requestlog.SetUpstream(r.Context(), upstreamURL)
+	//each retry is an event on the proxied request's span
+	span := trace.SpanFromContext(r.Context())
+	span.SetAttributes(attribute.String("gateway.upstream", upstreamURL))
+
+	atomic.AddInt64(&upstream.active, 1)
+	start := p.now()
+	resp, err := p.Transport.RoundTrip(outreq)
+	upstreamDuration.WithLabelValues(p.Name, upstreamURL).Observe(p.now().Sub(start).Seconds())
+	if err != nil {
+		atomic.AddInt64(&upstream.active, -1)
+		//a client that went away says nothing about the upstream,
+		//though one that ran out of time does
+		span.AddEvent("upstream failed", trace.WithAttributes(
+			attribute.String("gateway.upstream", upstreamURL), attribute.String("error", err.Error())))
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			upstreamRequests.WithLabelValues(p.Name, upstreamURL, OutcomeCanceled).Inc()
+			p.recordRelease(upstream)
+		} else {
+			upstreamRequests.WithLabelValues(p.Name, upstreamURL, OutcomeError).Inc()
+			p.recordFailure(upstream, err.Error())
+		}
+		return nil, err
+	}
+	if retryableStatus(resp.StatusCode) {
+		span.AddEvent("upstream failed", trace.WithAttributes(
+			attribute.String("gateway.upstream", upstreamURL), attribute.Int("http.response.status_code", resp.StatusCode)))
+		upstreamRequests.WithLabelValues(p.Name, upstreamURL, OutcomeBadStatus).Inc()
+		p.recordFailure(upstream, resp.Status)
+	} else {
+		upstreamRequests.WithLabelValues(p.Name, upstreamURL, OutcomeSuccess).Inc()
+		p.recordSuccess(upstream)
+	}
+	//the request is in flight until its body has been read, or for
+	//an upgraded connection until it's closed
+	body := &activeBody{ReadCloser: resp.Body, active: &upstream.active}
+	if rw, ok := resp.Body.(io.ReadWriteCloser); ok {
+		//ReverseProxy needs to write to the body of a 101 response
+		resp.Body = &activeReadWriteBody{activeBody: body, Writer: rw}
+	} else {
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+//activeBody decrements an upstream's in flight count once closed
+type activeBody struct {
+	io.ReadCloser
+	active *int64
+	once   sync.Once
+}
+
+func (b *activeBody) Close() error {
+	b.once.Do(func() { atomic.AddInt64(b.active, -1) })
+	return b.ReadCloser.Close()
+}
+
+//activeReadWriteBody is an activeBody that can be written to, like
+//the connection in the body of a 101 Switching Protocols response
+type activeReadWriteBody struct {
+	*activeBody
+	io.Writer
+}
+
+//retryableStatus returns true if a response with the status means
+//the upstream couldn't handle the request
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+//recordFailure counts a failed request, opening the upstream's
+//circuit after MaxFails in a row
+func (p *Pool) recordFailure(upstream *Upstream, reason string) {
+	upstream.mx.Lock()
+	defer upstream.mx.Unlock()
+	upstream.lastError = reason
+	if upstream.circuit.failure(p.now(), p.MaxFails, p.EjectDuration) {
+		slog.Warn("Opening circuit to upstream", "service", p.Name, "upstream", upstream.URL.String(),
+			"duration", p.EjectDuration, "reason", reason)
+	}
+}
+
+//recordSuccess closes the upstream's circuit
+func (p *Pool) recordSuccess(upstream *Upstream) {
+	upstream.mx.Lock()
+	defer upstream.mx.Unlock()
+	if upstream.circuit.state != CircuitClosed {
+		slog.Info("Closing circuit to upstream", "service", p.Name, "upstream", upstream.URL.String())
+	}
+	upstream.circuit.success()
+}
+
+//recordRelease records a request that was abandoned before the
+//upstream answered
+func (p *Pool) recordRelease(upstream *Upstream) {
+	upstream.mx.Lock()
+	defer upstream.mx.Unlock()
+	upstream.circuit.release()
+}
+
+//CheckHealth probes every upstream once, concurrently, and
+//returns once all probes are done
+func (p *Pool) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, upstream := range p.upstreams {
+		wg.Add(1)
+		go func(upstream *Upstream) {
+			defer wg.Done()
+			p.probe(ctx, upstream)
+		}(upstream)
+	}
+	wg.Wait()
+}
+
+//RunHealthChecks probes every upstream every HealthInterval until ctx is done
+func (p *Pool) RunHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(p.HealthInterval)
+	defer ticker.Stop()
+	for {
+		p.CheckHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//probe requests the upstream's HealthPath and records whether it's up
+func (p *Pool) probe(ctx context.Context, upstream *Upstream) {
+	ctx, cancel := context.WithTimeout(ctx, p.HealthTimeout)
+	defer cancel()
+	probeURL := *upstream.URL
+	probeURL.Path = p.HealthPath
+
+	var reason string
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err == nil {
+		var resp *http.Response
+		if resp, err = p.Transport.RoundTrip(req); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode >= 500 {
+				reason = "health check returned " + resp.Status
+			}
+		}
+	}
+	if err != nil {
+		reason = err.Error()
+	}
+
+	upstream.mx.Lock()
+	defer upstream.mx.Unlock()
+	healthy := reason == ""
+	if healthy != upstream.healthy {
+		if healthy {
+			slog.Info("Upstream is healthy", "service", p.Name, "upstream", upstream.URL.String())
+		} else {
+			slog.Warn("Upstream is unhealthy", "service", p.Name, "upstream", upstream.URL.String(), "reason", reason)
+		}
+	}
+	upstream.healthy = healthy
+	upstream.lastCheck = p.now()
+	if !healthy {
+		upstream.lastError = reason
+	}
+}
@@ -0,0 +1,427 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+//testBackend is an httptest server that can be taken down and brought
+//back up without changing its address
+type testBackend struct {
+	*httptest.Server
+	name string
+	down int32
+	hits int32
+}
+
+func newTestBackend(t *testing.T, name string) *testBackend {
+	backend := &testBackend{name: name}
+	backend.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DefaultHealthPath {
+			atomic.AddInt32(&backend.hits, 1)
+		}
+		if atomic.LoadInt32(&backend.down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, backend.name)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func (b *testBackend) setDown(down bool) {
+	if down {
+		atomic.StoreInt32(&b.down, 1)
+	} else {
+		atomic.StoreInt32(&b.down, 0)
+	}
+}
+
+//newTestPool returns a pool over the backends, proxied by a test server
+func newTestPool(t *testing.T, strategy Strategy, backends ...*testBackend) (*Pool, *httptest.Server) {
+	targets := make([]*url.URL, len(backends))
+	for idx, backend := range backends {
+		targets[idx], _ = url.Parse(backend.URL)
+	}
+	pool := NewPool("test", targets, strategy)
+	front := httptest.NewServer(NewReverseProxy(pool, func(r *http.Request) {}))
+	t.Cleanup(front.Close)
+	return pool, front
+}
+
+//get requests the path from the server and returns the status and body
+func get(t *testing.T, server *httptest.Server, path string) (int, string) {
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	_, front := newTestPool(t, RoundRobin, a, b)
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		status, body := get(t, front, "/v1/summary")
+		if status != http.StatusOK {
+			t.Fatalf("expected %d but got %d", http.StatusOK, status)
+		}
+		got = append(got, body)
+	}
+	if got[0] == got[1] || got[0] != got[2] || got[1] != got[3] {
+		t.Errorf("expected requests to alternate between upstreams but got %v", got)
+	}
+}
+
+func TestHealthCheckExcludesDownUpstream(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	pool, front := newTestPool(t, RoundRobin, a, b)
+
+	b.setDown(true)
+	pool.CheckHealth(context.Background())
+	for i := 0; i < 4; i++ {
+		if _, body := get(t, front, "/v1/summary"); body != "a" {
+			t.Fatalf("expected the down upstream to get no requests but got %q", body)
+		}
+	}
+
+	b.setDown(false)
+	pool.CheckHealth(context.Background())
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		_, body := get(t, front, "/v1/summary")
+		seen[body] = true
+	}
+	if !seen["a"] || !seen["b"] {
+		t.Errorf("expected the recovered upstream back in rotation but saw %v", seen)
+	}
+}
+
+func TestHealthCheckConnectionRefused(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	pool, front := newTestPool(t, RoundRobin, a, b)
+	b.Close()
+
+	pool.CheckHealth(context.Background())
+	status := pool.Status()
+	if !status.Upstreams[0].Healthy || status.Upstreams[1].Healthy {
+		t.Fatalf("expected only the closed upstream to be unhealthy but got %+v", status.Upstreams)
+	}
+	if status.Upstreams[1].LastError == "" || status.Upstreams[1].LastCheck == nil {
+		t.Errorf("expected the failed probe to be reported but got %+v", status.Upstreams[1])
+	}
+	if _, body := get(t, front, "/v1/summary"); body != "a" {
+		t.Errorf("expected the live upstream but got %q", body)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	pool, front := newTestPool(t, RoundRobin, a, b)
+	now := time.Now()
+	pool.now = func() time.Time { return now }
+	pool.MaxFails = 2
+
+	//without a probe, b keeps failing requests until its circuit
+	//opens, and each failed request is retried on a
+	b.setDown(true)
+	for i := 0; i < 8; i++ {
+		if status, body := get(t, front, "/v1/summary"); status != http.StatusOK || body != "a" {
+			t.Fatalf("expected the request to be retried on a but got %d %q", status, body)
+		}
+	}
+	if hits := atomic.LoadInt32(&b.hits); hits != int32(pool.MaxFails) {
+		t.Errorf("expected %d requests to b before its circuit opened but got %d", pool.MaxFails, hits)
+	}
+	if status := pool.Status().Upstreams[1]; status.Available || status.Circuit != "open" || status.OpenUntil == nil {
+		t.Errorf("expected b's circuit to be open but got %+v", status)
+	}
+
+	//once open long enough, one failed trial opens it again
+	now = now.Add(pool.EjectDuration)
+	if status := pool.Status().Upstreams[1]; !status.Available || status.Circuit != "half-open" {
+		t.Errorf("expected b's circuit to be half-open but got %+v", status)
+	}
+	atomic.StoreInt32(&b.hits, 0)
+	for i := 0; i < 4; i++ {
+		get(t, front, "/v1/summary")
+	}
+	if hits := atomic.LoadInt32(&b.hits); hits != 1 {
+		t.Errorf("expected a single trial request to b but got %d", hits)
+	}
+	if status := pool.Status().Upstreams[1]; status.Circuit != "open" || !status.OpenUntil.After(now) {
+		t.Errorf("expected b's circuit to open again but got %+v", status)
+	}
+
+	//and a successful trial closes it
+	b.setDown(false)
+	now = now.Add(pool.EjectDuration)
+	atomic.StoreInt32(&b.hits, 0)
+	for i := 0; i < 4; i++ {
+		get(t, front, "/v1/summary")
+	}
+	if hits := atomic.LoadInt32(&b.hits); hits != 2 {
+		t.Errorf("expected b back in rotation after a successful trial but it got %d requests", hits)
+	}
+	if status := pool.Status().Upstreams[1]; status.Circuit != "closed" {
+		t.Errorf("expected b's circuit to be closed but got %+v", status)
+	}
+}
+
+func TestBreakerSingleTrial(t *testing.T) {
+	now := time.Now()
+	var b breaker
+	if !b.failure(now, 1, time.Second) || b.acquire(now) {
+		t.Fatalf("expected an open circuit to refuse requests")
+	}
+	later := now.Add(time.Second)
+	if !b.acquire(later) {
+		t.Fatalf("expected a half-open circuit to allow a trial")
+	}
+	if b.acquire(later) {
+		t.Errorf("expected a half-open circuit to allow only one trial at a time")
+	}
+	b.release()
+	if !b.acquire(later) {
+		t.Errorf("expected an abandoned trial to let another through")
+	}
+	b.success()
+	if b.current(later) != CircuitClosed || !b.acquire(later) || !b.acquire(later) {
+		t.Errorf("expected a successful trial to close the circuit")
+	}
+}
+
+func TestSuccessResetsFailures(t *testing.T) {
+	a := newTestBackend(t, "a")
+	pool, front := newTestPool(t, RoundRobin, a)
+	pool.MaxFails = 2
+
+	for i := 0; i < 3; i++ {
+		a.setDown(true)
+		get(t, front, "/v1/summary")
+		a.setDown(false)
+		get(t, front, "/v1/summary")
+	}
+	if status := pool.Status().Upstreams[0]; !status.Available || status.Failures != 0 {
+		t.Errorf("expected failures separated by successes not to eject but got %+v", status)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	t.Cleanup(func() { once.Do(func() { close(release) }) })
+	started := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, "slow")
+	}))
+	t.Cleanup(slow.Close)
+	fast := newTestBackend(t, "fast")
+
+	slowURL, _ := url.Parse(slow.URL)
+	fastURL, _ := url.Parse(fast.URL)
+	pool := NewPool("test", []*url.URL{slowURL, fastURL}, LeastConnections)
+	front := httptest.NewServer(NewReverseProxy(pool, func(r *http.Request) {}))
+	t.Cleanup(front.Close)
+
+	//tie the slow upstream up with one request, then every other
+	//request should go to the idle one
+	done := make(chan string)
+	go func() {
+		for {
+			_, body := get(t, front, "/v1/summary")
+			if body == "slow" {
+				done <- body
+				return
+			}
+		}
+	}()
+	<-started
+	for i := 0; i < 5; i++ {
+		if _, body := get(t, front, "/v1/summary"); body != "fast" {
+			t.Errorf("expected the upstream with no requests in flight but got %q", body)
+		}
+	}
+	if active := pool.Status().Upstreams[0].Active; active != 1 {
+		t.Errorf("expected 1 request in flight on the slow upstream but got %d", active)
+	}
+	once.Do(func() { close(release) })
+	<-done
+	if active := pool.Status().Upstreams[0].Active; active != 0 {
+		t.Errorf("expected no requests in flight once finished but got %d", active)
+	}
+}
+
+func TestAllUpstreamsDown(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	pool, front := newTestPool(t, LeastConnections, a, b)
+	a.setDown(true)
+	b.setDown(true)
+	pool.CheckHealth(context.Background())
+
+	status, body := get(t, front, "/v1/summary")
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("expected %d but got %d", http.StatusServiceUnavailable, status)
+	}
+	var errBody ErrorBody
+	if err := json.Unmarshal([]byte(body), &errBody); err != nil {
+		t.Fatalf("expected a JSON error body but got %q", body)
+	}
+	if errBody.Status != http.StatusServiceUnavailable || errBody.Service != "test" || errBody.Error == "" {
+		t.Errorf("unexpected error body: %+v", errBody)
+	}
+	if _, err := pool.Pick(); err != ErrNoUpstream {
+		t.Errorf("expected %v but got %v", ErrNoUpstream, err)
+	}
+}
+
+func TestRunHealthChecks(t *testing.T) {
+	a := newTestBackend(t, "a")
+	pool, _ := newTestPool(t, RoundRobin, a)
+	pool.HealthInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		pool.RunHealthChecks(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	a.setDown(true)
+	waitFor(t, func() bool { return !pool.Status().Upstreams[0].Healthy })
+	a.setDown(false)
+	waitFor(t, func() bool { return pool.Status().Upstreams[0].Healthy })
+}
+
+//waitFor fails the test if cond isn't true within a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	a, b := newTestBackend(t, "a"), newTestBackend(t, "b")
+	messages, _ := newTestPool(t, RoundRobin, a, b)
+	messages.Name = "messages"
+	summary, _ := newTestPool(t, LeastConnections, a)
+	summary.Name = "summary"
+	b.setDown(true)
+	messages.CheckHealth(context.Background())
+
+	rr := httptest.NewRecorder()
+	StatusHandler(PoolList{messages, summary}).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/upstreams", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d but got %d", http.StatusOK, rr.Code)
+	}
+	if ctype := rr.Header().Get("Content-Type"); ctype != "application/json" {
+		t.Errorf("expected JSON but got %q", ctype)
+	}
+	var statuses []PoolStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("error decoding status: %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].Name != "messages" || statuses[1].Strategy != "leastconn" {
+		t.Fatalf("unexpected status: %+v", statuses)
+	}
+	upstreams := statuses[0].Upstreams
+	if len(upstreams) != 2 || upstreams[0].URL != a.URL || !upstreams[0].Healthy || upstreams[1].Healthy {
+		t.Errorf("expected only b to be unhealthy but got %+v", upstreams)
+	}
+
+	rr = httptest.NewRecorder()
+	StatusHandler(PoolList{messages}).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/upstreams", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected %d but got %d", http.StatusMethodNotAllowed, rr.Code)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, s := range []Strategy{RoundRobin, LeastConnections} {
+		if parsed, err := ParseStrategy(s.String()); err != nil || parsed != s {
+			t.Errorf("expected %v but got %v, %v", s, parsed, err)
+		}
+	}
+	if _, err := ParseStrategy("random"); err == nil {
+		t.Errorf("expected error for unknown strategy")
+	}
+}
+
+//TestWebSocketUpgrade checks that upgraded connections are proxied,
+//and count as in flight until they're closed
+func TestWebSocketUpgrade(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, message)
+		}
+	}))
+	t.Cleanup(backend.Close)
+	backendURL, _ := url.Parse(backend.URL)
+	pool := NewPool("test", []*url.URL{backendURL}, RoundRobin)
+	front := httptest.NewServer(NewReverseProxy(pool, func(r *http.Request) {}))
+	t.Cleanup(front.Close)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(front.URL, "http")+"/v1/ws", nil)
+	if err != nil {
+		t.Fatalf("unexpected error dialing: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected status %d but got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if _, message, err := conn.ReadMessage(); err != nil || string(message) != "hello" {
+		t.Errorf("expected the message echoed back but got %q, %v", message, err)
+	}
+	if active := pool.Status().Upstreams[0].Active; active != 1 {
+		t.Errorf("expected the open connection in flight but got %d", active)
+	}
+	conn.Close()
+	waitFor(t, func() bool { return pool.Status().Upstreams[0].Active == 0 })
+}
+
+func TestIndexNeverNegative(t *testing.T) {
+	pool := NewPool("test", make([]*url.URL, 3), RoundRobin)
+	for _, n := range []uint64{0, 1, 1<<31 - 1, 1 << 31, 1<<32 - 1, 1 << 32, 1<<64 - 1} {
+		if idx := pool.index(n); idx < 0 || idx >= 3 || uint64(idx) != n%3 {
+			t.Errorf("index(%d): expected %d but got %d", n, n%3, idx)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+//routerRun is a Router along with the health checks of its pools
+type routerRun struct {
+	router *Router
+	stop   context.CancelFunc
+	done   chan struct{}
+}
+
+//Reloader serves requests with a Router that can be replaced while
+//the gateway runs. Requests already being proxied finish with the
+//Router they started with.
+type Reloader struct {
+	director Director
+	mx       sync.Mutex // Serializes reloads
+	current  atomic.Pointer[routerRun]
+}
+
+//NewReloader constructs a new Reloader serving a Router built from
+//the config, and starts probing its upstreams
+func NewReloader(config *Config, director Director) (*Reloader, error) {
+	router, err := NewRouter(config, director)
+	if err != nil {
+		return nil, err
+	}
+	rl := &Reloader{director: director}
+	rl.current.Store(startRouter(router))
+	return rl, nil
+}
+
+//startRouter starts probing the upstreams of the router
+func startRouter(router *Router) *routerRun {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &routerRun{router: router, stop: cancel, done: make(chan struct{})}
+	go func() {
+		router.RunHealthChecks(ctx)
+		close(run.done)
+	}()
+	return run
+}
+
+//Reload replaces the Router with one built from the config. Upstreams
+//kept by a service carry their health and circuit state over. If the
+//config is invalid the current Router is kept.
+func (rl *Reloader) Reload(config *Config) error {
+	rl.mx.Lock()
+	defer rl.mx.Unlock()
+	router, err := NewRouter(config, rl.director)
+	if err != nil {
+		return err
+	}
+	old := rl.current.Load()
+	router.adopt(old.router)
+	rl.current.Store(startRouter(router))
+	old.stop()
+	<-old.done
+	return nil
+}
+
+//Close stops probing the current Router's upstreams
+func (rl *Reloader) Close() {
+	run := rl.current.Load()
+	run.stop()
+	<-run.done
+}
+
+//Router returns the current Router
+func (rl *Reloader) Router() *Router {
+	return rl.current.Load().router
+}
+
+//Pools returns the current Router's pools
+func (rl *Reloader) Pools() []*Pool {
+	return rl.Router().Pools()
+}
+
+//Route returns the pattern the request matches in the current Router
+func (rl *Reloader) Route(r *http.Request) string {
+	return rl.Router().Route(r)
+}
+
+//ServeHTTP proxies the request with the current Router
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.Router().ServeHTTP(w, r)
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloader(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/slow" {
+			close(started)
+			<-release
+		}
+		io.WriteString(w, "old")
+	}))
+	t.Cleanup(old.Close)
+	replacement := newTestBackend(t, "new")
+	kept := newTestBackend(t, "kept")
+
+	config := &Config{Services: []ServiceConfig{
+		{Name: "messages", Upstreams: []string{old.URL}, Prefixes: []string{"/v1"}},
+		{Name: "summary", Upstreams: []string{kept.URL}, Prefixes: []string{"/v1/summary"}},
+	}}
+	rl, err := NewReloader(config, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(rl.Close)
+	front := httptest.NewServer(rl)
+	t.Cleanup(front.Close)
+
+	//a request in flight when the routes change finishes on the old upstream
+	done := make(chan string)
+	go func() {
+		_, body := get(t, front, "/v1/slow")
+		done <- body
+	}()
+	<-started
+	keptUpstream := rl.Pools()[1].Upstreams()[0]
+	//the kept upstream's circuit is open, and stays open after the reload
+	rl.Pools()[1].MaxFails = 1
+	rl.Pools()[1].recordFailure(keptUpstream, "test failure")
+
+	reloaded := &Config{Services: []ServiceConfig{
+		{Name: "messages", Upstreams: []string{replacement.URL}, Prefixes: []string{"/v1/channels"}},
+		{Name: "summary", Upstreams: []string{kept.URL}, Prefixes: []string{"/v1/summary"}},
+	}}
+	if err := rl.Reload(reloaded); err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if _, body := get(t, front, "/v1/channels"); body != "new" {
+		t.Errorf("expected requests to go to the new upstream but got %q", body)
+	}
+	if status, _ := get(t, front, "/v1/slow"); status != http.StatusNotFound {
+		t.Errorf("expected removed prefixes to stop matching but got %d", status)
+	}
+	close(release)
+	if body := <-done; body != "old" {
+		t.Errorf("expected the in flight request to finish on the old upstream but got %q", body)
+	}
+
+	if upstream := rl.Pools()[1].Upstreams()[0]; upstream != keptUpstream {
+		t.Errorf("expected the kept upstream to carry over")
+	}
+	if status := rl.Pools()[1].Status().Upstreams[0]; status.Circuit != "open" {
+		t.Errorf("expected the kept upstream's circuit to stay open but got %+v", status)
+	}
+
+	//an invalid config keeps the current routes
+	if err := rl.Reload(&Config{Services: []ServiceConfig{{Name: "messages"}}}); err == nil {
+		t.Errorf("expected error reloading an invalid config")
+	}
+	if _, body := get(t, front, "/v1/channels"); body != "new" {
+		t.Errorf("expected the current routes to be kept but got %q", body)
+	}
+
+	rr := httptest.NewRecorder()
+	StatusHandler(rl).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/upstreams", nil))
+	if body := rr.Body.String(); !strings.Contains(body, replacement.URL) || strings.Contains(body, old.URL) {
+		t.Errorf("expected the status of the reloaded upstreams but got %s", body)
+	}
+}
+
+func TestReloaderStopsOldHealthChecks(t *testing.T) {
+	probes := make(chan string, 100)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes <- r.URL.Path
+	}))
+	t.Cleanup(backend.Close)
+	config := &Config{Services: []ServiceConfig{
+		{Name: "messages", Upstreams: []string{backend.URL}, Prefixes: []string{"/v1"}, HealthPath: "/old"},
+	}}
+	rl, err := NewReloader(config, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitForProbe(t, probes, "/old")
+
+	config.Services[0].HealthPath = "/new"
+	if err := rl.Reload(config); err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	waitForProbe(t, probes, "/new")
+	rl.Close()
+	for len(probes) > 0 {
+		if path := <-probes; path == "/old" {
+			t.Errorf("expected the old router to stop probing")
+		}
+	}
+}
+
+//waitForProbe waits for a probe of the path
+func waitForProbe(t *testing.T, probes chan string, path string) {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case probed := <-probes:
+			if probed == path {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("expected a probe of %s", path)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+)
+
+//DefaultMaxRetries is how many other upstreams NewReverseProxy tries
+//after an idempotent request fails
+const DefaultMaxRetries = 2
+
+//RetryTransport sends requests through a pool, retrying idempotent
+//requests that fail on upstreams that haven't been tried yet. A
+//request fails if it gets no response, or a 502, 503 or 504.
+type RetryTransport struct {
+	Pool       *Pool
+	MaxRetries int
+}
+
+//NewRetryTransport constructs a new RetryTransport that retries
+//requests at most maxRetries times
+func NewRetryTransport(pool *Pool, maxRetries int) *RetryTransport {
+	return &RetryTransport{
+		Pool:       pool,
+		MaxRetries: maxRetries,
+	}
+}
+
+//RoundTrip sends the request, retrying it on another upstream if it
+//fails and can safely be sent again. Once out of upstreams or retries
+//it returns the last failure. It implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !retryable(r) {
+		return t.Pool.RoundTrip(r)
+	}
+	tried := map[*Upstream]bool{}
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt <= t.MaxRetries; attempt++ {
+		upstream, pickErr := t.Pool.pick(tried)
+		if pickErr != nil {
+			if attempt == 0 {
+				return nil, pickErr
+			}
+			break
+		}
+		tried[upstream] = true
+		req := r
+		if attempt > 0 {
+			//the failed response is dropped for the next one
+			if resp != nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			if req, err = rewind(r); err != nil {
+				t.Pool.recordRelease(upstream)
+				return nil, err
+			}
+		}
+		resp, err = t.Pool.send(req, upstream)
+		if err == nil && !retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if r.Context().Err() != nil {
+			break
+		}
+	}
+	return resp, err
+}
+
+//retryable returns true if the request is idempotent and its body,
+//if any, can be sent again
+func retryable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+//rewind returns a shallow copy of the request with a fresh body
+func rewind(r *http.Request) (*http.Request, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r, nil
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	rewound := r.WithContext(r.Context())
+	rewound.Body = body
+	return rewound, nil
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryOnAnotherUpstream(t *testing.T) {
+	closed, live := newTestBackend(t, "closed"), newTestBackend(t, "live")
+	pool, front := newTestPool(t, RoundRobin, closed, live)
+	//keep sending requests to the closed upstream
+	pool.MaxFails = 0
+	closed.Close()
+
+	for i := 0; i < 4; i++ {
+		if status, body := get(t, front, "/v1/channels"); status != http.StatusOK || body != "live" {
+			t.Errorf("expected the request to be retried on the live upstream but got %d %q", status, body)
+		}
+	}
+
+	//a POST may have been handled before the connection failed, so
+	//it's never sent twice
+	failures := 0
+	for i := 0; i < 4; i++ {
+		resp, err := http.Post(front.URL+"/v1/channels", "application/json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var errBody ErrorBody
+		if resp.StatusCode == http.StatusBadGateway {
+			failures++
+			if err := json.NewDecoder(resp.Body).Decode(&errBody); err != nil || errBody.Status != http.StatusBadGateway {
+				t.Errorf("expected a JSON error body but got %+v, %v", errBody, err)
+			}
+		}
+		resp.Body.Close()
+	}
+	if failures == 0 {
+		t.Errorf("expected POSTs to the closed upstream not to be retried")
+	}
+}
+
+func TestRetriesBounded(t *testing.T) {
+	backends := []*testBackend{
+		newTestBackend(t, "a"), newTestBackend(t, "b"),
+		newTestBackend(t, "c"), newTestBackend(t, "d"),
+	}
+	for _, backend := range backends {
+		backend.setDown(true)
+	}
+	_, front := newTestPool(t, RoundRobin, backends...)
+
+	status, _ := get(t, front, "/v1/channels")
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("expected the last upstream's %d but got %d", http.StatusServiceUnavailable, status)
+	}
+	var hits int32
+	for _, backend := range backends {
+		backendHits := atomic.LoadInt32(&backend.hits)
+		if backendHits > 1 {
+			t.Errorf("expected no upstream to be tried twice but %s was tried %d times", backend.name, backendHits)
+		}
+		hits += backendHits
+	}
+	if hits != DefaultMaxRetries+1 {
+		t.Errorf("expected %d attempts but got %d", DefaultMaxRetries+1, hits)
+	}
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	var attempts int32
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	t.Cleanup(echo.Close)
+	first, _ := url.Parse(echo.URL)
+	second, _ := url.Parse(echo.URL)
+	client := &http.Client{Transport: NewRetryTransport(NewPool("test", []*url.URL{first, second}, RoundRobin), 1)}
+
+	req, _ := http.NewRequest(http.MethodPut, "http://upstream/v1/messages/1", strings.NewReader("edited"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "edited" {
+		t.Errorf("expected the body to be sent again but got %d %q", resp.StatusCode, body)
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("expected 2 attempts but got %d", n)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	get := httptest.NewRequest(http.MethodGet, "/", nil)
+	post := httptest.NewRequest(http.MethodPost, "/", nil)
+	//requests a server receives can't rewind their bodies
+	put := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("body"))
+	del, _ := http.NewRequest(http.MethodDelete, "/", strings.NewReader("body"))
+
+	cases := []struct {
+		name     string
+		req      *http.Request
+		expected bool
+	}{
+		{"GET", get, true},
+		{"POST", post, false},
+		{"PUT Without GetBody", put, false},
+		{"DELETE With GetBody", del, true},
+	}
+	for _, c := range cases {
+		if got := retryable(c.req); got != c.expected {
+			t.Errorf("case %s: expected %v but got %v", c.name, c.expected, got)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/identity"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/requestlog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//tracerName names the tracer of proxied requests
+const tracerName = "github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/proxy"
+
+//ErrUnauthorized is returned by a Director to reject a request to a
+//service that isn't public because it has no valid session
+var ErrUnauthorized = errors.New("no valid session")
+
+//Director prepares each request to a service before it's proxied,
+//adding the identity of the user making it. It returns
+//ErrUnauthorized if the service isn't public and there is no user.
+type Director func(r *http.Request, public bool) error
+
+//Service proxies requests to one microservice's pool of upstreams
+type Service struct {
+	Config   ServiceConfig
+	Pool     *Pool
+	director Director
+	proxy    *httputil.ReverseProxy
+}
+
+//newService builds the pool and proxy for a validated service config
+func newService(config ServiceConfig, director Director) *Service {
+	targets := make([]*url.URL, len(config.Upstreams))
+	for idx, upstream := range config.Upstreams {
+		targets[idx], _ = parseUpstream(upstream)
+	}
+	strategy, _ := ParseStrategy(config.Strategy)
+	if len(config.Strategy) == 0 {
+		strategy = RoundRobin
+	}
+	pool := NewPool(config.Name, targets, strategy)
+	if len(config.HealthPath) != 0 {
+		pool.HealthPath = config.HealthPath
+	}
+
+	proxy := NewReverseProxy(pool, func(r *http.Request) {
+		for _, rewrite := range config.Rewrites {
+			if path, ok := rewrite.apply(r.URL.Path); ok {
+				r.URL.Path = path
+				r.URL.RawPath = ""
+				break
+			}
+		}
+	})
+	if config.MaxRetries != nil {
+		proxy.Transport = NewRetryTransport(pool, *config.MaxRetries)
+	}
+	return &Service{Config: config, Pool: pool, director: director, proxy: proxy}
+}
+
+//ServeHTTP proxies the request to the service within its timeout, or
+//responds with 401 if the director rejects it. The proxied request has
+//its own span, which the director can propagate to the service.
+func (s *Service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := otel.Tracer(tracerName).Start(r.Context(), "proxy "+s.Config.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("gateway.service", s.Config.Name)))
+	defer span.End()
+	//the director changes a copy of the request's headers
+	r = r.WithContext(ctx)
+	r.Header = r.Header.Clone()
+	//only the director can vouch for the user, clients can't
+	identity.Strip(r.Header)
+	if s.director != nil {
+		if err := s.director(r, s.Config.Public); err != nil {
+			if errors.Is(err, ErrUnauthorized) {
+				span.SetAttributes(attribute.Bool("gateway.unauthorized", true))
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "unauthorized", s.Config.Name)
+				return
+			}
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "director error")
+			requestlog.Logger(r.Context()).Error("Director error", "service", s.Config.Name, "error", err)
+			writeError(w, http.StatusInternalServerError, "internal server error", s.Config.Name)
+			return
+		}
+	}
+	if s.Config.Timeout.Duration > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), s.Config.Timeout.Duration)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	s.proxy.ServeHTTP(w, r)
+}
+
+//Router sends requests to the service whose prefix matches their path
+type Router struct {
+	mux      *http.ServeMux
+	services []*Service
+}
+
+//NewRouter validates the config and builds a Router for its services
+func NewRouter(config *Config, director Director) (*Router, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	router := &Router{mux: http.NewServeMux()}
+	for _, serviceConfig := range config.Services {
+		service := newService(serviceConfig, director)
+		for _, prefix := range serviceConfig.Prefixes {
+			//a prefix matches itself and every path below it
+			prefix = strings.TrimSuffix(prefix, "/")
+			if len(prefix) != 0 {
+				router.mux.Handle(prefix, service)
+			}
+			router.mux.Handle(prefix+"/", service)
+		}
+		router.services = append(router.services, service)
+	}
+	return router, nil
+}
+
+//ServeHTTP proxies the request, or responds with 404 if no service's
+//prefix matches
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+//Route returns the prefix pattern the request matches, or "" if none
+//does. Paths are too many to label metrics with, but patterns aren't.
+func (rt *Router) Route(r *http.Request) string {
+	_, pattern := rt.mux.Handler(r)
+	return pattern
+}
+
+//Services returns the router's services in config order
+func (rt *Router) Services() []*Service {
+	return rt.services
+}
+
+//Pools returns the upstream pool of each service in config order
+func (rt *Router) Pools() []*Pool {
+	pools := make([]*Pool, len(rt.services))
+	for idx, service := range rt.services {
+		pools[idx] = service.Pool
+	}
+	return pools
+}
+
+//adopt replaces the upstreams of each service with those of the old
+//router's service of the same name and URL, so they keep their state
+func (rt *Router) adopt(old *Router) {
+	for _, service := range rt.services {
+		for _, oldService := range old.services {
+			if oldService.Config.Name == service.Config.Name {
+				service.Pool.adopt(oldService.Pool)
+			}
+		}
+	}
+}
+
+//RunHealthChecks probes every service's upstreams until ctx is done
+func (rt *Router) RunHealthChecks(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, pool := range rt.Pools() {
+		wg.Add(1)
+		go func(pool *Pool) {
+			defer wg.Done()
+			pool.RunHealthChecks(ctx)
+		}(pool)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,313 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/requestlog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+//newEchoBackend returns a server that responds with its name, the
+//path it was asked for and the X-User header it got
+func newEchoBackend(t *testing.T, name string, delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DefaultHealthPath {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		io.WriteString(w, name+" "+r.URL.Path+" "+r.Header.Get("X-User"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRouter(t *testing.T) {
+	messages := newEchoBackend(t, "messages", 0)
+	summary := newEchoBackend(t, "summary", 0)
+	slow := newEchoBackend(t, "slow", time.Second)
+	zero := 0
+	config := &Config{
+		Services: []ServiceConfig{
+			{
+				Name:      "messages",
+				Upstreams: []string{messages.URL},
+				Prefixes:  []string{"/v1/channels", "/v1/messages/"},
+			},
+			{
+				Name:      "summary",
+				Upstreams: []string{summary.URL},
+				Prefixes:  []string{"/v1/summary"},
+				Public:    true,
+				Rewrites:  []Rewrite{{Prefix: "/v1/summary", Replace: "/summary"}},
+			},
+			{
+				Name:       "slow",
+				Upstreams:  []string{slow.URL},
+				Prefixes:   []string{"/v1/slow"},
+				Timeout:    Duration{50 * time.Millisecond},
+				MaxRetries: &zero,
+			},
+		},
+	}
+	router, err := NewRouter(config, func(r *http.Request, public bool) error {
+		if !public {
+			r.Header.Set("X-User", "ada")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	front := httptest.NewServer(router)
+	t.Cleanup(front.Close)
+
+	cases := []struct {
+		name     string
+		path     string
+		status   int
+		expected string
+	}{
+		{"Exact Prefix", "/v1/channels", http.StatusOK, "messages /v1/channels ada"},
+		{"Below Prefix", "/v1/channels/1/members", http.StatusOK, "messages /v1/channels/1/members ada"},
+		{"Prefix With Slash", "/v1/messages", http.StatusOK, "messages /v1/messages ada"},
+		{"Public And Rewritten", "/v1/summary?url=x", http.StatusOK, "summary /summary "},
+		{"Similar Prefix", "/v1/channelsx", http.StatusNotFound, ""},
+		{"No Service", "/v2/channels", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		status, body := get(t, front, c.path)
+		if status != c.status {
+			t.Errorf("case %s: expected status %d but got %d", c.name, c.status, status)
+			continue
+		}
+		if len(c.expected) != 0 && body != c.expected {
+			t.Errorf("case %s: expected %q but got %q", c.name, c.expected, body)
+		}
+	}
+
+	start := time.Now()
+	status, body := get(t, front, "/v1/slow")
+	if status != http.StatusGatewayTimeout {
+		t.Errorf("expected %d but got %d", http.StatusGatewayTimeout, status)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the request to be cut off at the service timeout but took %v", elapsed)
+	}
+	var errBody ErrorBody
+	if err := json.Unmarshal([]byte(body), &errBody); err != nil || errBody.Service != "slow" {
+		t.Errorf("expected a JSON error body but got %q", body)
+	}
+	if status := router.Pools()[2].Status().Upstreams[0]; status.Failures != 1 {
+		t.Errorf("expected the timeout to count against the upstream but got %+v", status)
+	}
+
+	pools := router.Pools()
+	if len(pools) != 3 || pools[0].Name != "messages" || pools[1].HealthPath != DefaultHealthPath {
+		t.Errorf("unexpected pools: %+v", pools)
+	}
+}
+
+func TestNewRouterInvalid(t *testing.T) {
+	config := &Config{Services: []ServiceConfig{{Name: "messages"}}}
+	if _, err := NewRouter(config, nil); err == nil || !strings.Contains(err.Error(), "upstream") {
+		t.Errorf("expected invalid config to be rejected but got %v", err)
+	}
+}
+
+func TestRouterStripsUser(t *testing.T) {
+	backend := newEchoBackend(t, "backend", 0)
+	config := &Config{Services: []ServiceConfig{
+		{Name: "public", Upstreams: []string{backend.URL}, Prefixes: []string{"/public"}, Public: true},
+		{Name: "private", Upstreams: []string{backend.URL}, Prefixes: []string{"/private"}},
+	}}
+	//a director that lets requests without a session through adds no user
+	router, err := NewRouter(config, func(r *http.Request, public bool) error { return nil })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	front := httptest.NewServer(router)
+	t.Cleanup(front.Close)
+
+	for _, path := range []string{"/public", "/private"} {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+path, nil)
+		req.Header.Set("X-User", "forged")
+		req.Header.Set("X-User-Signature", "forged")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "backend "+path+" " {
+			t.Errorf("path %s: expected the client's X-User to be removed but got %q", path, body)
+		}
+	}
+}
+
+func TestRouterUnauthorized(t *testing.T) {
+	backend := newTestBackend(t, "backend")
+	config := &Config{Services: []ServiceConfig{
+		{Name: "summary", Upstreams: []string{backend.URL}, Prefixes: []string{"/v1/summary"}, Public: true},
+		{Name: "messages", Upstreams: []string{backend.URL}, Prefixes: []string{"/v1/channels"}},
+		{Name: "broken", Upstreams: []string{backend.URL}, Prefixes: []string{"/v1/broken"}},
+	}}
+	router, err := NewRouter(config, func(r *http.Request, public bool) error {
+		switch {
+		case public:
+			return nil
+		case r.URL.Path == "/v1/broken":
+			return errors.New("session store unavailable")
+		case r.Header.Get("Authorization") == "Bearer valid":
+			r.Header.Set("X-User", "ada")
+			return nil
+		}
+		return ErrUnauthorized
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name          string
+		path          string
+		authorization string
+		status        int
+	}{
+		{"Public Without Session", "/v1/summary", "", http.StatusOK},
+		{"Protected Without Session", "/v1/channels", "", http.StatusUnauthorized},
+		{"Protected Invalid Session", "/v1/channels/1", "Bearer invalid", http.StatusUnauthorized},
+		{"Protected With Session", "/v1/channels", "Bearer valid", http.StatusOK},
+		{"Director Failure", "/v1/broken", "Bearer valid", http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		atomic.StoreInt32(&backend.hits, 0)
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if len(c.authorization) != 0 {
+			r.Header.Set("Authorization", c.authorization)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		if rr.Code != c.status {
+			t.Errorf("case %s: expected %d but got %d", c.name, c.status, rr.Code)
+			continue
+		}
+		if len(r.Header.Values("X-User")) != 0 {
+			t.Errorf("case %s: expected the director to change a copy of the request", c.name)
+		}
+		if c.status == http.StatusOK {
+			continue
+		}
+		if hits := atomic.LoadInt32(&backend.hits); hits != 0 {
+			t.Errorf("case %s: expected the rejected request not to be proxied", c.name)
+		}
+		var errBody ErrorBody
+		if err := json.Unmarshal(rr.Body.Bytes(), &errBody); err != nil || errBody.Status != c.status {
+			t.Errorf("case %s: expected a JSON error body but got %q", c.name, rr.Body.String())
+		}
+		if c.status == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("case %s: expected a WWW-Authenticate header", c.name)
+		}
+	}
+}
+
+func TestRouterLogsUpstream(t *testing.T) {
+	messages := newEchoBackend(t, "messages", 0)
+	config := &Config{
+		Services: []ServiceConfig{
+			{Name: "messages", Upstreams: []string{messages.URL}, Prefixes: []string{"/v1/channels"}},
+		},
+	}
+	router, err := NewRouter(config, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logs := &bytes.Buffer{}
+	handler := requestlog.NewHandler(router, slog.New(slog.NewJSONHandler(logs, nil)))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/channels", nil))
+
+	entry := struct {
+		Status   int    `json:"status"`
+		Upstream string `json:"upstream"`
+	}{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("access log entry isn't JSON: %v", err)
+	}
+	if entry.Status != http.StatusOK || entry.Upstream != messages.URL {
+		t.Errorf("expected a 200 from %s to be logged but got %+v", messages.URL, entry)
+	}
+}
+
+func TestRouterTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	var traceparent string
+	messages := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		if r.URL.Path == "/v1/channels/down" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer messages.Close()
+	config := &Config{
+		Services: []ServiceConfig{
+			{Name: "messages", Upstreams: []string{messages.URL}, Prefixes: []string{"/v1/channels"}},
+		},
+	}
+	router, err := NewRouter(config, func(r *http.Request, public bool) error {
+		propagation.TraceContext{}.Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		path   string
+		failed bool
+	}{
+		{"Success", "/v1/channels", false},
+		{"Upstream Failure", "/v1/channels/down", true},
+	}
+	for _, c := range cases {
+		ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+		req := httptest.NewRequest(http.MethodGet, c.path, nil).WithContext(ctx)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		parent.End()
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-2]
+		if span.Name() != "proxy messages" || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("case %s: expected a proxy span in the request's trace but got %s", c.name, span.Name())
+		}
+		if failed := span.Status().Code == codes.Error; failed != c.failed {
+			t.Errorf("case %s: expected the span to have failed: %t, but got %v", c.name, c.failed, span.Status())
+		}
+		//the service continues the trace from the proxy span
+		expected := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+		if traceparent != expected {
+			t.Errorf("case %s: expected traceparent %s but got %s", c.name, expected, traceparent)
+		}
+	}
+}
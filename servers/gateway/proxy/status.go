@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"sync/atomic"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/requestlog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//UpstreamStatus is the health of one upstream as reported by the admin endpoint
+type UpstreamStatus struct {
+	URL       string     `json:"url"`
+	Healthy   bool       `json:"healthy"`
+	Available bool       `json:"available"`
+	Circuit   string     `json:"circuit"`
+	Active    int64      `json:"active"`
+	Failures  int        `json:"failures"`
+	OpenUntil *time.Time `json:"openUntil,omitempty"`
+	LastCheck *time.Time `json:"lastCheck,omitempty"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+//PoolStatus is the health of one pool as reported by the admin endpoint
+type PoolStatus struct {
+	Name      string           `json:"name"`
+	Strategy  string           `json:"strategy"`
+	Upstreams []UpstreamStatus `json:"upstreams"`
+}
+
+//Status returns the current health of the pool's upstreams
+func (p *Pool) Status() PoolStatus {
+	now := p.now()
+	status := PoolStatus{
+		Name:      p.Name,
+		Strategy:  p.Strategy.String(),
+		Upstreams: make([]UpstreamStatus, len(p.upstreams)),
+	}
+	for idx, upstream := range p.upstreams {
+		upstream.mx.Lock()
+		circuit := upstream.circuit.current(now)
+		s := UpstreamStatus{
+			URL:       upstream.URL.String(),
+			Healthy:   upstream.healthy,
+			Available: upstream.healthy && upstream.circuit.allow(now),
+			Circuit:   circuit.String(),
+			Active:    atomic.LoadInt64(&upstream.active),
+			Failures:  upstream.circuit.failures,
+			LastError: upstream.lastError,
+		}
+		if circuit == CircuitOpen {
+			openUntil := upstream.circuit.openUntil
+			s.OpenUntil = &openUntil
+		}
+		if !upstream.lastCheck.IsZero() {
+			lastCheck := upstream.lastCheck
+			s.LastCheck = &lastCheck
+		}
+		upstream.mx.Unlock()
+		status.Upstreams[idx] = s
+	}
+	return status
+}
+
+//PoolLister lists the pools whose health is reported. Routers and
+//Reloaders are PoolListers.
+type PoolLister interface {
+	Pools() []*Pool
+}
+
+//PoolList is a fixed list of pools
+type PoolList []*Pool
+
+//Pools returns the pools in the list
+func (l PoolList) Pools() []*Pool {
+	return l
+}
+
+//StatusHandler responds with the health of every upstream in the
+//pools the lister has when asked
+func StatusHandler(lister PoolLister) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pools := lister.Pools()
+		statuses := make([]PoolStatus, len(pools))
+		for idx, pool := range pools {
+			statuses[idx] = pool.Status()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
+
+//ErrorBody is the JSON body of a response the gateway makes in place
+//of a service's
+type ErrorBody struct {
+	Status  int    `json:"status"`
+	Error   string `json:"error"`
+	Service string `json:"service"`
+}
+
+//NewReverseProxy returns a ReverseProxy that sends requests through
+//the pool after the director has prepared them, retrying idempotent
+//requests on other upstreams. Requests fail with a 503 when the pool
+//has no upstream to send them to, a 504 when they time out, and a 502
+//when the upstreams don't respond, all with an ErrorBody. The outcome
+//is recorded on the request's span.
+func NewReverseProxy(pool *Pool, director func(r *http.Request)) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director:  director,
+		Transport: NewRetryTransport(pool, DefaultMaxRetries),
+		ModifyResponse: func(resp *http.Response) error {
+			span := trace.SpanFromContext(resp.Request.Context())
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= 500 {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			span := trace.SpanFromContext(r.Context())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			switch {
+			case errors.Is(err, ErrNoUpstream):
+				upstreamRequests.WithLabelValues(pool.Name, "", OutcomeNoUpstream).Inc()
+				writeError(w, http.StatusServiceUnavailable, "no upstream available", pool.Name)
+			case errors.Is(err, context.DeadlineExceeded):
+				writeError(w, http.StatusGatewayTimeout, "upstream timed out", pool.Name)
+			default:
+				requestlog.Logger(r.Context()).Error("Proxy error", "service", pool.Name, "error", err)
+				writeError(w, http.StatusBadGateway, "upstream did not respond", pool.Name)
+			}
+		},
+	}
+}
+
+//writeError responds with an ErrorBody
+func writeError(w http.ResponseWriter, status int, message string, service string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorBody{Status: status, Error: message, Service: service})
+}
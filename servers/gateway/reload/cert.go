@@ -0,0 +1,44 @@
+package reload
+
+import (
+	"crypto/tls"
+	"sync/atomic"
+)
+
+//Certificate is a TLS certificate and key read from files, which can
+//be read again without restarting the server. Handshakes started
+//after a reload use the new certificate, while connections that are
+//already open carry on with the old one.
+type Certificate struct {
+	CertPath string
+	KeyPath  string
+
+	cert atomic.Pointer[tls.Certificate]
+}
+
+//NewCertificate constructs a new Certificate, reading the certificate
+//and key from the given files
+func NewCertificate(certPath string, keyPath string) (*Certificate, error) {
+	c := &Certificate{CertPath: certPath, KeyPath: keyPath}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//Reload reads the certificate and key files again. If they can't be
+//read, or don't match, the current certificate is kept.
+func (c *Certificate) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+	if err != nil {
+		return err
+	}
+	c.cert.Store(&cert)
+	return nil
+}
+
+//GetCertificate returns the current certificate. It is meant to be
+//a tls.Config's GetCertificate.
+func (c *Certificate) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
@@ -0,0 +1,113 @@
+package reload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//writeTestCert writes a self-signed certificate for localhost with
+//the given common name, returning it parsed
+func writeTestCert(t *testing.T, certPath string, keyPath string, name string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error encoding key: %v", err)
+	}
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+//servedName returns the common name of the certificate the server
+//presents to a new connection. Clients must send a server name, or
+//httptest's own certificate is served instead.
+func servedName(t *testing.T, server *httptest.Server) string {
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certPath, keyPath, "first")
+	cert, err := NewCertificate(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{GetCertificate: cert.GetCertificate}
+	server.StartTLS()
+	defer server.Close()
+	if name := servedName(t, server); name != "first" {
+		t.Fatalf("expected the first certificate but got %s", name)
+	}
+
+	//a connection made before the reload keeps its certificate
+	open, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+	defer open.Close()
+
+	writeTestCert(t, certPath, keyPath, "second")
+	if err := cert.Reload(); err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	if name := servedName(t, server); name != "second" {
+		t.Errorf("expected the reloaded certificate but got %s", name)
+	}
+	if name := open.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "first" {
+		t.Errorf("expected the open connection to keep its certificate but got %s", name)
+	}
+	if _, err := open.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")); err != nil {
+		t.Errorf("expected the open connection to stay usable but got %v", err)
+	}
+
+	//a half written certificate is rejected and the current one kept
+	os.WriteFile(certPath, []byte("-----BEGIN CERTIFICATE-----\n"), 0600)
+	if err := cert.Reload(); err == nil {
+		t.Errorf("expected error reloading an invalid certificate")
+	}
+	if name := servedName(t, server); name != "second" {
+		t.Errorf("expected the current certificate to be kept but got %s", name)
+	}
+}
+
+func TestNewCertificateMissing(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewCertificate(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")); err == nil {
+		t.Errorf("expected error for missing files")
+	}
+}
@@ -0,0 +1,55 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+//fileVersion identifies the contents of a file without reading it
+type fileVersion struct {
+	exists  bool
+	size    int64
+	modTime int64
+}
+
+//versionOf returns the current version of the file at path
+func versionOf(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{exists: true, size: info.Size(), modTime: info.ModTime().UnixNano()}
+}
+
+//Watch checks the files at paths every interval until ctx is done,
+//calling changed once for each check that finds any of them have been
+//written, replaced or removed since the last. A file being rewritten
+//may be seen half written, so changed should keep what it has if the
+//new contents are invalid; once the write finishes the next check
+//calls it again.
+func Watch(ctx context.Context, interval time.Duration, paths []string, changed func()) {
+	versions := make([]fileVersion, len(paths))
+	for idx, path := range paths {
+		versions[idx] = versionOf(path)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modified := false
+		for idx, path := range paths {
+			if version := versionOf(path); version != versions[idx] {
+				versions[idx] = version
+				modified = true
+			}
+		}
+		if modified {
+			changed()
+		}
+	}
+}
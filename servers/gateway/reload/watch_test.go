@@ -0,0 +1,60 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	watched, other := filepath.Join(dir, "gateway.json"), filepath.Join(dir, "other.json")
+	os.WriteFile(watched, []byte("{}"), 0600)
+
+	var changes int32
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		Watch(ctx, 5*time.Millisecond, []string{watched}, func() { atomic.AddInt32(&changes, 1) })
+		close(stopped)
+	}()
+
+	waitForChanges := func(expected int32) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&changes) < expected {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d changes but saw %d", expected, atomic.LoadInt32(&changes))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&changes); n != 0 {
+		t.Errorf("expected no changes before the file is written but saw %d", n)
+	}
+	os.WriteFile(other, []byte("{}"), 0600)
+	os.WriteFile(watched, []byte(`{"services": []}`), 0600)
+	waitForChanges(1)
+	//replacing the file by renaming, as editors and cert renewals do
+	os.WriteFile(other, []byte(`{"services": null}`), 0600)
+	os.Rename(other, watched)
+	waitForChanges(2)
+	os.Remove(watched)
+	waitForChanges(3)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Watch to return once its context is done")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&changes); n != 3 {
+		t.Errorf("expected 3 changes but saw %d", n)
+	}
+}
@@ -0,0 +1,129 @@
+package requestlog
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+//Handler wraps an HTTP handler, giving every request an ID and
+//writing an access log entry once it has been served
+type Handler struct {
+	//Logger writes the access log, or the default logger if nil
+	Logger *slog.Logger
+	//Observe, if set, is also called with every response, to record
+	//metrics about it
+	Observe func(r *http.Request, status int, latency time.Duration)
+
+	handler http.Handler
+	now     func() time.Time
+}
+
+//NewHandler initializes a new Handler of the given HTTP handler
+func NewHandler(handler http.Handler, logger *slog.Logger) *Handler {
+	return &Handler{Logger: logger, handler: handler, now: time.Now}
+}
+
+//ServeHTTP serves HTTP with the request's ID in its context and in the
+//X-Request-ID response header. A valid ID sent by the client is kept,
+//so it can follow the request through the services behind the gateway.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(Header)
+	if !validID(id) {
+		id = NewID()
+	}
+	entry := &Entry{ID: id}
+	w.Header().Set(Header, id)
+
+	start := h.now()
+	sw := &statusWriter{ResponseWriter: w}
+	h.handler.ServeHTTP(sw, r.WithContext(NewContext(r.Context(), entry)))
+	latency := h.now().Sub(start)
+
+	status := sw.status
+	if status == 0 {
+		//nothing was written, so net/http responds with 200
+		status = http.StatusOK
+	}
+	level := slog.LevelInfo
+	if status >= 500 {
+		level = slog.LevelError
+	}
+	attrs := append([]any{
+		slog.String("request_id", id),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", status),
+		slog.Duration("latency", latency),
+		slog.Int64("bytes", sw.bytes),
+		slog.String("remote_addr", r.RemoteAddr),
+	}, entry.attrs()...)
+	//the trace has the details of a slow or failed request
+	if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+		attrs = append(attrs, slog.String("trace_id", span.TraceID().String()))
+	}
+
+	logger := h.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Log(r.Context(), level, "request", attrs...)
+	if h.Observe != nil {
+		h.Observe(r, status, latency)
+	}
+}
+
+//statusWriter records the status and size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	//informational responses are followed by the real one
+	if sw.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(data []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(data)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+//Flush lets proxied responses be streamed
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+//Hijack lets websocket connections take over the connection, which is
+//logged as a 101 response
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && sw.status == 0 {
+		sw.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+//Unwrap returns the wrapped writer for http.ResponseController
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
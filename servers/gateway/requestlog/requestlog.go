@@ -0,0 +1,113 @@
+package requestlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+)
+
+//Header carries the ID of a request, from clients that already have one
+//and to the microservices the gateway proxies it to
+const Header = "X-Request-ID"
+
+//maxIDLength is the longest request ID accepted from a client
+const maxIDLength = 128
+
+//NewID returns a new random request ID
+func NewID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+//validID returns true if a client's request ID is safe to log and forward
+func validID(id string) bool {
+	if len(id) == 0 || len(id) > maxIDLength {
+		return false
+	}
+	for _, c := range id {
+		//printable ASCII without spaces or quotes
+		if c <= ' ' || c > '~' || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+//Entry is what the access log records about a request besides its
+//response. Handlers fill it in as they learn who made the request and
+//where it was sent.
+type Entry struct {
+	ID string
+
+	mx       sync.Mutex
+	userID   int64
+	upstream string
+}
+
+//contextKey is the type of the key an Entry is stored under in a
+//request's context
+type contextKey struct{}
+
+//NewContext returns a copy of ctx carrying the entry
+func NewContext(ctx context.Context, entry *Entry) context.Context {
+	return context.WithValue(ctx, contextKey{}, entry)
+}
+
+//FromContext returns the entry of the request ctx belongs to, or nil
+func FromContext(ctx context.Context) *Entry {
+	entry, _ := ctx.Value(contextKey{}).(*Entry)
+	return entry
+}
+
+//ID returns the ID of the request ctx belongs to, or "" if it has none
+func ID(ctx context.Context) string {
+	if entry := FromContext(ctx); entry != nil {
+		return entry.ID
+	}
+	return ""
+}
+
+//SetUser records the ID of the signed in user making the request
+func SetUser(ctx context.Context, userID int64) {
+	if entry := FromContext(ctx); entry != nil {
+		entry.mx.Lock()
+		entry.userID = userID
+		entry.mx.Unlock()
+	}
+}
+
+//SetUpstream records the upstream the request was proxied to. When a
+//request is retried, the last upstream tried is kept.
+func SetUpstream(ctx context.Context, upstream string) {
+	if entry := FromContext(ctx); entry != nil {
+		entry.mx.Lock()
+		entry.upstream = upstream
+		entry.mx.Unlock()
+	}
+}
+
+//attrs returns the user and upstream recorded for the request, if any
+func (e *Entry) attrs() []any {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+	var attrs []any
+	if e.userID != 0 {
+		attrs = append(attrs, slog.Int64("user_id", e.userID))
+	}
+	if len(e.upstream) != 0 {
+		attrs = append(attrs, slog.String("upstream", e.upstream))
+	}
+	return attrs
+}
+
+//Logger returns the default logger, with the ID of the request ctx
+//belongs to if it has one
+func Logger(ctx context.Context) *slog.Logger {
+	if id := ID(ctx); len(id) != 0 {
+		return slog.Default().With(slog.String("request_id", id))
+	}
+	return slog.Default()
+}
@@ -0,0 +1,226 @@
+package requestlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+//newTestHandler returns a Handler of the given handler whose clock
+//advances by half a second every time it is read, and the access log it writes
+func newTestHandler(handler http.HandlerFunc) (*Handler, *bytes.Buffer) {
+	logs := &bytes.Buffer{}
+	h := NewHandler(handler, slog.New(slog.NewJSONHandler(logs, nil)))
+	now := time.Now()
+	h.now = func() time.Time {
+		now = now.Add(500 * time.Millisecond)
+		return now
+	}
+	return h, logs
+}
+
+//accessLog decodes the only access log entry written
+func accessLog(t *testing.T, logs *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one access log entry but got %d: %s", len(lines), logs)
+	}
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("access log entry isn't JSON: %v", err)
+	}
+	return entry
+}
+
+func TestHandlerRequestID(t *testing.T) {
+	cases := []struct {
+		name     string
+		clientID string
+		kept     bool
+	}{
+		{"No ID", "", false},
+		{"Valid ID", "7f3c2a9e-client.id:1", true},
+		{"ID With Spaces", "not an id", false},
+		{"ID With Quotes", `id"`, false},
+		{"Non ASCII ID", "idé", false},
+		{"Too Long ID", strings.Repeat("a", maxIDLength+1), false},
+	}
+	for _, c := range cases {
+		var handlerID string
+		h, _ := newTestHandler(func(w http.ResponseWriter, r *http.Request) {
+			handlerID = ID(r.Context())
+		})
+		req := httptest.NewRequest(http.MethodGet, "/v1/channels", nil)
+		if len(c.clientID) != 0 {
+			req.Header.Set(Header, c.clientID)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if len(handlerID) == 0 {
+			t.Fatalf("case %s: expected the request to have an ID", c.name)
+		}
+		if responseID := rr.Header().Get(Header); responseID != handlerID {
+			t.Errorf("case %s: expected the response to have ID %s but got %s", c.name, handlerID, responseID)
+		}
+		if kept := handlerID == c.clientID; kept != c.kept {
+			t.Errorf("case %s: expected the client's ID to be kept: %t, but got ID %s", c.name, c.kept, handlerID)
+		}
+	}
+}
+
+func TestHandlerAccessLog(t *testing.T) {
+	h, logs := newTestHandler(func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r.Context(), 42)
+		SetUpstream(r.Context(), "http://messaging1:80")
+		SetUpstream(r.Context(), "http://messaging2:80")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/channels?q=secret", nil)
+	req.Header.Set(Header, "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	entry := accessLog(t, logs)
+	expected := map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "request",
+		"request_id": "abc",
+		"method":     http.MethodPost,
+		"path":       "/v1/channels",
+		"status":     float64(http.StatusCreated),
+		"latency":    float64(500 * time.Millisecond),
+		"bytes":      float64(len("created")),
+		"user_id":    float64(42),
+		"upstream":   "http://messaging2:80",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("expected %s %v but got %v", key, value, entry[key])
+		}
+	}
+}
+
+func TestHandlerAccessLogDefaults(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		status  float64
+		level   string
+	}{
+		{
+			"Nothing Written",
+			func(w http.ResponseWriter, r *http.Request) {},
+			http.StatusOK,
+			"INFO",
+		},
+		{
+			"Body Without Status",
+			func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) },
+			http.StatusOK,
+			"INFO",
+		},
+		{
+			"Server Error",
+			func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "failed", http.StatusBadGateway)
+			},
+			http.StatusBadGateway,
+			"ERROR",
+		},
+	}
+	for _, c := range cases {
+		h, logs := newTestHandler(c.handler)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		entry := accessLog(t, logs)
+		if entry["status"] != c.status || entry["level"] != c.level {
+			t.Errorf("case %s: expected %v at %s but got %v at %v", c.name, c.status, c.level, entry["status"], entry["level"])
+		}
+		if _, found := entry["user_id"]; found {
+			t.Errorf("case %s: expected no user without a session", c.name)
+		}
+	}
+}
+
+func TestHandlerHijack(t *testing.T) {
+	h, logs := newTestHandler(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("unexpected error hijacking: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	})
+	//a hijacked connection's handler can outlive the server
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected %d but got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+	<-done
+	if entry := accessLog(t, logs); entry["status"] != float64(http.StatusSwitchingProtocols) {
+		t.Errorf("expected a hijacked connection to be logged as %d but got %v", http.StatusSwitchingProtocols, entry["status"])
+	}
+}
+
+func TestLogger(t *testing.T) {
+	logs := &bytes.Buffer{}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Logger(r.Context()).Info("handled")
+	}), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(Header, "abc")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if !strings.Contains(logs.String(), "request_id=abc") {
+		t.Errorf("expected the handler's logs to have the request ID but got %s", logs)
+	}
+
+	//outside of a request there is no ID
+	logs.Reset()
+	Logger(req.Context()).Info("started")
+	if strings.Contains(logs.String(), "request_id") {
+		t.Errorf("expected no request ID but got %s", logs)
+	}
+}
+
+func TestHandlerAccessLogTraceID(t *testing.T) {
+	h, logs := newTestHandler(func(w http.ResponseWriter, r *http.Request) {})
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if entry := accessLog(t, logs); entry["trace_id"] != traceID.String() {
+		t.Errorf("expected trace_id %s but got %v", traceID, entry["trace_id"])
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/proxy"
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/reload"
+)
+
+//loadGatewayConfig reads the microservices to proxy from the JSON file
+//named by GATEWAYCONFIG. Without one, the messaging and summary
+//services are proxied from MESSAGESADDR and SUMMARYADDR.
+func loadGatewayConfig() (*proxy.Config, error) {
+	if path := os.Getenv("GATEWAYCONFIG"); len(path) != 0 {
+		return proxy.LoadConfig(path)
+	}
+	config := defaultGatewayConfig(
+		splitAddrs(os.Getenv("MESSAGESADDR"), "http://micro-messaging:4000"),
+		splitAddrs(os.Getenv("SUMMARYADDR"), "http://micro-summary:8080"),
+		//LBSTRATEGY is roundrobin or leastconn
+		os.Getenv("LBSTRATEGY"),
+	)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+//defaultGatewayConfig returns the config for the messaging and summary
+//services at the given addresses
+func defaultGatewayConfig(msgAddrs []string, summaryAddrs []string, strategy string) *proxy.Config {
+	return &proxy.Config{
+		Services: []proxy.ServiceConfig{
+			{
+				Name:      "messages",
+				Upstreams: msgAddrs,
+				Prefixes:  []string{"/v1/channels", "/v1/messages"},
+				Strategy:  strategy,
+			},
+			{
+				Name:      "summary",
+				Upstreams: summaryAddrs,
+				Prefixes:  []string{"/v1/summary"},
+				Public:    true,
+				Strategy:  strategy,
+			},
+		},
+	}
+}
+
+//splitAddrs splits a comma separated list of addresses, returning
+//the fallback address if the list is empty
+func splitAddrs(addrs string, fallback string) []string {
+	var split []string
+	for _, addr := range strings.Split(addrs, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) != 0 {
+			split = append(split, addr)
+		}
+	}
+	if len(split) == 0 {
+		return []string{fallback}
+	}
+	return split
+}
+
+//reloadGateway reads the gateway config and TLS certificate again,
+//keeping the current ones if the new ones are invalid. Open
+//connections, websockets included, are unaffected.
+func reloadGateway(routes *proxy.Reloader, certificate *reload.Certificate) {
+	if err := certificate.Reload(); err != nil {
+		slog.Warn("Keeping the current TLS certificate, failed to reload it", "error", err)
+	}
+	config, err := loadGatewayConfig()
+	if err == nil {
+		err = routes.Reload(config)
+	}
+	if err != nil {
+		slog.Warn("Keeping the current gateway config, failed to reload it", "error", err)
+		return
+	}
+	slog.Info("Reloaded gateway config")
+}
+
+//watchGateway reloads the gateway on SIGHUP, and when the config or
+//TLS files change if interval isn't zero
+func watchGateway(routes *proxy.Reloader, certificate *reload.Certificate, interval time.Duration) {
+	if interval > 0 {
+		paths := []string{certificate.CertPath, certificate.KeyPath}
+		if path := os.Getenv("GATEWAYCONFIG"); len(path) != 0 {
+			paths = append(paths, path)
+		}
+		go reload.Watch(context.Background(), interval, paths, func() {
+			reloadGateway(routes, certificate)
+		})
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		reloadGateway(routes, certificate)
+	}
+}
@@ -0,0 +1,36 @@
+package sessions
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//redisCommandDuration times redis commands by name and result
+var redisCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "gateway",
+	Subsystem: "redis",
+	Name:      "command_duration_seconds",
+	Help:      "Time for redis to answer each command, by command and result.",
+	Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+}, []string{"command", "result"})
+
+//InstrumentRedis times every command the client sends, including
+//those of session stores and anything else sharing the client
+func InstrumentRedis(client *redis.Client) {
+	client.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			start := time.Now()
+			err := process(cmd)
+			result := "ok"
+			//a missing key is an answer, not a failure
+			if err != nil && err != redis.Nil {
+				result = "error"
+			}
+			redisCommandDuration.WithLabelValues(cmd.Name(), result).Observe(time.Since(start).Seconds())
+			return err
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package sessions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+//observations returns how many redis commands with the name and result were timed
+func observations(t *testing.T, command string, result string) uint64 {
+	metric := &dto.Metric{}
+	observer := redisCommandDuration.WithLabelValues(command, result)
+	if err := observer.(prometheus.Metric).Write(metric); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestInstrumentRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	InstrumentRedis(client)
+	store := NewRedisStore(client, time.Hour)
+	sid := SessionID("test")
+
+	setBefore, getBefore, errorBefore := observations(t, "set", "ok"), observations(t, "get", "ok"), observations(t, "get", "error")
+	store.Save(sid, "state")
+	var state string
+	store.Get(sid, &state)
+	store.Delete(sid)
+	//a missing session is an answer from redis
+	store.Get(sid, &state)
+	server.Close()
+	store.Get(sid, &state)
+
+	cases := []struct {
+		name     string
+		command  string
+		result   string
+		expected uint64
+	}{
+		{"Save", "set", "ok", setBefore + 1},
+		{"Get And Missing Get", "get", "ok", getBefore + 2},
+		{"Get Without Redis", "get", "error", errorBefore + 1},
+	}
+	for _, c := range cases {
+		if count := observations(t, c.command, c.result); count != c.expected {
+			t.Errorf("case %s: expected %d but got %d", c.name, c.expected, count)
+		}
+	}
+}
@@ -4,8 +4,14 @@ import (
 	"errors"
 	"net/http"
 	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+//tracerName names the tracer of session lookups
+const tracerName = "github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/sessions"
+
 const headerAuthorization = "Authorization"
 const paramAuthorization = "auth"
 const schemeBearer = "Bearer "
@@ -62,11 +68,15 @@ func GetSessionID(r *http.Request, signingKey string) (SessionID, error) {
 func GetState(r *http.Request, signingKey string, store Store, sessionState interface{}) (SessionID, error) {
 	//TODO: get the SessionID from the request, and get the data
 	//associated with that SessionID from the store.
+	_, span := otel.Tracer(tracerName).Start(r.Context(), "sessions.GetState")
+	defer span.End()
 	sid, err := GetSessionID(r, signingKey)
 	if err != nil {
+		span.SetAttributes(attribute.Bool("session.found", false))
 		return InvalidSessionID, ErrNoSessionID
 	}
 	sessionState = store.Get(sid, sessionState)
+	span.SetAttributes(attribute.Bool("session.found", sessionState != ErrStateNotFound))
 	if sessionState == ErrStateNotFound {
 		return InvalidSessionID, ErrStateNotFound
 	}
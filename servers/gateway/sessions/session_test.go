@@ -1,11 +1,16 @@
 package sessions
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestSessionGetSessionID(t *testing.T) {
@@ -166,3 +171,44 @@ func TestSessionCycle(t *testing.T) {
 		t.Error("expected error when attempting to end session with no Authorization header in request")
 	}
 }
+
+func TestGetStateSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	store := NewMemStore(time.Hour, time.Minute)
+	key := "test key"
+	respRec := httptest.NewRecorder()
+	BeginSession(key, store, 100, respRec)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+
+	cases := []struct {
+		name  string
+		auth  string
+		found bool
+	}{
+		{"Valid Session", respRec.Header().Get(headerAuthorization), true},
+		{"No Session", "", false},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequestWithContext(ctx, "GET", "/", nil)
+		if len(c.auth) != 0 {
+			req.Header.Add(headerAuthorization, c.auth)
+		}
+		var state int
+		GetState(req, key, store, &state)
+
+		spans := recorder.Ended()
+		span := spans[len(spans)-1]
+		if span.Name() != "sessions.GetState" || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("case %s: expected a session lookup span in the request's trace but got %s", c.name, span.Name())
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == "session.found" && attr.Value.AsBool() != c.found {
+				t.Errorf("case %s: expected session.found %t", c.name, c.found)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/identity"
+)
+
+//loadUserSigner returns the signer of the user forwarded to the
+//microservices. XUSERKEY is a secret shared with them for HMAC
+//signatures, XUSERED25519KEY a base64 encoded Ed25519 seed or private
+//key whose public key they verify with (XUSERED25519PUBLICKEY in the
+//messaging service). Exactly one must be set.
+func loadUserSigner() (*identity.Signer, error) {
+	hmacKey := os.Getenv("XUSERKEY")
+	ed25519Key := os.Getenv("XUSERED25519KEY")
+	switch {
+	case len(hmacKey) != 0 && len(ed25519Key) != 0:
+		return nil, errors.New("only one of XUSERKEY and XUSERED25519KEY can be set")
+	case len(hmacKey) != 0:
+		if len(hmacKey) < 32 {
+			return nil, errors.New("XUSERKEY must be at least 32 bytes")
+		}
+		return identity.NewHMACSigner([]byte(hmacKey)), nil
+	case len(ed25519Key) != 0:
+		decoded, err := base64.StdEncoding.DecodeString(ed25519Key)
+		if err != nil {
+			return nil, fmt.Errorf("XUSERED25519KEY is not base64: %v", err)
+		}
+		switch len(decoded) {
+		case ed25519.SeedSize:
+			return identity.NewEd25519Signer(ed25519.NewKeyFromSeed(decoded)), nil
+		case ed25519.PrivateKeySize:
+			return identity.NewEd25519Signer(ed25519.PrivateKey(decoded)), nil
+		}
+		return nil, fmt.Errorf("XUSERED25519KEY must be %d or %d bytes", ed25519.SeedSize, ed25519.PrivateKeySize)
+	}
+	return nil, errors.New("environment variable XUSERKEY or XUSERED25519KEY not defined")
+}
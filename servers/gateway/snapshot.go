@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+)
+
+//defaultSnapshotInterval is how often the trie snapshot is rewritten
+const defaultSnapshotInterval = 10 * time.Minute
+
+//trieSnapshotter is a user store whose trie can be saved and restored
+type trieSnapshotter interface {
+	SaveTrieSnapshot(w io.Writer) error
+	LoadTrieSnapshot(r io.Reader) error
+}
+
+//loadTrie loads the user store's trie, starting from the snapshot
+//at path when there is one, and rebuilding it from scratch otherwise
+func loadTrie(store users.Store, path string) error {
+	snapshotter, ok := store.(trieSnapshotter)
+	if !ok || path == "" {
+		return store.LoadTrie()
+	}
+	file, err := os.Open(path)
+	if err == nil {
+		err = snapshotter.LoadTrieSnapshot(bufio.NewReader(file))
+		file.Close()
+		if err == nil {
+			slog.Info("Loaded user trie from snapshot", "path", path)
+			return nil
+		}
+	}
+	if !os.IsNotExist(err) {
+		slog.Warn("Ignoring trie snapshot", "path", path, "error", err)
+	}
+	return store.LoadTrie()
+}
+
+//saveTrieSnapshot replaces the snapshot at path, writing it to a
+//temporary file first so a crash never leaves a partial snapshot
+func saveTrieSnapshot(snapshotter trieSnapshotter, path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	buffered := bufio.NewWriter(tmp)
+	if err := snapshotter.SaveTrieSnapshot(buffered); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+//snapshotTriePeriodically saves a trie snapshot now and then every
+//interval, so the next start has little to replay
+func snapshotTriePeriodically(store users.Store, path string, interval time.Duration) {
+	snapshotter, ok := store.(trieSnapshotter)
+	if !ok || path == "" {
+		return
+	}
+	for {
+		if err := saveTrieSnapshot(snapshotter, path); err != nil {
+			slog.Error("Failed to save trie snapshot", "path", path, "error", err)
+		}
+		time.Sleep(interval)
+	}
+}
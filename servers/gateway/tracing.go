@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+//setupTracing installs the tracer provider the gateway's spans are
+//exported by. TRACEEXPORTER is "otlp" to send them to
+//OTEL_EXPORTER_OTLP_ENDPOINT (http://localhost:4318 by default),
+//"stdout" to print them for local testing, or empty to not record
+//them. TRACESAMPLERATIO is the fraction of new traces recorded, 1 by
+//default. W3C trace context is propagated to the services either way.
+//The returned func flushes the spans not exported yet and stops the
+//provider, and must be called before the gateway exits.
+func setupTracing() (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	noShutdown := func(ctx context.Context) error { return nil }
+
+	exporterName := os.Getenv("TRACEEXPORTER")
+	if len(exporterName) == 0 {
+		return noShutdown, nil
+	}
+	ratio := 1.0
+	if value := os.Getenv("TRACESAMPLERATIO"); len(value) != 0 {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return nil, fmt.Errorf("invalid TRACESAMPLERATIO %q", value)
+		}
+		ratio = parsed
+	}
+
+	ctx := context.Background()
+	var spanProcessor sdktrace.SpanProcessor
+	switch exporterName {
+	case "otlp":
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+		}
+		spanProcessor = sdktrace.NewBatchSpanProcessor(exporter)
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create stdout exporter: %v", err)
+		}
+		//print spans as they end, so none are lost when testing stops
+		spanProcessor = sdktrace.NewSimpleSpanProcessor(exporter)
+	default:
+		return nil, fmt.Errorf("invalid TRACEEXPORTER %q", exporterName)
+	}
+
+	//OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "gateway")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		spanProcessor.Shutdown(ctx)
+		return nil, fmt.Errorf("failed to describe the gateway for traces: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(spanProcessor),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/UW-Info-441-Winter-Quarter-2020/homework-ziyuguo716/servers/gateway/models/users"
+	"github.com/streadway/amqp"
+)
+
+//trieExchange is the fanout exchange gateway replicas share trie events on
+const trieExchange = "user_trie"
+
+//defaultReconcileInterval is how often each replica rebuilds its trie
+//from the database, to repair any events it missed
+const defaultReconcileInterval = 5 * time.Minute
+
+//rabbitTriePublisher publishes trie events to every replica's queue
+type rabbitTriePublisher struct {
+	ch *amqp.Channel
+}
+
+//PublishTrieEvent publishes the event on the trie exchange
+func (p *rabbitTriePublisher) PublishTrieEvent(event *users.TrieEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.ch.Publish(
+		trieExchange, // exchange
+		"",           // routing key, ignored by fanout exchanges
+		false,        // mandatory
+		false,        // immediate
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        body,
+		})
+}
+
+//startTrieSync keeps the store's trie consistent with the other
+//gateway replicas: it publishes this replica's changes, applies
+//everyone else's, and reconciles with the database every interval.
+//ch must only be used for trie sync.
+func startTrieSync(ch *amqp.Channel, store *users.PostgressStore, interval time.Duration) error {
+	err := ch.ExchangeDeclare(
+		trieExchange, // name
+		"fanout",     // type
+		true,         // durable
+		false,        // auto-deleted
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("error declaring trie exchange: %w", err)
+	}
+	//every replica gets its own queue, which goes away with it
+	queue, err := ch.QueueDeclare(
+		"",    // name, chosen by the server
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("error declaring trie queue: %w", err)
+	}
+	if err := ch.QueueBind(queue.Name, "", trieExchange, false, nil); err != nil {
+		return fmt.Errorf("error binding trie queue: %w", err)
+	}
+	events, err := ch.Consume(
+		queue.Name, // queue
+		"",         // consumer
+		true,       // auto-ack
+		true,       // exclusive
+		false,      // no-local
+		false,      // no-wait
+		nil,        // args
+	)
+	if err != nil {
+		return fmt.Errorf("error consuming trie events: %w", err)
+	}
+	store.Publisher = &rabbitTriePublisher{ch: ch}
+
+	go func() {
+		for d := range events {
+			event := &users.TrieEvent{}
+			if err := json.Unmarshal(d.Body, event); err != nil {
+				slog.Warn("Ignoring malformed trie event", "error", err)
+				continue
+			}
+			store.ApplyTrieEvent(event)
+		}
+		slog.Warn("Trie event consumer stopped, relying on reconciliation")
+	}()
+	go func() {
+		for range time.Tick(interval) {
+			if err := store.ReconcileTrie(); err != nil {
+				slog.Error("Failed to reconcile trie", "error", err)
+			}
+		}
+	}()
+	return nil
+}
@@ -5,10 +5,16 @@ export TLSCERT="/etc/letsencrypt/live/api.ziyuguo.me/fullchain.pem"
 export TLSKEY="/etc/letsencrypt/live/api.ziyuguo.me/privkey.pem"
 export MYSQL_ROOT_PASSWORD="mypassword"
 export SESSIONKEY="thisismykey"
+export XUSERKEY="thisismyxuserkeyforthemicroservices"
 export REDISADDR="redis:6379"
 export DSN="root:mypassword@tcp(mysql:3306)/mydb"
 export MESSAGESADDR="http://micro-messaging:4000"
 export SUMMARYADDR="http://micro-summary:8080"
+export GATEWAYCONFIG="/etc/gateway/gateway.json"
+export TRIESNAPSHOT="/var/lib/gateway/trie.snapshot"
+# listen on every interface inside the container, but only publish
+# the admin port on the host's loopback for Prometheus to scrape
+export ADMINADDR=":9090"
 
 docker network create 441network
 
@@ -46,16 +52,22 @@ docker run \
     --network 441network \
     -p 80:80 \
     -p 443:443 \
+    -p 127.0.0.1:9090:9090 \
     -v /etc/letsencrypt:/etc/letsencrypt:ro \
+    -v gateway-data:/var/lib/gateway \
     -e ADDR=$ADDR \
     -e TLSCERT=$TLSCERT \
     -e TLSKEY=$TLSKEY \
     -e SESSIONKEY=$SESSIONKEY \
+    -e XUSERKEY=$XUSERKEY \
     -e REDISADDR=$REDISADDR \
     -e MESSAGESADDR=$MESSAGESADDR \
     -e SUMMARYADDR=$SUMMARYADDR \
+    -e GATEWAYCONFIG=$GATEWAYCONFIG \
     -e MYSQL_ROOT_PASSWORD=$MYSQL_ROOT_PASSWORD \
     -e DSN=$DSN \
+    -e TRIESNAPSHOT=$TRIESNAPSHOT \
+    -e ADMINADDR=$ADMINADDR \
     $DOCKER_BUILD_NAME
 
 docker system prune --all -f
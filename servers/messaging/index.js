@@ -11,6 +11,7 @@ const {
     channelMemberHandler
 } = require("./channel");
 const { messageHandler } = require("./message");
+const { verifyUser } = require("./xuser");
 
 const Channel = mongoose.model("Channel", channelSchema);
 const Message = mongoose.model("Message", messageSchema);
@@ -69,7 +70,7 @@ Channel.findOne({ name: "general" }, function (err, result) {
 });
 
 app.use("/v1/", function (req, res, next) {
-    if (!req.get('X-User')) {
+    if (!verifyUser(req)) {
         res.status(401).send("Unauthorized");
         return
     }
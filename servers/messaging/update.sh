@@ -1,10 +1,13 @@
 export SUMMARY_BUILD_NAME="gzy123/messaging"
+# the service refuses to start without XUSERKEY, or XUSERED25519PUBLICKEY
+# when the gateway signs X-User with XUSERED25519KEY
+export XUSERKEY="thisismyxuserkeyforthemicroservices"
 
 docker rm -f micro-messaging
 
 docker pull $SUMMARY_BUILD_NAME
 
-docker run -d --name micro-messaging --network 441network -p 4000:4000 $SUMMARY_BUILD_NAME
+docker run -d --name micro-messaging --network 441network -p 4000:4000 -e XUSERKEY=$XUSERKEY $SUMMARY_BUILD_NAME
 
 
 exit
\ No newline at end of file
@@ -0,0 +1,75 @@
+"use strict";
+
+const crypto = require("crypto");
+
+//claims are accepted for 5 minutes after the gateway issues them
+const maxAge = 5 * 60;
+//and up to a minute early, in case the gateway's clock is ahead
+const maxClockSkew = 60;
+
+//loadVerifier returns the signature check matching the gateway's signer:
+//XUSERKEY is the secret shared for HMAC signatures, XUSERED25519PUBLICKEY
+//the base64 encoded public key of the gateway's XUSERED25519KEY.
+//Exactly one must be set, so the service never trusts an unsigned X-User.
+const loadVerifier = () => {
+    const hmacKey = process.env.XUSERKEY;
+    const ed25519Key = process.env.XUSERED25519PUBLICKEY;
+    if (hmacKey && ed25519Key) {
+        throw new Error("only one of XUSERKEY and XUSERED25519PUBLICKEY can be set");
+    }
+    if (hmacKey) {
+        if (Buffer.byteLength(hmacKey) < 32) {
+            throw new Error("XUSERKEY must be at least 32 bytes");
+        }
+        return {
+            alg: "hmac-sha256",
+            verify: (user, sig) => {
+                const expected = crypto.createHmac("sha256", hmacKey).update(user).digest();
+                return sig.length === expected.length && crypto.timingSafeEqual(sig, expected);
+            }
+        };
+    }
+    if (ed25519Key) {
+        const raw = Buffer.from(ed25519Key, "base64");
+        if (raw.length !== 32) {
+            throw new Error("XUSERED25519PUBLICKEY must be 32 bytes");
+        }
+        const publicKey = crypto.createPublicKey({
+            key: { kty: "OKP", crv: "Ed25519", x: raw.toString("base64url") },
+            format: "jwk"
+        });
+        return {
+            alg: "ed25519",
+            verify: (user, sig) => crypto.verify(null, Buffer.from(user), publicKey, sig)
+        };
+    }
+    throw new Error("environment variable XUSERKEY or XUSERED25519PUBLICKEY not defined");
+}
+
+const verifier = loadVerifier();
+
+//verifyUser returns true if X-User was signed by the gateway
+//within the last maxAge seconds.
+const verifyUser = (req) => {
+    const user = req.get('X-User');
+    const signature = req.get('X-User-Signature');
+    if (!user || !signature) {
+        return false;
+    }
+    const sep = signature.indexOf("=");
+    if (sep < 0 || signature.slice(0, sep) !== verifier.alg) {
+        return false;
+    }
+    try {
+        if (!verifier.verify(user, Buffer.from(signature.slice(sep + 1), "base64url"))) {
+            return false;
+        }
+        const claims = JSON.parse(Buffer.from(user, 'base64').toString());
+        const age = Date.now() / 1000 - claims.iat;
+        return age <= maxAge && age >= -maxClockSkew;
+    } catch (e) {
+        return false;
+    }
+}
+
+module.exports = { verifyUser };